@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/nsrip-dd/trace2timeline/agent"
+	"github.com/nsrip-dd/trace2timeline/convert"
+	"github.com/nsrip-dd/trace2timeline/export"
+)
+
+// runAgent implements the agent command, which continuously profiles a
+// program by capturing traces from its /debug/pprof/trace endpoint at
+// regular intervals, and sends the profiles converted from them to a sink.
+func runAgent(args []string) error {
+	fs := flag.NewFlagSet("agent", flag.ExitOnError)
+	rawURL := fs.String("url", "", "`URL` of the program's /debug/pprof/trace endpoint")
+	var cf clientFlags
+	cf.register(fs)
+	duration := fs.Duration("duration", 10*time.Second, "length of each trace")
+	interval := fs.Duration("interval", time.Minute, "how often to capture a trace")
+	profiles := fs.String("profiles", "cpu", "comma-separated `kinds` of profile to convert each trace to")
+	dir := fs.String("dir", "", "write the profiles to files in this `directory`")
+	endpoint := fs.String("otlp-endpoint", "", "send the CPU samples to the OTLP/HTTP collector at this base `URL`")
+	service := fs.String("service", "", "service name, for -otlp-endpoint, -datadog and -clickhouse-url")
+	otlpHeaders := keyValueFlag{}
+	fs.Var(otlpHeaders, "otlp-header", "`key=value` header to add to requests to -otlp-endpoint (repeatable)")
+	otlpGzip := fs.Bool("otlp-gzip", false, "gzip the requests to -otlp-endpoint")
+	otlpBatch := fs.Int("otlp-batch", 1, "send the CPU samples of this `many` traces to -otlp-endpoint in each request")
+	otlpRetries := fs.Int("otlp-retries", 3, "retry failed requests to -otlp-endpoint up to this `many` times, backing off between attempts")
+	datadog := fs.Bool("datadog", false, "upload the profiles to Datadog, through the Agent on localhost or, with $DD_API_KEY set, straight to the intake")
+	ddSite := fs.String("datadog-site", "datadoghq.com", "Datadog `site`, for -datadog")
+	ddEnv := fs.String("env", "", "env tag, for -datadog")
+	ddVersion := fs.String("version", "", "version tag, for -datadog")
+	pyroscopeURL := fs.String("pyroscope-url", "", "upload the profiles to the Pyroscope server at this base `URL`")
+	appName := fs.String("app-name", "", "application `name` for -pyroscope-url")
+	tags := keyValueFlag{}
+	fs.Var(tags, "tag", "`key=value` tag to add to the profiles uploaded to -pyroscope-url or -datadog (repeatable)")
+	pyroscopeUser := fs.String("pyroscope-user", "", "basic auth `user` for -pyroscope-url, with the password in $PYROSCOPE_PASSWORD")
+	pyroscopeTenant := fs.String("pyroscope-tenant", "", "tenant `ID` for -pyroscope-url, for multi-tenant servers")
+	clickhouseURL := fs.String("clickhouse-url", "", "insert the events of each trace into ClickHouse through the HTTP interface at this base `URL`, like http://localhost:8123")
+	clickhouseTable := fs.String("clickhouse-table", "trace_events", "`table` for -clickhouse-url, optionally qualified with its database")
+	clickhouseCreate := fs.Bool("clickhouse-create-table", false, "create the -clickhouse-table if it doesn't exist")
+	clickhouseUser := fs.String("clickhouse-user", "", "`user` for -clickhouse-url, with the password in $CLICKHOUSE_PASSWORD")
+	var pprofOpts convert.PprofOptions
+	addCompatFlags(fs, &pprofOpts)
+	fs.IntVar(&pprofOpts.CPUProfileRate, "cpu-rate", 100, "CPU profiling `rate` in Hz the traced program uses, set with runtime.SetCPUProfileRate")
+	fs.DurationVar(&pprofOpts.BreakdownInterval, "breakdown-interval", 0, "roll the breakdown of each pprof sample up into entries for each `duration`, like 10ms, for smaller profiles")
+	addFrameFlags(fs, &pprofOpts)
+	addLogFlags(fs)
+	fs.Parse(args)
+
+	if *rawURL == "" {
+		fs.Usage()
+		return usageError("-url is required")
+	}
+	if *duration <= 0 {
+		return usageError("-duration must be positive")
+	}
+	var sinks []export.Sink
+	if *dir != "" {
+		sinks = append(sinks, &export.DirSink{Dir: *dir})
+	}
+	if *endpoint != "" {
+		resource := map[string]string{}
+		if *service != "" {
+			resource["service.name"] = *service
+		}
+		otlp := &export.OTLPSink{
+			Exporter: &export.OTLPExporter{
+				Endpoint: *endpoint,
+				Headers:  otlpHeaders,
+				Gzip:     *otlpGzip,
+				Retry:    export.RetryPolicy{MaxAttempts: 1 + *otlpRetries},
+			},
+			Resource: resource,
+		}
+		if *otlpBatch > 1 {
+			sinks = append(sinks, &export.BufferedSink{Sink: otlp, Size: *otlpBatch})
+		} else {
+			sinks = append(sinks, otlp)
+		}
+	}
+	if *datadog {
+		sinks = append(sinks, &export.DatadogSink{
+			APIKey:  os.Getenv("DD_API_KEY"),
+			Site:    *ddSite,
+			Service: *service,
+			Env:     *ddEnv,
+			Version: *ddVersion,
+			Tags:    tags,
+		})
+	}
+	if *pyroscopeURL != "" {
+		if *appName == "" {
+			return usageError("-app-name is required with -pyroscope-url")
+		}
+		sinks = append(sinks, &export.PyroscopeSink{
+			ServerURL:     *pyroscopeURL,
+			AppName:       *appName,
+			Tags:          tags,
+			BasicAuthUser: *pyroscopeUser,
+			// Kept out of the command line, where other users could
+			// see it
+			BasicAuthPassword: os.Getenv("PYROSCOPE_PASSWORD"),
+			TenantID:          *pyroscopeTenant,
+		})
+	}
+	if *clickhouseURL != "" {
+		sinks = append(sinks, &export.ClickHouseSink{
+			URL:         *clickhouseURL,
+			Table:       *clickhouseTable,
+			CreateTable: *clickhouseCreate,
+			User:        *clickhouseUser,
+			Password:    os.Getenv("CLICKHOUSE_PASSWORD"),
+			Service:     *service,
+			Retry:       export.RetryPolicy{MaxAttempts: 3},
+		})
+	}
+	if len(sinks) != 1 {
+		fs.Usage()
+		return usageError("exactly one of -dir, -otlp-endpoint, -datadog, -pyroscope-url and -clickhouse-url is required")
+	}
+	sink := sinks[0]
+	client, err := cf.client(*duration)
+	if err != nil {
+		return err
+	}
+
+	a := &agent.Agent{
+		Source:   &agent.Remote{URL: *rawURL, Headers: cf.headers, Client: client},
+		Sink:     sink,
+		Duration: *duration,
+		Interval: *interval,
+		Profiles: strings.Split(*profiles, ","),
+		Pprof:    pprofOpts,
+		Logf: func(format string, args ...any) {
+			slog.Warn(fmt.Sprintf(format, args...))
+		},
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if err := a.Run(ctx); err != nil && !errors.Is(err, context.Canceled) {
+		return err
+	}
+	return nil
+}
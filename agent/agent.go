@@ -0,0 +1,210 @@
+// Package agent continuously profiles a program by capturing execution
+// traces from it at regular intervals, converting them to profiles, and
+// sending those to a sink.
+package agent
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"runtime/pprof"
+	"runtime/trace"
+	"strconv"
+	"time"
+
+	"github.com/nsrip-dd/trace2timeline/convert"
+	"github.com/nsrip-dd/trace2timeline/export"
+)
+
+// Source captures execution traces.
+type Source interface {
+	// Capture traces the program for d, and returns the trace and the
+	// wall clock time at which tracing started.
+	Capture(ctx context.Context, d time.Duration) ([]byte, time.Time, error)
+}
+
+// Self captures traces of the current process. It runs the CPU profiler
+// while tracing, unless something else already is, so that the traces have
+// CPU samples.
+type Self struct{}
+
+func (Self) Capture(ctx context.Context, d time.Duration) ([]byte, time.Time, error) {
+	buf := new(bytes.Buffer)
+	start := time.Now()
+	if err := trace.Start(buf); err != nil {
+		return nil, start, err
+	}
+	if err := pprof.StartCPUProfile(io.Discard); err == nil {
+		defer pprof.StopCPUProfile()
+	}
+	select {
+	case <-time.After(d):
+	case <-ctx.Done():
+	}
+	trace.Stop()
+	return buf.Bytes(), start, ctx.Err()
+}
+
+// Remote captures traces from a /debug/pprof/trace endpoint, as served by
+// net/http/pprof.
+type Remote struct {
+	// URL is the endpoint's URL. The seconds query parameter is set for
+	// each capture.
+	URL string
+	// Headers are added to each request, e.g. for authentication.
+	Headers map[string]string
+	// Client is used to send requests. If nil, http.DefaultClient is used.
+	Client *http.Client
+}
+
+func (r *Remote) Capture(ctx context.Context, d time.Duration) ([]byte, time.Time, error) {
+	u, err := url.Parse(r.URL)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	q := u.Query()
+	q.Set("seconds", strconv.FormatFloat(d.Seconds(), 'f', -1, 64))
+	u.RawQuery = q.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	for k, v := range r.Headers {
+		req.Header.Set(k, v)
+	}
+	client := r.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	// The server starts tracing as soon as it gets the request
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, start, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, start, fmt.Errorf("fetching %s: %s: %s", u, resp.Status, bytes.TrimSpace(msg))
+	}
+	data, err := io.ReadAll(resp.Body)
+	return data, start, err
+}
+
+// Agent captures a trace from Source every Interval, converts it to the
+// selected Profiles, and sends them to Sink.
+type Agent struct {
+	Source Source
+	Sink   export.Sink
+	// Duration is how long each trace is, and Interval how often one is
+	// captured. An Interval shorter than Duration means traces are
+	// captured back to back.
+	Duration time.Duration
+	Interval time.Duration
+	// Profiles are the kinds of profile to convert each trace to, keys of
+	// convert.Profiles. If empty, just the CPU profile is made.
+	Profiles []string
+	// Pprof configures the pprof encoding of the profiles.
+	Pprof convert.PprofOptions
+	// Logf, if not nil, is called to report failed rounds, which don't
+	// stop the agent.
+	Logf func(format string, args ...any)
+}
+
+// Run captures, converts and sends traces until ctx is done. Then, if the
+// sink holds on to batches to send them together, like an
+// export.BufferedSink, it sends the rest. It returns an error straight away
+// if Duration isn't positive or a profile kind is unknown.
+func (a *Agent) Run(ctx context.Context) error {
+	if a.Duration <= 0 {
+		return fmt.Errorf("trace duration must be positive, not %v", a.Duration)
+	}
+	for _, kind := range a.Profiles {
+		if _, ok := convert.Profiles[kind]; !ok {
+			return fmt.Errorf("unknown profile %q", kind)
+		}
+	}
+	ticker := time.NewTicker(max(a.Interval, a.Duration))
+	defer ticker.Stop()
+	for {
+		if err := a.Once(ctx); err != nil && ctx.Err() == nil && a.Logf != nil {
+			a.Logf("capturing trace: %v", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			a.flush(ctx)
+			return ctx.Err()
+		}
+	}
+}
+
+// flushTimeout is how long the agent waits for its sink to send the
+// batches it holds when it stops
+const flushTimeout = 30 * time.Second
+
+func (a *Agent) flush(ctx context.Context) {
+	f, ok := a.Sink.(interface{ Flush(context.Context) error })
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), flushTimeout)
+	defer cancel()
+	if err := f.Flush(ctx); err != nil && a.Logf != nil {
+		a.Logf("sending remaining traces: %v", err)
+	}
+}
+
+// Once captures, converts and sends one trace.
+func (a *Agent) Once(ctx context.Context) error {
+	data, start, err := a.Source.Capture(ctx, a.Duration)
+	if err != nil {
+		return err
+	}
+	b, err := newBatch(data, start, time.Time{}, a.Profiles, a.Pprof)
+	if err != nil {
+		return err
+	}
+	return a.Sink.Send(ctx, b)
+}
+
+// newBatch converts the trace to the given kinds of profiles, or just a CPU
+// profile if there are none. The trace started at the wall clock time
+// start, or if that's zero, ended at end.
+func newBatch(data []byte, start, end time.Time, profiles []string, opts convert.PprofOptions) (*export.Batch, error) {
+	res, err := convert.ParseBytes(data, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	if start.IsZero() {
+		start = end.Add(-convert.Duration(res))
+	}
+	b := &export.Batch{
+		Start: start,
+		End:   start.Add(convert.Duration(res)),
+		Trace: res,
+	}
+	if len(profiles) == 0 {
+		profiles = []string{"cpu"}
+	}
+	for _, kind := range profiles {
+		write, ok := convert.Profiles[kind]
+		if !ok {
+			return nil, fmt.Errorf("unknown profile %q", kind)
+		}
+		buf := new(bytes.Buffer)
+		gz := gzip.NewWriter(buf)
+		if err := write(res, b.Start, b.End, opts, gz); err != nil {
+			return nil, fmt.Errorf("converting %s profile: %w", kind, err)
+		}
+		if err := gz.Close(); err != nil {
+			return nil, err
+		}
+		b.Profiles = append(b.Profiles, export.Profile{Kind: kind, Data: buf.Bytes()})
+	}
+	return b, nil
+}
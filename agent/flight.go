@@ -0,0 +1,27 @@
+package agent
+
+import (
+	"bytes"
+	"runtime/trace"
+	"time"
+
+	"github.com/nsrip-dd/trace2timeline/convert"
+	"github.com/nsrip-dd/trace2timeline/export"
+)
+
+// Snapshot converts the recent execution held by a running flight recorder
+// to the given kinds of profiles, or just a CPU profile if there are none.
+// It's meant to be called when the program notices something going wrong,
+// such as a slow request, to see what it was doing in the moments before.
+// The batch can be sent to a sink, or its profiles saved.
+//
+// Like any trace, the flight recorder's only has CPU samples while the CPU
+// profiler is running.
+func Snapshot(fr *trace.FlightRecorder, profiles []string, opts convert.PprofOptions) (*export.Batch, error) {
+	buf := new(bytes.Buffer)
+	if _, err := fr.WriteTo(buf); err != nil {
+		return nil, err
+	}
+	// The window ends as the snapshot is taken
+	return newBatch(buf.Bytes(), time.Time{}, time.Now(), profiles, opts)
+}
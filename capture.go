@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/nsrip-dd/trace2timeline/agent"
+	"github.com/nsrip-dd/trace2timeline/convert"
+)
+
+// runCapture implements the capture command, which fetches an execution
+// trace from a running program's /debug/pprof/trace endpoint, as served by
+// net/http/pprof, and converts it.
+func runCapture(args []string) error {
+	fs := flag.NewFlagSet("capture", flag.ExitOnError)
+	rawURL := fs.String("url", "", "`URL` of the program's /debug/pprof/trace endpoint")
+	seconds := fs.Int("seconds", 5, "how many `seconds` to trace for")
+	output := fs.String("o", "", "output `file`, or - for stdout, or an s3://, gs:// or azblob:// object URL")
+	save := fs.String("save", "", "also save the raw trace to this `file`")
+	var cf clientFlags
+	cf.register(fs)
+	opts := outputOptions{}
+	fs.StringVar(&opts.format, "format", "pprof", "output `format`: "+strings.Join(formats, ", "))
+	fs.StringVar(&opts.profile, "profile", "cpu", "`kind` of profile for pprof output: "+strings.Join(slices.Sorted(maps.Keys(convert.Profiles)), ", "))
+	addCompatFlags(fs, &opts.pprof)
+	fs.IntVar(&opts.pprof.CPUProfileRate, "cpu-rate", 100, "CPU profiling `rate` in Hz the traced program used, set with runtime.SetCPUProfileRate")
+	fs.DurationVar(&opts.pprof.BreakdownInterval, "breakdown-interval", 0, "roll the breakdown of each pprof sample up into entries for each `duration`, like 10ms, for smaller profiles")
+	addFrameFlags(fs, &opts.pprof)
+	var nameOpts convert.NameOptions
+	addNameFlags(fs, &nameOpts)
+	maxDepth := fs.Int("max-stack-depth", 0, "truncate stacks deeper than this many `frames`, keeping the frames nearest the leaf (0 for no limit)")
+	fs.Var(compressionFlag{&opts.compression}, "compress", "output `compression`: gzip, zstd or none, optionally with a level like gzip:9 (default gzip for pprof, none otherwise)")
+	fs.BoolVar(&opts.validate, "validate", false, "decode pprof output again and check that it's well-formed before writing it")
+	fs.DurationVar(&opts.pauseThreshold, "pause-threshold", defaultPauseThreshold, "mark stop-the-world pauses longer than this `duration` as anomalies in json, chrome and timeline output (0 to mark none)")
+	addLogFlags(fs)
+	fs.Parse(args)
+
+	if *rawURL == "" || *output == "" {
+		fs.Usage()
+		return usageError("both -url and -o are required")
+	}
+	if *seconds <= 0 {
+		return usageError("-seconds must be positive")
+	}
+	if err := opts.check(); err != nil {
+		return err
+	}
+	u, err := url.Parse(*rawURL)
+	if err != nil {
+		return err
+	}
+	duration := time.Duration(*seconds) * time.Second
+	client, err := cf.client(duration)
+	if err != nil {
+		return err
+	}
+
+	src := &agent.Remote{URL: *rawURL, Headers: cf.headers, Client: client}
+	data, start, err := src.Capture(context.Background(), duration)
+	if err != nil {
+		return err
+	}
+	if *save != "" {
+		if err := os.WriteFile(*save, data, 0o644); err != nil {
+			return err
+		}
+	}
+	res, err := convert.ParseBytes(data, "", opts.keep())
+	if err != nil {
+		return fmt.Errorf("parsing trace from %s: %w", u.Host, err)
+	}
+	convert.SimplifyNames(res, nameOpts)
+	convert.TruncateStacks(res, *maxDepth)
+	stop := start.Add(convert.Duration(res))
+	opts.title = u.Host
+	return writeFile(*output, res, start, stop, opts)
+}
+
+// clientFlags are the flags which configure the HTTP client for fetching
+// traces from a program.
+type clientFlags struct {
+	headers           keyValueFlag
+	caCert, cert, key string
+	insecure          bool
+}
+
+func (f *clientFlags) register(fs *flag.FlagSet) {
+	f.headers = keyValueFlag{}
+	fs.Var(f.headers, "header", "`key=value` header to add to requests, e.g. for authentication (repeatable)")
+	fs.StringVar(&f.caCert, "cacert", "", "PEM `file` with the CA certificates to verify the server with, instead of the system's")
+	fs.StringVar(&f.cert, "cert", "", "PEM client certificate `file`, for servers which require one")
+	fs.StringVar(&f.key, "key", "", "PEM `file` with the private key for -cert")
+	fs.BoolVar(&f.insecure, "insecure", false, "don't verify the server's TLS certificate")
+}
+
+// client returns an HTTP client for fetching traces of the given duration.
+func (f *clientFlags) client(duration time.Duration) (*http.Client, error) {
+	if (f.cert == "") != (f.key == "") {
+		return nil, usageError("-cert and -key must be used together")
+	}
+	tlsConfig := &tls.Config{InsecureSkipVerify: f.insecure}
+	if f.caCert != "" {
+		pem, err := os.ReadFile(f.caCert)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", f.caCert)
+		}
+	}
+	if f.cert != "" {
+		pair, err := tls.LoadX509KeyPair(f.cert, f.key)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{pair}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	// Allow for the trace to take a while to send once it's done
+	return &http.Client{Transport: transport, Timeout: duration + time.Minute}, nil
+}
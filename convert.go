@@ -0,0 +1,424 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/pprof/profile"
+	"github.com/nsrip-dd/trace2timeline/convert"
+)
+
+// runConvert implements the convert command, which reads an
+// already-captured execution trace from disk and writes the converted
+// output. Given several traces, it merges them into one.
+func runConvert(args []string) error {
+	fs := flag.NewFlagSet("convert", flag.ExitOnError)
+	var inputs stringsFlag
+	fs.Var(&inputs, "i", "execution trace `file` to convert, or - for stdin (repeat to merge several traces)")
+	output := fs.String("o", "", "output `file`, or - for stdout, or an s3://, gs:// or azblob:// object URL")
+	binary := fs.String("binary", "", "binary which produced the trace, for the pprof mapping and for symbolizing with its debug info (required for traces from Go 1.6 and below)")
+	cpuProfile := fs.String("cpuprofile", "", "CPU profile `file` collected at the same time as the trace, to copy pprof labels from")
+	var startFlag, endFlag timeFlag
+	fs.Var(&startFlag, "start", "only convert events after this `time`, an offset from the start of the trace like 1m30s or an RFC 3339 wall clock time")
+	fs.Var(&endFlag, "end", "only convert events before this `time`, in the same form as -start")
+	var goroutines, excludeGoroutines idListFlag
+	fs.Var(&goroutines, "goroutines", "only convert events for these goroutine `IDs`, separated by commas")
+	fs.Var(&excludeGoroutines, "exclude-goroutines", "don't convert events for these goroutine `IDs`, separated by commas")
+	excludeRuntime := fs.Bool("exclude-runtime", false, "don't convert events for the runtime's background goroutines, like GC workers, or CPU samples taken outside of any goroutine")
+	task := fs.String("task", "", "only convert events on goroutines while they were in regions of the user task with this `name or ID`, or its subtasks")
+	focus := fs.String("focus", "", "only keep samples with a function matching this `regexp` in their stack")
+	ignore := fs.String("ignore", "", "drop samples with a function matching this `regexp` in their stack")
+	window := fs.Duration("window", 0, "split the output into one file per `duration` of the trace, named like the -o file with _000, _001, ... before the extension")
+	opts := outputOptions{}
+	fs.StringVar(&opts.format, "format", "pprof", "output `format`: "+strings.Join(formats, ", "))
+	fs.StringVar(&opts.profile, "profile", "cpu", "`kind` of profile for pprof output: "+strings.Join(slices.Sorted(maps.Keys(convert.Profiles)), ", "))
+	addCompatFlags(fs, &opts.pprof)
+	fs.IntVar(&opts.pprof.CPUProfileRate, "cpu-rate", 100, "CPU profiling `rate` in Hz the traced program used, set with runtime.SetCPUProfileRate")
+	fs.DurationVar(&opts.pprof.BreakdownInterval, "breakdown-interval", 0, "roll the breakdown of each pprof sample up into entries for each `duration`, like 10ms, for smaller profiles")
+	addFrameFlags(fs, &opts.pprof)
+	var nameOpts convert.NameOptions
+	addNameFlags(fs, &nameOpts)
+	maxDepth := fs.Int("max-stack-depth", 0, "truncate stacks deeper than this many `frames`, keeping the frames nearest the leaf (0 for no limit)")
+	fs.Var(compressionFlag{&opts.compression}, "compress", "output `compression`: gzip, zstd or none, optionally with a level like gzip:9 (default gzip for pprof, none otherwise)")
+	fs.BoolVar(&opts.validate, "validate", false, "decode pprof output again and check that it's well-formed before writing it")
+	fs.DurationVar(&opts.pauseThreshold, "pause-threshold", defaultPauseThreshold, "mark stop-the-world pauses longer than this `duration` as anomalies in json, chrome and timeline output (0 to mark none)")
+	addLogFlags(fs)
+	fs.Parse(args)
+
+	if len(inputs) == 0 || *output == "" {
+		fs.Usage()
+		return usageError("both -i and -o are required")
+	}
+	if len(inputs) > 1 && *cpuProfile != "" {
+		return usageError("-cpuprofile can only be used with a single -i")
+	}
+	if stdin := slices.Index(inputs, "-"); stdin >= 0 && slices.Contains(inputs[stdin+1:], "-") {
+		return usageError("stdin can only be read once")
+	}
+	if *window > 0 && *output == "-" {
+		return usageError("-window writes several files, and can't be used with -o -")
+	}
+	if err := opts.check(); err != nil {
+		return err
+	}
+	if opts.format == "ndjson" && len(inputs) == 1 && onlyFlagsSet(fs, "i", "o", "format", "compress", "v", "q") {
+		return streamFile(inputs[0], *output, opts)
+	}
+	if *binary != "" {
+		m, err := convert.BinaryMapping(*binary)
+		if err != nil {
+			return err
+		}
+		opts.pprof.Mapping = &m
+	}
+	var filter convert.Filter
+	var err error
+	if filter.Focus, err = compileFlag("focus", *focus); err != nil {
+		return err
+	}
+	if filter.Ignore, err = compileFlag("ignore", *ignore); err != nil {
+		return err
+	}
+
+	var traces []convert.ParseResult
+	var starts []time.Time
+	var names []string
+	for _, input := range inputs {
+		res, start, err := parseFile(input, *binary, opts.keep())
+		if err != nil {
+			return err
+		}
+		traces = append(traces, res)
+		starts = append(starts, start)
+		names = append(names, displayName(input))
+	}
+	res, traceStart := traces[0], starts[0]
+	if len(traces) > 1 {
+		res, traceStart = convert.Merge(traces, starts)
+	}
+	if *binary != "" {
+		if err := convert.SymbolizeDWARF(res, *binary); err != nil {
+			slog.Warn("not using the binary's debug info", "err", err)
+		}
+	}
+	if *cpuProfile != "" {
+		if err := joinCPUProfile(res, *cpuProfile); err != nil {
+			return err
+		}
+	}
+	convert.SimplifyNames(res, nameOpts)
+	convert.TruncateStacks(res, *maxDepth)
+	filter.Start = startFlag.resolve(traceStart)
+	filter.End = endFlag.resolve(traceStart)
+	filter.Goroutines = goroutines
+	filter.ExcludeGoroutines = excludeGoroutines
+	filter.ExcludeRuntime = *excludeRuntime
+	if *task != "" {
+		if len(convert.TaskIDs(convert.UserAnnotations(res), *task)) == 0 {
+			return fmt.Errorf("no task %q in %s", *task, strings.Join(inputs, ", "))
+		}
+		filter.Task = *task
+	}
+	opts.title = strings.Join(names, ", ")
+
+	if *window <= 0 {
+		filtered := filter.Apply(res)
+		start := traceStart.Add(convert.Offset(res, filtered))
+		stop := start.Add(convert.Duration(filtered))
+		return writeFile(*output, filtered, start, stop, opts)
+	}
+	return filter.Windows(res, *window, func(i int, from, to time.Duration, windowed convert.ParseResult) error {
+		return writeFile(windowName(*output, i), windowed, traceStart.Add(from), traceStart.Add(to), opts)
+	})
+}
+
+// parseFile parses the named trace file, or stdin if the name is "-",
+// keeping the events as convert.ParseFunc does. It also returns the wall
+// clock time at which the trace started.
+func parseFile(name, binary string, keep func(*convert.Event) bool) (convert.ParseResult, time.Time, error) {
+	f := os.Stdin
+	if name != "-" {
+		var err error
+		if f, err = os.Open(name); err != nil {
+			return convert.ParseResult{}, time.Time{}, err
+		}
+		defer f.Close()
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return convert.ParseResult{}, time.Time{}, err
+	}
+	var res convert.ParseResult
+	if info.Mode().IsRegular() {
+		// Parse the file's mapped bytes rather than reading it into
+		// another buffer. The parsed events don't refer to them.
+		var data []byte
+		var unmap func() error
+		data, unmap, err = mapFile(f, info.Size())
+		if err != nil {
+			return convert.ParseResult{}, time.Time{}, err
+		}
+		res, err = convert.ParseBytes(data, binary, keep)
+		unmap()
+	} else {
+		res, err = convert.ParseFunc(f, binary, keep)
+	}
+	if err != nil {
+		return convert.ParseResult{}, time.Time{}, fmt.Errorf("parsing %s: %w", displayName(name), err)
+	}
+	// The trace doesn't record wall clock time, so assume it was written
+	// out right as tracing stopped. A trace read from a pipe was
+	// presumably written just now.
+	end := time.Now()
+	if info.Mode().IsRegular() {
+		end = info.ModTime()
+	}
+	return res, end.Add(-convert.Duration(res)), nil
+}
+
+// onlyFlagsSet reports whether the flags set on the command line are all
+// among names.
+func onlyFlagsSet(fs *flag.FlagSet, names ...string) bool {
+	only := true
+	fs.Visit(func(f *flag.Flag) {
+		if !slices.Contains(names, f.Name) {
+			only = false
+		}
+	})
+	return only
+}
+
+// streamFile converts the named trace file, or stdin if the name is "-", to
+// JSON Lines as it's parsed, and writes it to the named output. Nothing
+// needs the whole trace, so it isn't held in memory.
+func streamFile(input, output string, opts outputOptions) error {
+	f := os.Stdin
+	if input != "-" {
+		var err error
+		if f, err = os.Open(input); err != nil {
+			return err
+		}
+		defer f.Close()
+	}
+	out, err := createOutput(output)
+	if err != nil {
+		return err
+	}
+	err = compressed(out, opts, func(w io.Writer) error {
+		return convert.StreamJSONLines(f, "", w)
+	})
+	if err != nil {
+		discard(out)
+		return fmt.Errorf("converting %s: %w", displayName(input), err)
+	}
+	return out.Close()
+}
+
+// displayName returns the name of an input file for messages and titles.
+func displayName(name string) string {
+	if name == "-" {
+		return "stdin"
+	}
+	return filepath.Base(name)
+}
+
+// writeFile writes the parsed trace to the named output, like writeOutput.
+// See createOutput for the names outputs can have.
+func writeFile(name string, res convert.ParseResult, start, stop time.Time, opts outputOptions) error {
+	out, err := createOutput(name)
+	if err != nil {
+		return err
+	}
+	if err := writeOutput(out, res, start, stop, opts); err != nil {
+		discard(out)
+		return err
+	}
+	return out.Close()
+}
+
+// windowName returns the name of the output file for the i'th window of the
+// trace, given the -o file name: profile.pprof becomes profile_000.pprof.
+func windowName(output string, i int) string {
+	base, ext := splitExt(output)
+	return fmt.Sprintf("%s_%03d%s", base, i, ext)
+}
+
+// compileFlag compiles the value of the named regexp flag, if it was set.
+func compileFlag(name, value string) (*regexp.Regexp, error) {
+	if value == "" {
+		return nil, nil
+	}
+	re, err := regexp.Compile(value)
+	if err != nil {
+		return nil, usageError(fmt.Sprintf("invalid -%s: %v", name, err))
+	}
+	return re, nil
+}
+
+// joinCPUProfile copies the pprof labels from the CPU profile in the named
+// file onto the trace's CPU samples.
+func joinCPUProfile(res convert.ParseResult, name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	prof, err := profile.Parse(f)
+	if err != nil {
+		return fmt.Errorf("parsing %s: %w", name, err)
+	}
+	joined := convert.JoinCPUProfileLabels(res, prof)
+	if joined.Labeled == 0 && joined.Ambiguous == 0 {
+		slog.Warn("no trace samples matched the CPU profile's samples", "file", name)
+	}
+	if joined.Ambiguous > 0 {
+		slog.Warn("left trace samples unlabeled whose stacks ran under several label sets in the CPU profile",
+			"file", name, "samples", joined.Ambiguous, "stacks", joined.AmbiguousStacks)
+	}
+	return nil
+}
+
+// formats are the supported output formats
+var formats = []string{"pprof", "json", "ndjson", "csv", "sqlite", "parquet", "arrow", "proto", "msgpack", "timeline-msgpack", "chrome", "perfetto", "folded", "otlp", "flamegraph", "stw", "timeline", "otlp-spans"}
+
+// outputOptions are the flags which control the conversion.
+type outputOptions struct {
+	format  string
+	profile string
+	pprof   convert.PprofOptions
+	// title names the trace, for formats which show it
+	title string
+	// compression is how to compress the output. If the format is
+	// empty, it depends on the output format.
+	compression convert.Compression
+	// validate is whether to decode pprof output again and check it
+	// before writing it
+	validate bool
+	// pauseThreshold is how long a stop-the-world pause has to be for the
+	// formats which mark them to mark it as an anomaly
+	pauseThreshold time.Duration
+}
+
+// defaultPauseThreshold is the default for -pause-threshold.
+const defaultPauseThreshold = 10 * time.Millisecond
+
+// check reports an error if the format or profile are unknown, or the
+// pprof options are out of range.
+func (o outputOptions) check() error {
+	if !slices.Contains(formats, o.format) {
+		return usageError(fmt.Sprintf("unknown output format %q", o.format))
+	}
+	if _, ok := convert.Profiles[o.profile]; !ok {
+		return usageError(fmt.Sprintf("unknown profile %q", o.profile))
+	}
+	if o.pprof.BreakdownInterval < 0 {
+		return usageError(fmt.Sprintf("negative breakdown interval %v", o.pprof.BreakdownInterval))
+	}
+	return nil
+}
+
+// keep returns the function which selects the events the output needs, for
+// convert.ParseFunc. Outputs made from just the CPU samples don't need to
+// hold the rest of the trace in memory.
+func (o outputOptions) keep() func(*convert.Event) bool {
+	switch {
+	case o.format == "folded", o.format == "flamegraph", o.format == "otlp",
+		o.format == "pprof" && o.profile == "cpu":
+		return convert.CPUEvents
+	}
+	return nil
+}
+
+// writeOutput writes the parsed trace, which covers the wall clock times
+// from start to stop, to out in the selected format and compression.
+func writeOutput(out io.Writer, res convert.ParseResult, start, stop time.Time, opts outputOptions) error {
+	return compressed(out, opts, func(out io.Writer) error {
+		return encode(out, res, start, stop, opts)
+	})
+}
+
+// encode writes the parsed trace to out in the selected format, uncompressed.
+func encode(out io.Writer, res convert.ParseResult, start, stop time.Time, opts outputOptions) error {
+	switch opts.format {
+	case "json":
+		return convert.ToJSON(res, int64(opts.pauseThreshold), out)
+	case "ndjson":
+		return convert.ToJSONLines(res, out)
+	case "csv":
+		return convert.ToCSV(res, out)
+	case "sqlite":
+		return convert.ToSQLite(res, out)
+	case "parquet":
+		return convert.ToParquet(res, out)
+	case "arrow":
+		return convert.ToArrow(res, out)
+	case "proto":
+		return convert.ToProto(res, out)
+	case "msgpack":
+		return convert.ToMsgpack(res, out)
+	case "timeline-msgpack":
+		return convert.ToTimelineMsgpack(res, start, out)
+	case "chrome":
+		return convert.ToChrome(res, int64(opts.pauseThreshold), out)
+	case "perfetto":
+		return convert.ToPerfetto(res, start, out)
+	case "folded":
+		return convert.ToFolded(res, out)
+	case "otlp":
+		return convert.ToOTLP(res, start, stop, nil, out)
+	case "timeline":
+		return convert.ToTimeline(res, start, int64(opts.pauseThreshold), out)
+	case "stw":
+		return convert.ToSTWJSON(res, start, out)
+	case "otlp-spans":
+		return convert.ToOTLPSpans(res, start, nil, out)
+	case "flamegraph":
+		return convert.ToFlamegraph(res, opts.title, out)
+	case "pprof":
+		profile := convert.Profiles[opts.profile]
+		if !opts.validate {
+			return profile(res, start, stop, opts.pprof, out)
+		}
+		buf := new(bytes.Buffer)
+		if err := profile(res, start, stop, opts.pprof, buf); err != nil {
+			return err
+		}
+		if err := convert.ValidatePprof(buf.Bytes()); err != nil {
+			return fmt.Errorf("validating %s profile: %w", opts.profile, err)
+		}
+		_, err := buf.WriteTo(out)
+		return err
+	}
+	return fmt.Errorf("unknown output format %q", opts.format)
+}
+
+// compressed calls write with a writer which compresses to out as
+// selected. By default, pprof profiles are gzip-compressed, as they usually
+// are, and the other formats aren't compressed.
+func compressed(out io.Writer, opts outputOptions, write func(w io.Writer) error) error {
+	c := opts.compression
+	if c.Format == "" {
+		c.Format = "none"
+		if opts.format == "pprof" {
+			c.Format = "gzip"
+		}
+	}
+	w, err := c.NewWriter(out)
+	if err != nil {
+		return err
+	}
+	if err := write(w); err != nil {
+		return err
+	}
+	return w.Close()
+}
@@ -0,0 +1,144 @@
+package convert
+
+import "sort"
+
+// Task is a user task created with runtime/trace.NewTask.
+type Task struct {
+	ID     uint64
+	Parent uint64
+	Name   string
+	// Start and End are timestamps in nanoseconds. Tasks created before
+	// the trace started start at the first event, and tasks which hadn't
+	// ended by the end of the trace end at the last event.
+	Start int64
+	End   int64
+	// StkID is the stack where the task was created
+	StkID uint64
+}
+
+// Region is a user region of code on a goroutine, from
+// runtime/trace.StartRegion or WithRegion.
+type Region struct {
+	// Task is the ID of the task the region belongs to, or 0 for none
+	Task uint64
+	G    uint64
+	Name string
+	// Start and End are timestamps in nanoseconds, with the same
+	// treatment of incomplete regions as Task.
+	Start int64
+	End   int64
+	// Depth is the number of regions the region is nested in on its
+	// goroutine
+	Depth int
+	// StkID is the stack where the region started
+	StkID uint64
+}
+
+// UserLog is a message logged with runtime/trace.Log.
+type UserLog struct {
+	// Task is the ID of the task the log belongs to, or 0 for none
+	Task     uint64
+	G        uint64
+	Ts       int64
+	Category string
+	Message  string
+	StkID    uint64
+}
+
+// Annotations are the user annotations in a trace.
+type Annotations struct {
+	// Tasks are ordered by start time
+	Tasks []*Task
+	// Regions are ordered by start time
+	Regions []Region
+	// Logs are ordered by time
+	Logs []UserLog
+}
+
+// UserAnnotations collects the tasks, regions and logs in the parsed trace.
+// Task 0, the background task, isn't included.
+func UserAnnotations(parsed ParseResult) Annotations {
+	var a Annotations
+	if len(parsed.Events) == 0 {
+		return a
+	}
+	first, last := parsed.Events[0].Ts, parsed.Events[len(parsed.Events)-1].Ts
+	tasks := make(map[uint64]*Task)
+	task := func(id uint64) *Task {
+		t, ok := tasks[id]
+		if !ok {
+			t = &Task{ID: id, Start: first, End: last}
+			tasks[id] = t
+		}
+		return t
+	}
+	active := make(map[uint64][]Region) // by goroutine
+	for _, ev := range parsed.Events {
+		switch ev.Type {
+		case EvUserTaskCreate:
+			t := task(ev.Args[0])
+			t.Parent = ev.Args[1]
+			t.Start = ev.Ts
+			t.StkID = ev.StkID
+			if len(ev.SArgs) > 0 {
+				t.Name = ev.SArgs[0]
+			}
+		case EvUserTaskEnd:
+			task(ev.Args[0]).End = ev.Ts
+		case EvUserRegion:
+			name := ""
+			if len(ev.SArgs) > 0 {
+				name = ev.SArgs[0]
+			}
+			regions := active[ev.G]
+			if ev.Args[1] == 0 { // start
+				active[ev.G] = append(regions, Region{
+					Task: ev.Args[0], G: ev.G, Name: name,
+					Start: ev.Ts, End: last, Depth: len(regions), StkID: ev.StkID,
+				})
+				continue
+			}
+			if n := len(regions); n > 0 {
+				r := regions[n-1]
+				r.End = ev.Ts
+				a.Regions = append(a.Regions, r)
+				active[ev.G] = regions[:n-1]
+			} else {
+				// started before the trace did
+				a.Regions = append(a.Regions, Region{
+					Task: ev.Args[0], G: ev.G, Name: name, Start: first, End: ev.Ts,
+				})
+			}
+		case EvUserLog:
+			l := UserLog{Task: ev.Args[0], G: ev.G, Ts: ev.Ts, StkID: ev.StkID}
+			if len(ev.SArgs) > 1 {
+				l.Category, l.Message = ev.SArgs[0], ev.SArgs[1]
+			}
+			a.Logs = append(a.Logs, l)
+		}
+	}
+	for _, regions := range active {
+		a.Regions = append(a.Regions, regions...)
+	}
+	delete(tasks, 0)
+	for _, t := range tasks {
+		a.Tasks = append(a.Tasks, t)
+	}
+	sort.Slice(a.Tasks, func(i, j int) bool {
+		if a.Tasks[i].Start != a.Tasks[j].Start {
+			return a.Tasks[i].Start < a.Tasks[j].Start
+		}
+		return a.Tasks[i].ID < a.Tasks[j].ID
+	})
+	sort.SliceStable(a.Regions, func(i, j int) bool {
+		ri, rj := a.Regions[i], a.Regions[j]
+		if ri.Start != rj.Start {
+			return ri.Start < rj.Start
+		}
+		if ri.G != rj.G {
+			return ri.G < rj.G
+		}
+		return ri.Depth < rj.Depth
+	})
+	return a
+}
@@ -0,0 +1,93 @@
+package convert
+
+import (
+	"io"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+)
+
+// arrowSchema is the schema of ToArrow's record batches. It has the same
+// columns as ToParquet's rows, plus the OS thread.
+var arrowSchema = arrow.NewSchema([]arrow.Field{
+	{Name: "ts", Type: arrow.PrimitiveTypes.Int64},
+	{Name: "goroutine", Type: arrow.PrimitiveTypes.Uint64},
+	{Name: "p", Type: arrow.PrimitiveTypes.Int32, Nullable: true},
+	{Name: "thread", Type: arrow.PrimitiveTypes.Int64, Nullable: true},
+	{Name: "type", Type: arrow.BinaryTypes.String},
+	{Name: "stack_id", Type: arrow.PrimitiveTypes.Uint64, Nullable: true},
+}, nil)
+
+// arrowBatch is how many rows go in each of ToArrow's record batches.
+const arrowBatch = 64 * 1024
+
+// ToArrow writes the events of a parsed trace, CPU samples included, to out
+// as Arrow record batches in the IPC file format, also known as Feather
+// version 2, which pyarrow, Polars, DuckDB and the like read without
+// converting. The columns are:
+//
+//   - ts: nanoseconds since the start of the trace
+//   - goroutine: the ID of the goroutine the event happened on, or 0
+//   - p: the ID of the P it happened on, or null if it wasn't on one
+//   - thread: the ID of the OS thread, or null if it isn't known
+//   - type: the event type, as in EventDescriptions
+//   - stack_id: the ID of the event's stack, or null if it has none
+func ToArrow(parsed ParseResult, out io.Writer) error {
+	w, err := ipc.NewFileWriter(out, ipc.WithSchema(arrowSchema))
+	if err != nil {
+		return err
+	}
+	b := array.NewRecordBuilder(memory.DefaultAllocator, arrowSchema)
+	defer b.Release()
+	ts := b.Field(0).(*array.Int64Builder)
+	goroutine := b.Field(1).(*array.Uint64Builder)
+	proc := b.Field(2).(*array.Int32Builder)
+	thread := b.Field(3).(*array.Int64Builder)
+	typ := b.Field(4).(*array.StringBuilder)
+	stack := b.Field(5).(*array.Uint64Builder)
+
+	var base int64
+	if len(parsed.Events) > 0 {
+		base = parsed.Events[0].Ts
+	}
+	rows := 0
+	flush := func() error {
+		rec := b.NewRecordBatch()
+		defer rec.Release()
+		rows = 0
+		return w.Write(rec)
+	}
+	for _, ev := range parsed.Events {
+		ts.Append(ev.Ts - base)
+		goroutine.Append(ev.G)
+		if ev.P >= 0 && ev.P < FakeP {
+			proc.Append(int32(ev.P))
+		} else {
+			proc.AppendNull()
+		}
+		if ev.M >= 0 {
+			thread.Append(ev.M)
+		} else {
+			thread.AppendNull()
+		}
+		typ.Append(EventDescriptions[ev.Type].Name)
+		if len(parsed.Stacks[ev.StkID]) > 0 {
+			stack.Append(ev.StkID)
+		} else {
+			stack.AppendNull()
+		}
+		if rows++; rows == arrowBatch {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if rows > 0 {
+		if err := flush(); err != nil {
+			return err
+		}
+	}
+	return w.Close()
+}
@@ -0,0 +1,28 @@
+package convert
+
+import (
+	"io"
+	"time"
+)
+
+// ToBlock writes a pprof profile of the time goroutines spent blocked on
+// channels, select statements and sync primitives to out, uncompressed. It's
+// the equivalent of the runtime's block profile with every event recorded,
+// limited to the time span of the trace: the sample types are
+// contentions/count and delay/nanoseconds, and the samples are the stacks
+// where goroutines blocked. The breakdown of each sample has the start time
+// and duration of each time a goroutine blocked there.
+func ToBlock(parsed ParseResult, start, stop time.Time, opts PprofOptions, out io.Writer) error {
+	contentions := valueType{"contentions", "count"}
+	b := newProfileBuilder(parsed, opts, contentions, contentions, valueType{"delay", "nanoseconds"})
+	for _, in := range GoroutineIntervals(parsed) {
+		if in.State != StateBlocked {
+			continue
+		}
+		switch in.Reason {
+		case EvGoBlockSend, EvGoBlockRecv, EvGoBlockSelect, EvGoBlockSync, EvGoBlockCond:
+			b.add(sampleKey{stkID: in.StkID}, in.Start, 1, in.Duration())
+		}
+	}
+	return b.write(start, stop, out)
+}
@@ -0,0 +1,215 @@
+package convert
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Process IDs for the Chrome trace. Each goroutine is a thread of the
+// goroutines process, and runtime-wide activity like GC goes in the runtime
+// process.
+const (
+	chromeGoroutinesPid = 1
+	chromeRuntimePid    = 2
+
+	chromeGCTid  = 1
+	chromeSTWTid = 2
+)
+
+type chromeEvent struct {
+	Name  string         `json:"name"`
+	Cat   string         `json:"cat,omitempty"`
+	Ph    string         `json:"ph"`
+	Ts    float64        `json:"ts"`
+	Dur   float64        `json:"dur,omitempty"`
+	Pid   int            `json:"pid"`
+	Tid   uint64         `json:"tid"`
+	Scope string         `json:"s,omitempty"`
+	Sf    string         `json:"sf,omitempty"`
+	Args  map[string]any `json:"args,omitempty"`
+}
+
+type chromeFrame struct {
+	Name   string `json:"name"`
+	Parent string `json:"parent,omitempty"`
+}
+
+type chromeTrace struct {
+	TraceEvents     []chromeEvent          `json:"traceEvents"`
+	StackFrames     map[string]chromeFrame `json:"stackFrames"`
+	DisplayTimeUnit string                 `json:"displayTimeUnit"`
+}
+
+// chromeStacks builds the stackFrames table of a Chrome trace, where each
+// frame refers to its caller. Frames are shared between stacks with a common
+// root.
+type chromeStacks struct {
+	frames map[string]chromeFrame
+	ids    map[chromeFrame]string
+	stacks map[uint64]string // trace stack ID to leaf frame ID
+	parsed ParseResult
+}
+
+// leaf returns the ID of the leaf frame of the given stack, or "" if there is
+// no such stack.
+func (c *chromeStacks) leaf(stkID uint64) string {
+	if id, ok := c.stacks[stkID]; ok {
+		return id
+	}
+	stk := c.parsed.Stacks[stkID]
+	parent := ""
+	// the trace stacks start at the leaf
+	for i := len(stk) - 1; i >= 0; i-- {
+		frame := chromeFrame{
+			Name:   fmt.Sprintf("%s %s:%d", stk[i].Fn, stk[i].File, stk[i].Line),
+			Parent: parent,
+		}
+		id, ok := c.ids[frame]
+		if !ok {
+			id = strconv.Itoa(len(c.ids) + 1)
+			c.ids[frame] = id
+			c.frames[id] = frame
+		}
+		parent = id
+	}
+	c.stacks[stkID] = parent
+	return parent
+}
+
+// ToChrome writes the parsed trace to out in the Chrome Trace Event format,
+// which can be loaded into chrome://tracing or Perfetto. Each goroutine gets
+// its own track, with slices for the time it spent running, runnable,
+// blocked, and in syscalls. CPU samples and trace.Log messages are instant
+// events on the track of the goroutine they came from. Stop-the-world pauses
+// longer than pauseThreshold nanoseconds are marked as anomalies, with an
+// instant event across the whole trace so they stand out.
+func ToChrome(parsed ParseResult, pauseThreshold int64, out io.Writer) error {
+	stacks := &chromeStacks{
+		frames: make(map[string]chromeFrame),
+		ids:    make(map[chromeFrame]string),
+		stacks: make(map[uint64]string),
+		parsed: parsed,
+	}
+	us := func(ns int64) float64 { return float64(ns) / 1e3 }
+
+	var events []chromeEvent
+	meta := func(name string, pid int, tid uint64, value string) {
+		events = append(events, chromeEvent{
+			Name: name, Ph: "M", Pid: pid, Tid: tid,
+			Args: map[string]any{"name": value},
+		})
+	}
+	meta("process_name", chromeGoroutinesPid, 0, "Goroutines")
+	meta("process_name", chromeRuntimePid, 0, "Runtime")
+	meta("thread_name", chromeRuntimePid, chromeGCTid, "GC")
+	meta("thread_name", chromeRuntimePid, chromeSTWTid, "STW")
+
+	named := make(map[uint64]bool)
+	for _, in := range GoroutineIntervals(parsed) {
+		if !named[in.G] {
+			named[in.G] = true
+			meta("thread_name", chromeGoroutinesPid, in.G, "G"+strconv.FormatUint(in.G, 10))
+		}
+		name := in.State.String()
+		if in.State == StateBlocked {
+			name = EventDescriptions[in.Reason].Name
+		}
+		ev := chromeEvent{
+			Name: name,
+			Cat:  in.State.String(),
+			Ph:   "X",
+			Ts:   us(in.Start),
+			Dur:  us(in.Duration()),
+			Pid:  chromeGoroutinesPid,
+			Tid:  in.G,
+			Sf:   stacks.leaf(in.StkID),
+		}
+		if in.State == StateRunning {
+			ev.Args = map[string]any{"p": in.P}
+		}
+		events = append(events, ev)
+	}
+
+	var gcStart, stwStart *Event
+	for _, ev := range parsed.Events {
+		switch ev.Type {
+		case EvCPUSample:
+			events = append(events, chromeEvent{
+				Name:  "CPU sample",
+				Cat:   "cpu",
+				Ph:    "i",
+				Scope: "t",
+				Ts:    us(ev.Ts),
+				Pid:   chromeGoroutinesPid,
+				Tid:   ev.G,
+				Sf:    stacks.leaf(ev.StkID),
+			})
+		case EvUserLog:
+			ce := chromeEvent{
+				Name:  "log",
+				Cat:   "log",
+				Ph:    "i",
+				Scope: "t",
+				Ts:    us(ev.Ts),
+				Pid:   chromeGoroutinesPid,
+				Tid:   ev.G,
+				Sf:    stacks.leaf(ev.StkID),
+			}
+			if len(ev.SArgs) > 1 {
+				if ev.SArgs[0] != "" {
+					ce.Name = ev.SArgs[0]
+				}
+				ce.Args = map[string]any{"category": ev.SArgs[0], "message": ev.SArgs[1]}
+			}
+			events = append(events, ce)
+		case EvGCStart:
+			gcStart = ev
+		case EvGCDone:
+			if gcStart != nil {
+				events = append(events, chromeEvent{
+					Name: "GC", Cat: "gc", Ph: "X",
+					Ts: us(gcStart.Ts), Dur: us(ev.Ts - gcStart.Ts),
+					Pid: chromeRuntimePid, Tid: chromeGCTid,
+				})
+				gcStart = nil
+			}
+		case EvGCSTWStart:
+			stwStart = ev
+		case EvGCSTWDone:
+			if stwStart != nil {
+				ce := chromeEvent{
+					Name: "STW", Cat: "gc", Ph: "X",
+					Ts: us(stwStart.Ts), Dur: us(ev.Ts - stwStart.Ts),
+					Pid: chromeRuntimePid, Tid: chromeSTWTid,
+				}
+				if len(stwStart.SArgs) > 0 {
+					ce.Args = map[string]any{"kind": stwStart.SArgs[0]}
+				}
+				events = append(events, ce)
+				if pauseThreshold > 0 && ev.Ts-stwStart.Ts > pauseThreshold {
+					events = append(events, chromeEvent{
+						Name: "long STW pause", Cat: "anomaly", Ph: "i", Scope: "g",
+						Ts: us(stwStart.Ts), Pid: chromeRuntimePid, Tid: chromeSTWTid,
+						Args: ce.Args,
+					})
+				}
+				stwStart = nil
+			}
+		case EvHeapAlloc, EvHeapGoal:
+			events = append(events, chromeEvent{
+				Name: EventDescriptions[ev.Type].Name, Cat: "memory", Ph: "C",
+				Ts:   us(ev.Ts),
+				Pid:  chromeRuntimePid,
+				Args: map[string]any{"bytes": ev.Args[0]},
+			})
+		}
+	}
+
+	return json.NewEncoder(out).Encode(chromeTrace{
+		TraceEvents:     events,
+		StackFrames:     stacks.frames,
+		DisplayTimeUnit: "ns",
+	})
+}
@@ -0,0 +1,115 @@
+package convert
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// compressed reports whether data starts like a gzip or zstd stream.
+func compressed(data []byte) bool {
+	return bytes.HasPrefix(data, gzipMagic) || bytes.HasPrefix(data, zstdMagic)
+}
+
+// decompress returns a reader for the trace read from r, which decompresses
+// it if it's gzip or zstd compressed. The returned function releases the
+// decompressor's resources once reading is done.
+func decompress(r io.Reader) (*bufio.Reader, func(), error) {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(len(zstdMagic))
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, nil, err
+		}
+		return bufio.NewReader(zr), func() { zr.Close() }, nil
+	case bytes.HasPrefix(magic, zstdMagic):
+		zr, err := zstd.NewReader(br, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, nil, err
+		}
+		return bufio.NewReader(zr), zr.Close, nil
+	}
+	return br, func() {}, nil
+}
+
+// Compression says how to compress an output.
+type Compression struct {
+	// Format is "gzip", "zstd", or "none".
+	Format string
+	// Level is the compression level, in the format's own terms: 1 to 9
+	// for gzip, and 1 to 22 for zstd, which is mapped onto the few levels
+	// the zstd encoder has. Zero means the format's default.
+	Level int
+}
+
+// ParseCompression parses a compression given as a format optionally
+// followed by a level, like "gzip", "zstd:3" or "none".
+func ParseCompression(s string) (Compression, error) {
+	format, level, hasLevel := strings.Cut(s, ":")
+	c := Compression{Format: format}
+	switch format {
+	case "gzip", "zstd":
+	case "none":
+		if hasLevel {
+			return c, fmt.Errorf("no level for compression none")
+		}
+	default:
+		return c, fmt.Errorf("unknown compression %q", format)
+	}
+	if hasLevel {
+		var err error
+		if c.Level, err = strconv.Atoi(level); err != nil || c.Level <= 0 {
+			return c, fmt.Errorf("invalid compression level %q", level)
+		}
+	}
+	if format == "gzip" && c.Level > gzip.BestCompression {
+		return c, fmt.Errorf("gzip compression level %d is above %d", c.Level, gzip.BestCompression)
+	}
+	return c, nil
+}
+
+func (c Compression) String() string {
+	if c.Level == 0 {
+		return c.Format
+	}
+	return fmt.Sprintf("%s:%d", c.Format, c.Level)
+}
+
+// NewWriter returns a writer which compresses to w. It must be closed to
+// finish the output.
+func (c Compression) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	switch c.Format {
+	case "gzip":
+		level := c.Level
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		return gzip.NewWriterLevel(w, level)
+	case "zstd":
+		var opts []zstd.EOption
+		if c.Level != 0 {
+			opts = append(opts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(c.Level)))
+		}
+		return zstd.NewWriter(w, opts...)
+	case "none", "":
+		return nopWriteCloser{w}, nil
+	}
+	return nil, fmt.Errorf("unknown compression %q", c.Format)
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
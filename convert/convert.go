@@ -0,0 +1,282 @@
+// Package convert converts runtime execution traces into profiles and other
+// formats.
+package convert
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/google/pprof/profile"
+)
+
+// Options configures a conversion.
+type Options struct {
+	// Binary is the path to the binary which produced the trace. It is
+	// only needed for traces produced by Go 1.6 and below, which are not
+	// symbolized.
+	Binary string
+	// Start is the wall clock time at which tracing started. The trace
+	// itself doesn't record wall clock time. If Start is zero, the trace
+	// is assumed to have ended at the time of conversion.
+	Start time.Time
+	// Profile is the kind of profile TraceToPprof writes, one of the
+	// keys of Profiles. If empty, it's a CPU profile.
+	Profile string
+	// Pprof configures the pprof encoding, for TraceToPprof.
+	Pprof PprofOptions
+	// Filter selects the part of the trace to convert.
+	Filter Filter
+	// PauseThreshold is how long a stop-the-world pause has to be for the
+	// JSON, Chrome and timeline outputs to mark it as an anomaly. If it's
+	// zero, they don't mark any.
+	PauseThreshold time.Duration
+}
+
+// ProfileFunc writes a pprof-encoded profile derived from the parsed trace
+// to out, uncompressed. The profile covers the time from start to stop.
+type ProfileFunc func(parsed ParseResult, start, stop time.Time, opts PprofOptions, out io.Writer) error
+
+// Profiles are the kinds of pprof profile which can be derived from a trace,
+// by name.
+var Profiles = map[string]ProfileFunc{
+	"cpu":    ToPprof,
+	"offcpu": ToOffCPU,
+	"block":  ToBlock,
+	"mutex":  ToMutex,
+	"sched":  ToSchedLatency,
+	"net":    ToNetWait,
+	"gc":     ToGCTime,
+	"create": ToGoCreate,
+	"stw":    ToSTW,
+}
+
+// TraceToPprof reads an execution trace from r and writes the profile
+// selected by opts.Profile to w, uncompressed. By default, that's the CPU
+// samples in the trace. See ToPprof for details of the encoding.
+func TraceToPprof(r io.Reader, w io.Writer, opts Options) error {
+	fn, err := opts.profileFunc()
+	if err != nil {
+		return err
+	}
+	res, start, stop, err := opts.parse(r, opts.keepProfile())
+	if err != nil {
+		return err
+	}
+	return fn(res, start, stop, opts.Pprof, w)
+}
+
+// TraceToProfile reads an execution trace from r and returns the profile
+// selected by opts.Profile, like TraceToPprof, decoded with the pprof
+// package. See ToProfile for what it leaves out.
+func TraceToProfile(r io.Reader, opts Options) (*profile.Profile, error) {
+	fn, err := opts.profileFunc()
+	if err != nil {
+		return nil, err
+	}
+	res, start, stop, err := opts.parse(r, opts.keepProfile())
+	if err != nil {
+		return nil, err
+	}
+	return ToProfile(fn, res, start, stop, opts.Pprof)
+}
+
+// ToProfile returns the profile fn derives from the parsed trace as a
+// *profile.Profile, which can be merged, pruned, relabeled and so on before
+// being written with its Write method. profile.Profile only has the fields
+// of the upstream profile.proto, so the profile is built as if opts.Compat
+// were set, without the Breakdown and LabelSet extensions.
+func ToProfile(fn ProfileFunc, parsed ParseResult, start, stop time.Time, opts PprofOptions) (*profile.Profile, error) {
+	opts.Compat = true
+	buf := new(bytes.Buffer)
+	if err := fn(parsed, start, stop, opts, buf); err != nil {
+		return nil, err
+	}
+	return profile.ParseData(buf.Bytes())
+}
+
+// profileFunc returns the function for opts.Profile.
+func (o Options) profileFunc() (ProfileFunc, error) {
+	if o.Profile == "" {
+		return ToPprof, nil
+	}
+	fn, ok := Profiles[o.Profile]
+	if !ok {
+		return nil, fmt.Errorf("unknown profile %q", o.Profile)
+	}
+	return fn, nil
+}
+
+// keepProfile returns the function which selects the events opts.Profile
+// needs, for ParseFunc.
+func (o Options) keepProfile() func(*Event) bool {
+	if o.Profile == "" || o.Profile == "cpu" {
+		return CPUEvents
+	}
+	return nil
+}
+
+// TraceToJSON reads an execution trace from r and writes its events to w as
+// JSON. See ToJSON for details of the encoding.
+func TraceToJSON(r io.Reader, w io.Writer, opts Options) error {
+	res, _, _, err := opts.parse(r, nil)
+	if err != nil {
+		return err
+	}
+	return ToJSON(res, int64(opts.PauseThreshold), w)
+}
+
+// TraceToChrome reads an execution trace from r and writes it to w in the
+// Chrome Trace Event format. See ToChrome for details of the encoding.
+func TraceToChrome(r io.Reader, w io.Writer, opts Options) error {
+	res, _, _, err := opts.parse(r, nil)
+	if err != nil {
+		return err
+	}
+	return ToChrome(res, int64(opts.PauseThreshold), w)
+}
+
+// TraceToCSV reads an execution trace from r and writes its events to w as
+// CSV. See ToCSV for the columns.
+func TraceToCSV(r io.Reader, w io.Writer, opts Options) error {
+	res, _, _, err := opts.parse(r, nil)
+	if err != nil {
+		return err
+	}
+	return ToCSV(res, w)
+}
+
+// TraceToSQLite reads an execution trace from r and writes it to w as a
+// SQLite database. See ToSQLite for the tables.
+func TraceToSQLite(r io.Reader, w io.Writer, opts Options) error {
+	res, _, _, err := opts.parse(r, nil)
+	if err != nil {
+		return err
+	}
+	return ToSQLite(res, w)
+}
+
+// TraceToParquet reads an execution trace from r and writes its events to w
+// as a Parquet file. See ToParquet for the columns.
+func TraceToParquet(r io.Reader, w io.Writer, opts Options) error {
+	res, _, _, err := opts.parse(r, nil)
+	if err != nil {
+		return err
+	}
+	return ToParquet(res, w)
+}
+
+// TraceToArrow reads an execution trace from r and writes its events to w
+// in the Arrow IPC file format. See ToArrow for the columns.
+func TraceToArrow(r io.Reader, w io.Writer, opts Options) error {
+	res, _, _, err := opts.parse(r, nil)
+	if err != nil {
+		return err
+	}
+	return ToArrow(res, w)
+}
+
+// TraceToProto reads an execution trace from r and writes it to w as a
+// protobuf-encoded Trace message. See ToProto and trace.proto.
+func TraceToProto(r io.Reader, w io.Writer, opts Options) error {
+	res, _, _, err := opts.parse(r, nil)
+	if err != nil {
+		return err
+	}
+	return ToProto(res, w)
+}
+
+// TraceToFolded reads an execution trace from r and writes its CPU samples
+// to w in the collapsed stack format. See ToFolded for details.
+func TraceToFolded(r io.Reader, w io.Writer, opts Options) error {
+	res, _, _, err := opts.parse(r, CPUEvents)
+	if err != nil {
+		return err
+	}
+	return ToFolded(res, w)
+}
+
+// TraceToPerfetto reads an execution trace from r and writes it to w as a
+// Perfetto protobuf trace. See ToPerfetto for details of the encoding.
+func TraceToPerfetto(r io.Reader, w io.Writer, opts Options) error {
+	res, start, _, err := opts.parse(r, nil)
+	if err != nil {
+		return err
+	}
+	return ToPerfetto(res, start, w)
+}
+
+// TraceToOTLP reads an execution trace from r and writes the CPU samples in
+// it to w as an OTLP ExportProfilesServiceRequest with the given resource
+// attributes. See ToOTLP for details of the encoding.
+func TraceToOTLP(r io.Reader, w io.Writer, resource map[string]string, opts Options) error {
+	res, start, stop, err := opts.parse(r, CPUEvents)
+	if err != nil {
+		return err
+	}
+	return ToOTLP(res, start, stop, resource, w)
+}
+
+// TraceToTimeline reads an execution trace from r and writes its
+// per-goroutine timeline to w as JSON. See Timeline for the structure.
+func TraceToTimeline(r io.Reader, w io.Writer, opts Options) error {
+	res, start, _, err := opts.parse(r, nil)
+	if err != nil {
+		return err
+	}
+	return ToTimeline(res, start, int64(opts.PauseThreshold), w)
+}
+
+// TraceToFlamegraph reads an execution trace from r and writes the CPU
+// samples in it to w as an HTML flame graph. See ToFlamegraph for details.
+func TraceToFlamegraph(r io.Reader, w io.Writer, opts Options) error {
+	res, _, _, err := opts.parse(r, CPUEvents)
+	if err != nil {
+		return err
+	}
+	return ToFlamegraph(res, "CPU flame graph", w)
+}
+
+// parse parses the trace from r, keeping the events as ParseFunc does, and
+// applies the filter. It returns the wall clock times at which the selected
+// part of the trace started and stopped.
+func (o Options) parse(r io.Reader, keep func(*Event) bool) (res ParseResult, start, stop time.Time, err error) {
+	res, err = ParseFunc(r, o.Binary, keep)
+	if err != nil {
+		return res, start, stop, err
+	}
+	start, _ = o.timeRange(res)
+	filtered := o.Filter.Apply(res)
+	start = start.Add(Offset(res, filtered))
+	return filtered, start, start.Add(Duration(filtered)), nil
+}
+
+// timeRange returns the wall clock times at which the parsed trace started
+// and stopped.
+func (o Options) timeRange(res ParseResult) (start, stop time.Time) {
+	d := Duration(res)
+	if o.Start.IsZero() {
+		stop = time.Now()
+		return stop.Add(-d), stop
+	}
+	return o.Start, o.Start.Add(d)
+}
+
+// Offset returns how long after the start of the parsed trace the filtered
+// part of it, returned by Filter.Apply, starts.
+func Offset(parsed, filtered ParseResult) time.Duration {
+	if len(parsed.Events) == 0 || len(filtered.Events) == 0 {
+		return 0
+	}
+	return time.Duration(filtered.Events[0].Ts - parsed.Events[0].Ts)
+}
+
+// Duration returns the time between the first and last events of the parsed
+// trace.
+func Duration(res ParseResult) time.Duration {
+	if len(res.Events) == 0 {
+		return 0
+	}
+	return time.Duration(res.Events[len(res.Events)-1].Ts - res.Events[0].Ts)
+}
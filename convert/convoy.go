@@ -0,0 +1,88 @@
+package convert
+
+import (
+	"cmp"
+	"slices"
+)
+
+// LockConvoy is a blocking stack at which goroutines convoyed: many of
+// them blocked on the same Mutex, RWMutex or Cond one after another, each
+// woken only to soon block there again or hand over to the next, so the
+// lock serializes them. Times are in nanoseconds.
+type LockConvoy struct {
+	// Reason is what the goroutines blocked on, as Interval.WaitReason
+	// gives it, and Stack where they blocked
+	Reason string       `json:"reason"`
+	Stack  []StackFrame `json:"stack,omitempty"`
+	// Episodes is how many separate convoys formed at the stack, and
+	// Blocks, Goroutines and Blocked add up how many times goroutines
+	// blocked in them, how many different goroutines did, and for how long
+	Episodes   int   `json:"episodes"`
+	Blocks     int   `json:"blocks"`
+	Goroutines int   `json:"goroutines"`
+	Blocked    int64 `json:"blocked_ns"`
+	// LongestStart and LongestEnd are when the longest convoy started and
+	// ended
+	LongestStart int64 `json:"longest_start_ns"`
+	LongestEnd   int64 `json:"longest_end_ns"`
+}
+
+// LockConvoys finds the convoys in the parsed trace, grouped by the stack
+// the goroutines blocked at. A convoy is a run of blocks at the same stack,
+// each starting at most gap nanoseconds after the ones before it ended,
+// by at least minGoroutines different goroutines. The stacks where the
+// goroutines spent the most time blocked in convoys come first.
+func LockConvoys(parsed ParseResult, gap int64, minGoroutines int) []LockConvoy {
+	byStack := make(map[uint64][]Interval)
+	for _, in := range GoroutineIntervals(parsed) {
+		if in.State == StateBlocked && (in.Reason == EvGoBlockSync || in.Reason == EvGoBlockCond) {
+			byStack[in.StkID] = append(byStack[in.StkID], in)
+		}
+	}
+
+	var convoys []LockConvoy
+	for stk, blocks := range byStack {
+		slices.SortFunc(blocks, func(a, b Interval) int { return cmp.Compare(a.Start, b.Start) })
+		c := LockConvoy{Reason: blocks[0].WaitReason(), Stack: jsonFrames(parsed.Stacks[stk])}
+		all := make(map[uint64]bool)
+		// add counts the run of blocks as a convoy, if enough goroutines
+		// were in it
+		add := func(run []Interval) {
+			gs := make(map[uint64]bool)
+			var end int64
+			for _, in := range run {
+				gs[in.G] = true
+				end = max(end, in.End)
+			}
+			if len(gs) < minGoroutines {
+				return
+			}
+			c.Episodes++
+			c.Blocks += len(run)
+			for _, in := range run {
+				all[in.G] = true
+				c.Blocked += in.Duration()
+			}
+			if end-run[0].Start > c.LongestEnd-c.LongestStart {
+				c.LongestStart, c.LongestEnd = run[0].Start, end
+			}
+		}
+		first, end := 0, blocks[0].End
+		for i, in := range blocks {
+			if in.Start > end+gap {
+				add(blocks[first:i])
+				first = i
+			}
+			end = max(end, in.End)
+		}
+		add(blocks[first:])
+		if c.Episodes > 0 {
+			c.Goroutines = len(all)
+			convoys = append(convoys, c)
+		}
+	}
+	slices.SortFunc(convoys, func(a, b LockConvoy) int {
+		return cmp.Or(cmp.Compare(b.Blocked, a.Blocked), cmp.Compare(b.Blocks, a.Blocks), cmp.Compare(a.LongestStart, b.LongestStart))
+	})
+	return convoys
+}
@@ -0,0 +1,175 @@
+package convert
+
+import (
+	"cmp"
+	"maps"
+	"slices"
+	"sort"
+)
+
+// CriticalPath is the chain of goroutine activity which determined how long
+// a user task took. It's found by walking back from the end of the task:
+// while a goroutine ran, or waited to run, that time is on the path. When
+// it was blocked, the path follows the goroutine which unblocked it, from
+// the moment it did so. Waits which no goroutine ended, such as for the
+// network or a timer, are on the path themselves. Times are in nanoseconds
+// since the start of the trace.
+type CriticalPath struct {
+	Task  uint64 `json:"task"`
+	Name  string `json:"name,omitempty"`
+	Start int64  `json:"start"`
+	End   int64  `json:"end"`
+	// Steps are the parts of the path, in order
+	Steps []CriticalStep `json:"steps"`
+	// Breakdown adds up the time of the steps by what they were, the most
+	// time first
+	Breakdown []CriticalShare `json:"breakdown"`
+}
+
+// CriticalStep is a stretch of time on a critical path, spent by one
+// goroutine in one state.
+type CriticalStep struct {
+	G uint64 `json:"g"`
+	// Func is the function the goroutine was started with, if known
+	Func  string `json:"func,omitempty"`
+	State string `json:"state"`
+	// Reason is why the goroutine was blocked, as Interval.WaitReason gives
+	// it
+	Reason string `json:"reason,omitempty"`
+	Start  int64  `json:"start"`
+	End    int64  `json:"end"`
+}
+
+// What describes what the step was, such as "running main.worker" or
+// "blocked (network)", for adding up similar steps.
+func (s CriticalStep) What() string {
+	switch s.State {
+	case "running":
+		if s.Func == "" {
+			return "running an unknown function"
+		}
+		return "running " + s.Func
+	case "blocked":
+		return "blocked (" + s.Reason + ")"
+	}
+	return s.State
+}
+
+// CriticalShare is the time the steps of a critical path described the
+// same way by CriticalStep.What spent.
+type CriticalShare struct {
+	What string `json:"what"`
+	Time int64  `json:"time_ns"`
+}
+
+// CriticalPaths finds the critical path of each user task in the parsed
+// trace with one of the given IDs, or of every task if ids is nil, in the
+// order the tasks started. The path starts from the goroutine which ended
+// the task, or for tasks which didn't end during the trace, the goroutine
+// of the last of the task's regions to end. Tasks with neither are left
+// out.
+func CriticalPaths(parsed ParseResult, ids []uint64) []CriticalPath {
+	if len(parsed.Events) == 0 {
+		return nil
+	}
+	base := parsed.Events[0].Ts
+	a := UserAnnotations(parsed)
+
+	type wake struct {
+		g  uint64
+		ts int64
+	}
+	// the goroutine which unblocked each goroutine at each time, and the
+	// goroutine which ended each task
+	unblockers := make(map[wake]uint64)
+	enders := make(map[uint64]uint64)
+	for _, ev := range parsed.Events {
+		switch ev.Type {
+		case EvGoUnblock:
+			unblockers[wake{ev.Args[0], ev.Ts}] = ev.G
+		case EvUserTaskEnd:
+			enders[ev.Args[0]] = ev.G
+		}
+	}
+	lastRegion := make(map[uint64]Region)
+	for _, r := range a.Regions {
+		if cur, ok := lastRegion[r.Task]; !ok || r.End > cur.End {
+			lastRegion[r.Task] = r
+		}
+	}
+	byG := make(map[uint64][]Interval)
+	for _, in := range GoroutineIntervals(parsed) {
+		byG[in.G] = append(byG[in.G], in)
+	}
+	for _, intervals := range byG {
+		sort.Slice(intervals, func(i, j int) bool { return intervals[i].Start < intervals[j].Start })
+	}
+	funcs := GoroutineFuncs(parsed)
+
+	// at returns the interval the goroutine was in just before the time
+	at := func(g uint64, ts int64) (Interval, bool) {
+		intervals := byG[g]
+		i := sort.Search(len(intervals), func(i int) bool { return intervals[i].End >= ts })
+		if i == len(intervals) || intervals[i].Start >= ts {
+			return Interval{}, false
+		}
+		return intervals[i], true
+	}
+
+	var paths []CriticalPath
+	for _, t := range a.Tasks {
+		if ids != nil && !slices.Contains(ids, t.ID) {
+			continue
+		}
+		g := enders[t.ID]
+		if r, ok := lastRegion[t.ID]; ok && g == 0 {
+			g = r.G
+		}
+		if g == 0 {
+			continue
+		}
+		path := CriticalPath{Task: t.ID, Name: t.Name, Start: t.Start - base, End: t.End - base}
+		ts := t.End
+		// jumps counts the jumps to unblocking goroutines since the walk
+		// last went back in time, to stop at cycles
+		jumps := 0
+		for ts > t.Start {
+			in, ok := at(g, ts)
+			if !ok {
+				break
+			}
+			if in.State == StateBlocked && jumps < 8 {
+				if by, ok := unblockers[wake{g, in.End}]; ok && by != 0 && in.End == ts {
+					g = by
+					jumps++
+					continue
+				}
+			}
+			step := CriticalStep{
+				G:     g,
+				Func:  funcs[g],
+				State: in.State.String(),
+				Start: max(in.Start, t.Start) - base,
+				End:   ts - base,
+			}
+			if in.State == StateBlocked {
+				step.Reason = in.WaitReason()
+			}
+			path.Steps = append(path.Steps, step)
+			ts = max(in.Start, t.Start)
+			jumps = 0
+		}
+		slices.Reverse(path.Steps)
+
+		shares := make(map[string]int64)
+		for _, s := range path.Steps {
+			shares[s.What()] += s.End - s.Start
+		}
+		for _, what := range slices.Sorted(maps.Keys(shares)) {
+			path.Breakdown = append(path.Breakdown, CriticalShare{What: what, Time: shares[what]})
+		}
+		slices.SortStableFunc(path.Breakdown, func(a, b CriticalShare) int { return cmp.Compare(b.Time, a.Time) })
+		paths = append(paths, path)
+	}
+	return paths
+}
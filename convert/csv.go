@@ -0,0 +1,75 @@
+package convert
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"strconv"
+)
+
+// csvHeader names the columns of ToCSV's output.
+var csvHeader = []string{"timestamp", "goroutine", "p", "type", "leaf_function", "stack_hash"}
+
+// ToCSV writes the events of a parsed trace, CPU samples included, to out as
+// CSV, one row per event after a header row, for spreadsheets, pandas and
+// the like. The columns are:
+//
+//   - timestamp: nanoseconds since the start of the trace
+//   - goroutine: the ID of the goroutine the event happened on, or 0
+//   - p: the ID of the P it happened on, or empty if it wasn't on one
+//   - type: the event type, as in EventDescriptions
+//   - leaf_function: the innermost function of the event's stack
+//   - stack_hash: a hash of the whole stack, in hex, which is the same for
+//     the same stack in other traces, to group events by stack
+//
+// The last two are empty for events without a stack.
+func ToCSV(parsed ParseResult, out io.Writer) error {
+	bw := bufio.NewWriter(out)
+	w := csv.NewWriter(bw)
+	w.Write(csvHeader)
+	var base int64
+	if len(parsed.Events) > 0 {
+		base = parsed.Events[0].Ts
+	}
+	hashes := make(map[uint64]string)
+	row := make([]string, len(csvHeader))
+	for _, ev := range parsed.Events {
+		row[0] = strconv.FormatInt(ev.Ts-base, 10)
+		row[1] = strconv.FormatUint(ev.G, 10)
+		row[2] = ""
+		if ev.P >= 0 && ev.P < FakeP {
+			row[2] = strconv.Itoa(ev.P)
+		}
+		row[3] = EventDescriptions[ev.Type].Name
+		row[4], row[5] = "", ""
+		if stk := parsed.Stacks[ev.StkID]; len(stk) > 0 {
+			h, ok := hashes[ev.StkID]
+			if !ok {
+				h = StackHash(stk)
+				hashes[ev.StkID] = h
+			}
+			row[4], row[5] = stk[0].Fn, h
+		}
+		if err := w.Write(row); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// StackHash returns the 64-bit FNV-1a hash, in hex, of the functions, files
+// and lines of the stack. Stack IDs are only meaningful within one trace,
+// but the hash of a stack is the same in every trace it appears in.
+func StackHash(stk []*Frame) string {
+	h := fnv.New64a()
+	for _, f := range stk {
+		fmt.Fprintf(h, "%s\x00%s\x00%d\x00", f.Fn, f.File, f.Line)
+	}
+	return fmt.Sprintf("%016x", h.Sum64())
+}
@@ -0,0 +1,337 @@
+package convert
+
+import (
+	"cmp"
+	"debug/dwarf"
+	"debug/elf"
+	"debug/macho"
+	"debug/pe"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// SymbolizeDWARF replaces the frames of the parsed trace's stacks with
+// those the DWARF debug info of the binary at path gives for their PCs. The
+// trace records a frame for each function at a PC, but the debug info has
+// more accurate file and line numbers, and the calls inlined at the PC for
+// traces which don't record them. Frames inlined into the next frame in the
+// stack are marked Inlined, and share a Location in pprof profiles.
+//
+// Frames whose PCs the debug info doesn't cover, or for which it has
+// different functions than the trace, as it would if the binary isn't the
+// one which produced the trace, are left as they are. It returns an error
+// if the binary has no debug info, e.g. if it was built with -ldflags=-w.
+func SymbolizeDWARF(parsed ParseResult, path string) error {
+	d, err := openDWARF(path)
+	if err != nil {
+		return err
+	}
+	s, err := newDWARFSymbolizer(d)
+	if err != nil {
+		return fmt.Errorf("reading debug info from %s: %w", path, err)
+	}
+	for id, stk := range parsed.Stacks {
+		parsed.Stacks[id] = s.stack(stk)
+	}
+	for _, ev := range parsed.Events {
+		if ev.StkID != 0 {
+			ev.Stk = parsed.Stacks[ev.StkID]
+		}
+	}
+	return nil
+}
+
+// openDWARF returns the debug info of the ELF, Mach-O or PE binary at path.
+func openDWARF(path string) (*dwarf.Data, error) {
+	if f, err := elf.Open(path); err == nil {
+		defer f.Close()
+		return f.DWARF()
+	}
+	if f, err := macho.Open(path); err == nil {
+		defer f.Close()
+		return f.DWARF()
+	}
+	if f, err := pe.Open(path); err == nil {
+		defer f.Close()
+		return f.DWARF()
+	}
+	return nil, fmt.Errorf("%s is not an ELF, Mach-O or PE executable", path)
+}
+
+// dwarfRange is a range of PCs covered by a debug info entry, such as a
+// compilation unit or a function.
+type dwarfRange struct {
+	low, high uint64
+	offset    dwarf.Offset
+}
+
+// dwarfUnit is a compilation unit, which has a line table, and an index of
+// its functions, read when it's first needed.
+type dwarfUnit struct {
+	entry *dwarf.Entry
+	lines *dwarf.LineReader
+	funcs []dwarfRange
+}
+
+type dwarfSymbolizer struct {
+	d     *dwarf.Data
+	units []*dwarfUnit
+	// ranges are the PC ranges of the units, sorted, with offset being
+	// the unit's index in units
+	ranges []dwarfRange
+	// cache has the frames for each PC looked up so far
+	cache map[uint64][]*Frame
+}
+
+func newDWARFSymbolizer(d *dwarf.Data) (*dwarfSymbolizer, error) {
+	s := &dwarfSymbolizer{d: d, cache: make(map[uint64][]*Frame)}
+	r := d.Reader()
+	for {
+		e, err := r.Next()
+		if err != nil {
+			return nil, err
+		}
+		if e == nil {
+			break
+		}
+		if e.Tag == dwarf.TagCompileUnit {
+			ranges, err := d.Ranges(e)
+			if err != nil {
+				return nil, err
+			}
+			for _, rg := range ranges {
+				s.ranges = append(s.ranges, dwarfRange{rg[0], rg[1], dwarf.Offset(len(s.units))})
+			}
+			s.units = append(s.units, &dwarfUnit{entry: e})
+		}
+		r.SkipChildren()
+	}
+	if len(s.units) == 0 {
+		return nil, errors.New("no compilation units")
+	}
+	slices.SortFunc(s.ranges, func(a, b dwarfRange) int { return cmp.Compare(a.low, b.low) })
+	return s, nil
+}
+
+// find returns the range containing pc in the sorted ranges.
+func find(ranges []dwarfRange, pc uint64) (dwarfRange, bool) {
+	i := sort.Search(len(ranges), func(i int) bool { return ranges[i].low > pc })
+	if i == 0 || pc >= ranges[i-1].high {
+		return dwarfRange{}, false
+	}
+	return ranges[i-1], true
+}
+
+// stack returns the frames for the stack. Each frame is replaced with the
+// frames the debug info has for its PC: its function, and those it was
+// inlined into. Traces from Go 1.12 and later already have frames for the
+// functions a call was inlined into, following it, and those are replaced
+// too.
+func (s *dwarfSymbolizer) stack(stk []*Frame) []*Frame {
+	sameFunc := func(a, b *Frame) bool { return joinFuncName(a.Fn) == joinFuncName(b.Fn) }
+	var out []*Frame
+	for i := 0; i < len(stk); {
+		frames := s.frames(stk[i].PC)
+		if len(frames) == 0 || !sameFunc(frames[0], stk[i]) {
+			out = append(out, stk[i])
+			i++
+			continue
+		}
+		n := 1
+		for n < len(frames) && i+n < len(stk) && sameFunc(frames[n], stk[i+n]) {
+			n++
+		}
+		out = append(out, frames...)
+		i += n
+	}
+	return out
+}
+
+// frames returns the frames for the functions at pc, innermost first, or
+// nil if the debug info doesn't cover it.
+func (s *dwarfSymbolizer) frames(pc uint64) []*Frame {
+	if frames, ok := s.cache[pc]; ok {
+		return frames
+	}
+	frames, err := s.lookup(pc)
+	if err != nil {
+		frames = nil
+	}
+	s.cache[pc] = frames
+	return frames
+}
+
+func (s *dwarfSymbolizer) lookup(pc uint64) ([]*Frame, error) {
+	if pc == 0 {
+		return nil, nil
+	}
+	addr := pc
+	rg, ok := find(s.ranges, addr)
+	if !ok {
+		return nil, nil
+	}
+	u := s.units[rg.offset]
+	if u.lines == nil {
+		if err := s.index(u); err != nil {
+			return nil, err
+		}
+	}
+	fn, ok := find(u.funcs, addr)
+	if !ok {
+		return nil, nil
+	}
+
+	// The chain of the function and the calls inlined into it at addr,
+	// outermost first
+	r := s.d.Reader()
+	r.Seek(fn.offset)
+	e, err := r.Next()
+	if err != nil {
+		return nil, err
+	}
+	chain := []*dwarf.Entry{e}
+	if e.Children {
+	children:
+		for {
+			e, err := r.Next()
+			if err != nil {
+				return nil, err
+			}
+			if e == nil || e.Tag == 0 {
+				// The end of the innermost entry containing addr
+				break children
+			}
+			switch e.Tag {
+			case dwarf.TagInlinedSubroutine, dwarf.TagLexDwarfBlock:
+				if s.contains(e, addr) {
+					if e.Tag == dwarf.TagInlinedSubroutine {
+						chain = append(chain, e)
+					}
+					if !e.Children {
+						break children
+					}
+					continue
+				}
+			}
+			r.SkipChildren()
+		}
+	}
+
+	var le dwarf.LineEntry
+	if err := u.lines.SeekPC(addr, &le); err != nil {
+		return nil, err
+	}
+	file, line := le.File.Name, le.Line
+	files := u.lines.Files()
+	var frames []*Frame
+	for i := len(chain) - 1; i >= 0; i-- {
+		name, err := s.name(chain[i])
+		if err != nil {
+			return nil, err
+		}
+		frames = append(frames, &Frame{PC: pc, Fn: name, File: file, Line: line, Inlined: i > 0})
+		// The file and line of the frame it's inlined into are those
+		// of the call
+		if idx, ok := chain[i].Val(dwarf.AttrCallFile).(int64); ok && int(idx) < len(files) && files[idx] != nil {
+			file = files[idx].Name
+		}
+		if l, ok := chain[i].Val(dwarf.AttrCallLine).(int64); ok {
+			line = int(l)
+		}
+	}
+	// The runtime leaves wrappers out of the stacks it records, so keeping
+	// them would stop the frames from lining up with the trace's, and the
+	// stacks from matching those of a CPU profile in JoinCPUProfileLabels.
+	frames = slices.DeleteFunc(frames, isWrapperFrame)
+	if len(frames) > 0 {
+		frames[len(frames)-1].Inlined = false
+	}
+	return frames, nil
+}
+
+// isWrapperFrame reports whether the frame is in a wrapper the compiler
+// generated, for a method value, a go or defer statement, or a method
+// called through a pointer or an interface.
+func isWrapperFrame(f *Frame) bool {
+	return f.File == "<autogenerated>" || strings.HasSuffix(f.Fn, "-fm") ||
+		strings.Contains(f.Fn, ".gowrap") || strings.Contains(f.Fn, ".deferwrap")
+}
+
+// index reads the unit's line table, and the PC ranges of its functions.
+func (s *dwarfSymbolizer) index(u *dwarfUnit) error {
+	lines, err := s.d.LineReader(u.entry)
+	if err != nil {
+		return err
+	}
+	if lines == nil {
+		return errors.New("no line table")
+	}
+	r := s.d.Reader()
+	r.Seek(u.entry.Offset)
+	if _, err := r.Next(); err != nil {
+		return err
+	}
+	for {
+		e, err := r.Next()
+		if err != nil {
+			return err
+		}
+		if e == nil || e.Tag == 0 {
+			break
+		}
+		if e.Tag == dwarf.TagSubprogram {
+			ranges, err := s.d.Ranges(e)
+			if err != nil {
+				return err
+			}
+			for _, rg := range ranges {
+				u.funcs = append(u.funcs, dwarfRange{rg[0], rg[1], e.Offset})
+			}
+		}
+		r.SkipChildren()
+	}
+	slices.SortFunc(u.funcs, func(a, b dwarfRange) int { return cmp.Compare(a.low, b.low) })
+	u.lines = lines
+	return nil
+}
+
+// contains reports whether the entry's PC ranges contain addr.
+func (s *dwarfSymbolizer) contains(e *dwarf.Entry, addr uint64) bool {
+	ranges, err := s.d.Ranges(e)
+	if err != nil {
+		return false
+	}
+	for _, rg := range ranges {
+		if rg[0] <= addr && addr < rg[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// name returns the name of the function the entry is for, which for inlined
+// calls, and for functions with inlined calls, is on its abstract origin.
+func (s *dwarfSymbolizer) name(e *dwarf.Entry) (string, error) {
+	for range 2 {
+		if name, ok := e.Val(dwarf.AttrName).(string); ok {
+			return name, nil
+		}
+		origin, ok := e.Val(dwarf.AttrAbstractOrigin).(dwarf.Offset)
+		if !ok {
+			break
+		}
+		r := s.d.Reader()
+		r.Seek(origin)
+		var err error
+		if e, err = r.Next(); err != nil {
+			return "", err
+		}
+		if e == nil {
+			break
+		}
+	}
+	return "", errors.New("function has no name")
+}
@@ -0,0 +1,55 @@
+package convert
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+)
+
+// ErrBinaryRequired is returned when a trace from Go 1.6 or below is parsed
+// without the binary which produced it, which its stacks need to be
+// symbolized.
+var ErrBinaryRequired = errors.New("for traces produced by go 1.6 or below, the binary argument must be provided")
+
+// ParseError is returned when a trace can't be parsed because it's
+// malformed, truncated, or of an unsupported version, as opposed to when it
+// can't be read in the first place.
+type ParseError struct {
+	// Stage is what the parser was doing, e.g. "reading header".
+	Stage string
+	// Offset is the position in the trace of the problem, if known, and
+	// -1 otherwise.
+	Offset int64
+	Err    error
+}
+
+func (e *ParseError) Error() string {
+	if e.Offset >= 0 {
+		return fmt.Sprintf("%s at offset %#x: %v", e.Stage, e.Offset, e.Err)
+	}
+	return fmt.Sprintf("%s: %v", e.Stage, e.Err)
+}
+
+func (e *ParseError) Unwrap() error { return e.Err }
+
+// parseError wraps err, from the given parsing stage, in a ParseError.
+// Errors reading the trace, and errors which are already ParseErrors, are
+// returned as they are.
+func parseError(stage string, err error) error {
+	var pe *ParseError
+	var pathErr *fs.PathError
+	var sysErr *os.SyscallError
+	if err == nil || errors.As(err, &pe) || errors.As(err, &pathErr) || errors.As(err, &sysErr) {
+		return err
+	}
+	return &ParseError{Stage: stage, Offset: -1, Err: err}
+}
+
+// recoverParse turns a panic while parsing, which a malformed trace can
+// cause in the parsers, into a ParseError in *err. It must be deferred.
+func recoverParse(stage string, err *error) {
+	if r := recover(); r != nil {
+		*err = &ParseError{Stage: stage, Offset: -1, Err: fmt.Errorf("parser panic: %v", r)}
+	}
+}
@@ -0,0 +1,478 @@
+package convert
+
+import (
+	"cmp"
+	"maps"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Filter selects the part of a trace to convert. The zero Filter keeps
+// everything.
+type Filter struct {
+	// Start and End are the window of time to keep, as offsets from the
+	// start of the trace. An End of zero means the end of the trace.
+	Start time.Duration
+	End   time.Duration
+	// Goroutines, if not empty, are the IDs of the only goroutines whose
+	// events are kept.
+	Goroutines []uint64
+	// ExcludeGoroutines are the IDs of goroutines whose events are
+	// dropped.
+	ExcludeGoroutines []uint64
+	// ExcludeRuntime drops the events for the runtime's own background
+	// goroutines, such as GC workers, the sweeper and the finalizer
+	// goroutine, and CPU samples taken outside of any goroutine, such as
+	// in sysmon or the scheduler.
+	ExcludeRuntime bool
+	// Task, if not empty, is the name or ID of a user task. Only the
+	// events on goroutines while they were in a region belonging to a
+	// task it matches, or to one of that task's subtasks, are kept.
+	Task string
+	// Focus, if not nil, keeps only the samples with a function in their
+	// stack matching it, like pprof's -focus option.
+	Focus *regexp.Regexp
+	// Ignore drops the samples with a function in their stack matching
+	// it, like pprof's -ignore option.
+	Ignore *regexp.Regexp
+}
+
+// Apply returns the part of the parsed trace selected by the filter. The
+// parsed trace isn't modified, and the result shares its events and stacks.
+//
+// When the window starts after the start of the trace, the state of each
+// goroutine at the start of the window is recreated with the same events
+// that describe goroutines which already existed when tracing started: a
+// GoCreate from goroutine 0, followed by GoStart, GoWaiting or GoInSyscall
+// for goroutines which were running, blocked, or in a syscall.
+//
+// Events which aren't about any one goroutine, like those for GC phases and
+// user tasks, are kept regardless of the goroutine filters.
+//
+// With a Task filter, each stretch of time a goroutine spends in the task's
+// regions is treated like a goroutine that is created and starts running
+// when the outermost region starts, and ends when it ends.
+//
+// The Focus and Ignore filters apply to samples rather than events. CPU
+// samples they reject are dropped. Scheduling events are kept, since the
+// goroutine states depend on them, but the profiles derived from them leave
+// out the samples at rejected stacks.
+func (f Filter) Apply(parsed ParseResult) ParseResult {
+	if f.isZero() || len(parsed.Events) == 0 {
+		return parsed
+	}
+	base := parsed.Events[0].Ts
+	lo := base + int64(f.Start)
+	hi := parsed.Events[len(parsed.Events)-1].Ts
+	if f.End > 0 {
+		hi = min(hi, base+int64(f.End))
+	}
+
+	var events []*Event
+	if f.Start > 0 {
+		events = newStateTracker(parsed, GoroutineIntervals(parsed)).at(lo)
+	}
+	for _, ev := range parsed.Events {
+		if ev.Ts >= lo && ev.Ts <= hi {
+			events = append(events, ev)
+		}
+	}
+	return f.prepare(parsed).apply(events)
+}
+
+// Windows splits the part of the parsed trace selected by the filter into
+// consecutive windows of the given length, and calls fn with each in turn,
+// filtered as Apply would with Start and End set to the window's bounds.
+// The bounds are offsets from the start of the trace. There is always at
+// least one window. If fn returns an error, Windows stops and returns it.
+//
+// The goroutine states and everything else the filter works out from the
+// whole trace are worked out once, and carried from one window to the
+// next, so splitting a long trace costs about as much as filtering it
+// once.
+func (f Filter) Windows(parsed ParseResult, window time.Duration, fn func(i int, start, end time.Duration, res ParseResult) error) error {
+	end := Duration(parsed)
+	if f.End > 0 {
+		end = min(end, f.End)
+	}
+	if len(parsed.Events) == 0 {
+		return fn(0, f.Start, end, parsed)
+	}
+	base := parsed.Events[0].Ts
+	prepared := f.prepare(parsed)
+	states := newStateTracker(parsed, GoroutineIntervals(parsed))
+	first := 0
+	for i, from := 0, f.Start; i == 0 || from < end; i, from = i+1, from+window {
+		to := min(from+window, end)
+		lo, hi := base+int64(from), base+int64(to)
+		var events []*Event
+		if from > 0 {
+			events = states.at(lo)
+		}
+		// windows share the events at their bounds, as they would
+		// filtered one at a time
+		for first < len(parsed.Events) && parsed.Events[first].Ts < lo {
+			first++
+		}
+		for _, ev := range parsed.Events[first:] {
+			if ev.Ts > hi {
+				break
+			}
+			events = append(events, ev)
+		}
+		if err := fn(i, from, to, prepared.apply(events)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// preparedFilter has what a filter works out from the whole of the parsed
+// trace, which is the same for every window of it.
+type preparedFilter struct {
+	parsed  ParseResult
+	exclude map[uint64]bool
+	include map[uint64]bool
+	// excludeRuntime is Filter.ExcludeRuntime
+	excludeRuntime bool
+	// tasks is nil without a Task filter
+	tasks *taskFilter
+	// ignored are the stacks the Focus and Ignore filters reject, or nil
+	// without them
+	ignored map[uint64]bool
+}
+
+func (f Filter) prepare(parsed ParseResult) *preparedFilter {
+	p := &preparedFilter{
+		parsed:         parsed,
+		include:        idSet(f.Goroutines),
+		exclude:        idSet(f.ExcludeGoroutines),
+		excludeRuntime: f.ExcludeRuntime,
+	}
+	if f.Task != "" {
+		p.tasks = newTaskFilter(parsed, TaskIDs(UserAnnotations(parsed), f.Task))
+	}
+	if f.ExcludeRuntime {
+		for g, fn := range GoroutineFuncs(parsed) {
+			if isRuntimeFunc(fn) {
+				p.exclude[g] = true
+			}
+		}
+	}
+	if f.Focus != nil || f.Ignore != nil {
+		p.ignored = make(map[uint64]bool)
+		for id, stk := range parsed.Stacks {
+			if !f.keepStack(stk) {
+				p.ignored[id] = true
+			}
+		}
+		if !f.keepStack(nil) {
+			p.ignored[0] = true
+		}
+	}
+	return p
+}
+
+// apply filters events, a time-ordered selection of the parsed trace's
+// events along with any recreating the goroutines' states at its start.
+func (p *preparedFilter) apply(events []*Event) ParseResult {
+	if p.tasks != nil {
+		events = p.tasks.events(events)
+	}
+
+	if len(p.include) > 0 || len(p.exclude) > 0 || p.excludeRuntime {
+		events = slices.DeleteFunc(events, func(ev *Event) bool {
+			if p.excludeRuntime && ev.Type == EvCPUSample && ev.G == 0 {
+				return true
+			}
+			g, ok := eventGoroutine(ev)
+			if !ok {
+				return false
+			}
+			return len(p.include) > 0 && !p.include[g] || p.exclude[g]
+		})
+	}
+
+	if p.ignored != nil {
+		events = slices.DeleteFunc(events, func(ev *Event) bool {
+			return ev.Type == EvCPUSample && p.ignored[ev.StkID]
+		})
+		return ParseResult{Events: events, Stacks: p.parsed.Stacks, ignoredStacks: p.ignored}
+	}
+	return ParseResult{Events: events, Stacks: p.parsed.Stacks}
+}
+
+func (f Filter) isZero() bool {
+	return f.Start <= 0 && f.End <= 0 && len(f.Goroutines) == 0 && len(f.ExcludeGoroutines) == 0 &&
+		!f.ExcludeRuntime && f.Task == "" && f.Focus == nil && f.Ignore == nil
+}
+
+// TaskIDs returns the IDs of the tasks with the given name or ID, and of all
+// their subtasks.
+func TaskIDs(a Annotations, task string) []uint64 {
+	selected := make(map[uint64]bool)
+	id, err := strconv.ParseUint(task, 10, 64)
+	for _, t := range a.Tasks {
+		if t.Name == task || err == nil && t.ID == id {
+			selected[t.ID] = true
+		}
+	}
+	// Tasks are ordered by start time, so parents come before their
+	// subtasks, which start after them.
+	for _, t := range a.Tasks {
+		if selected[t.Parent] {
+			selected[t.ID] = true
+		}
+	}
+	return slices.Sorted(maps.Keys(selected))
+}
+
+// taskSpan is a stretch of time a goroutine spends in a task's regions
+type taskSpan struct {
+	g          uint64
+	p          int
+	start, end int64
+}
+
+// taskFilter selects the events on goroutines while they were in a region
+// belonging to one of a set of tasks.
+type taskFilter struct {
+	spans []taskSpan
+	byG   map[uint64][]taskSpan
+	// createStacks are the stacks each goroutine was created with
+	createStacks map[uint64]uint64
+}
+
+func newTaskFilter(parsed ParseResult, tasks []uint64) *taskFilter {
+	selected := idSet(tasks)
+	var regions []Region
+	for _, r := range UserAnnotations(parsed).Regions {
+		if selected[r.Task] {
+			regions = append(regions, r)
+		}
+	}
+	// Merge nested and overlapping regions on each goroutine. The
+	// regions are ordered by start time.
+	var spans []taskSpan
+	last := make(map[uint64]int) // index in spans, by goroutine
+	for _, r := range regions {
+		if i, ok := last[r.G]; ok && r.Start <= spans[i].end {
+			spans[i].end = max(spans[i].end, r.End)
+			continue
+		}
+		last[r.G] = len(spans)
+		spans = append(spans, taskSpan{g: r.G, p: FakeP, start: r.Start, end: r.End})
+	}
+	// The region start events say which P the goroutines were running
+	// on when they entered the spans.
+	type regionStart struct {
+		g  uint64
+		ts int64
+	}
+	procs := make(map[regionStart]int)
+	for _, ev := range parsed.Events {
+		if ev.Type == EvUserRegion && ev.Args[1] == 0 {
+			procs[regionStart{ev.G, ev.Ts}] = ev.P
+		}
+	}
+	byG := make(map[uint64][]taskSpan)
+	for i, sp := range spans {
+		if p, ok := procs[regionStart{sp.g, sp.start}]; ok {
+			spans[i].p = p
+		}
+		byG[sp.g] = append(byG[sp.g], sp)
+	}
+	createStacks := make(map[uint64]uint64)
+	for _, ev := range parsed.Events {
+		if ev.Type == EvGoCreate {
+			createStacks[ev.Args[0]] = ev.Args[1]
+		}
+	}
+	return &taskFilter{spans: spans, byG: byG, createStacks: createStacks}
+}
+
+// events returns the events, which are a time-ordered subset of the parsed
+// trace's events, which happened on goroutines while they were in one of
+// the tasks' regions. Events which aren't about a particular goroutine are
+// all kept.
+func (t *taskFilter) events(events []*Event) []*Event {
+	inSpan := func(g uint64, ts int64) bool {
+		return slices.ContainsFunc(t.byG[g], func(sp taskSpan) bool {
+			return sp.start <= ts && ts <= sp.end
+		})
+	}
+
+	if len(events) == 0 {
+		return nil
+	}
+	lo, hi := events[0].Ts, events[len(events)-1].Ts
+
+	var kept []*Event
+	for _, ev := range events {
+		g, ok := eventGoroutine(ev)
+		if !ok {
+			kept = append(kept, ev)
+			continue
+		}
+		if inSpan(g, ev.Ts) {
+			kept = append(kept, ev)
+		}
+	}
+	// The synthetic events go before any others at the start of a span,
+	// and after any others at its end.
+	rank := make(map[*Event]int)
+	for _, sp := range t.spans {
+		if sp.end < lo || sp.start > hi {
+			continue
+		}
+		// Goroutines already in a span at the start of the events
+		// have their state there set up already.
+		if sp.start > lo {
+			create := &Event{Type: EvGoCreate, Ts: sp.start, P: FakeP, M: -1}
+			create.Args[0] = sp.g
+			create.Args[1] = t.createStacks[sp.g]
+			start := &Event{Type: EvGoStart, Ts: sp.start, P: sp.p, G: sp.g, M: -1}
+			start.Args[0] = sp.g
+			rank[create], rank[start] = -1, -1
+			kept = append(kept, create, start)
+		}
+		end := &Event{Type: EvGoEnd, Ts: min(sp.end, hi), P: sp.p, G: sp.g, M: -1}
+		rank[end] = 1
+		kept = append(kept, end)
+	}
+	slices.SortStableFunc(kept, func(a, b *Event) int {
+		return cmp.Or(cmp.Compare(a.Ts, b.Ts), cmp.Compare(rank[a], rank[b]))
+	})
+	return kept
+}
+
+// isRuntimeFunc reports whether a goroutine started with the named function
+// is one of the runtime's background goroutines, rather than one started by
+// the program. The main goroutine starts in runtime.main, but belongs to the
+// program.
+func isRuntimeFunc(fn string) bool {
+	if fn == "runtime.main" {
+		return false
+	}
+	return strings.HasPrefix(fn, "runtime.") || strings.HasPrefix(fn, "runtime/trace.")
+}
+
+// keepStack reports whether samples with the given stack pass the Focus and
+// Ignore filters.
+func (f Filter) keepStack(stk []*Frame) bool {
+	matches := func(re *regexp.Regexp) bool {
+		return slices.ContainsFunc(stk, func(frame *Frame) bool { return re.MatchString(frame.Fn) })
+	}
+	if f.Focus != nil && !matches(f.Focus) {
+		return false
+	}
+	return f.Ignore == nil || !matches(f.Ignore)
+}
+
+func idSet(ids []uint64) map[uint64]bool {
+	set := make(map[uint64]bool, len(ids))
+	for _, id := range ids {
+		set[id] = true
+	}
+	return set
+}
+
+// eventGoroutine returns the goroutine the event is about, which isn't
+// always the goroutine it happened on. For example, a GoUnblock event is
+// about the goroutine being unblocked. It returns false for events which
+// aren't about a particular goroutine.
+func eventGoroutine(ev *Event) (uint64, bool) {
+	switch ev.Type {
+	case EvGoCreate, EvGoUnblock:
+		return ev.Args[0], true
+	case EvBatch, EvFrequency, EvStack, EvGomaxprocs, EvProcStart, EvProcStop,
+		EvGCStart, EvGCDone, EvGCSTWStart, EvGCSTWDone, EvHeapAlloc,
+		EvHeapGoal, EvTimerGoroutine, EvString, EvUserTaskCreate,
+		EvUserTaskEnd:
+		return 0, false
+	}
+	return ev.G, ev.G != 0
+}
+
+// stateTracker follows the goroutines of a trace through it, to recreate
+// their states at the start of each window of it.
+type stateTracker struct {
+	events []*Event
+	// intervals are the goroutines' state intervals, in order of start,
+	// and next the index of the first which started at or after the
+	// last time asked about
+	intervals []Interval
+	next      int
+	// states has the latest interval of each goroutine which started
+	// before the last time asked about
+	states map[uint64]Interval
+	// createStacks are the stacks the goroutines created before the last
+	// time asked about were created with, and nextEvent the index of the
+	// first event at or after it
+	createStacks map[uint64]uint64
+	nextEvent    int
+}
+
+// newStateTracker returns a stateTracker for the parsed trace, whose
+// goroutine intervals, from GoroutineIntervals, are given.
+func newStateTracker(parsed ParseResult, intervals []Interval) *stateTracker {
+	intervals = slices.Clone(intervals)
+	// a goroutine's intervals which start at the same time are in the
+	// order they ended, as GoroutineIntervals returns them
+	slices.SortStableFunc(intervals, func(a, b Interval) int { return cmp.Compare(a.Start, b.Start) })
+	return &stateTracker{
+		events:       parsed.Events,
+		intervals:    intervals,
+		states:       make(map[uint64]Interval),
+		createStacks: make(map[uint64]uint64),
+	}
+}
+
+// at returns events at the given time which put the goroutines alive at
+// that time into the states they were in. The time mustn't be before that
+// of the previous call.
+func (t *stateTracker) at(ts int64) []*Event {
+	for ; t.next < len(t.intervals) && t.intervals[t.next].Start < ts; t.next++ {
+		in := t.intervals[t.next]
+		t.states[in.G] = in
+	}
+	// the stacks the goroutines were created with, so that the
+	// recreated GoCreate events say what the goroutines run
+	for ; t.nextEvent < len(t.events) && t.events[t.nextEvent].Ts < ts; t.nextEvent++ {
+		if ev := t.events[t.nextEvent]; ev.Type == EvGoCreate {
+			t.createStacks[ev.Args[0]] = ev.Args[1]
+		}
+	}
+	// goroutines whose latest interval ended before ts have ended, and
+	// are added back if they have another interval later
+	maps.DeleteFunc(t.states, func(_ uint64, in Interval) bool { return in.End < ts })
+	var events []*Event
+	for _, g := range slices.Sorted(maps.Keys(t.states)) {
+		in := t.states[g]
+		create := &Event{Type: EvGoCreate, Ts: ts, P: FakeP, M: -1}
+		create.Args[0] = g
+		create.Args[1] = t.createStacks[g]
+		events = append(events, create)
+		var typ byte
+		switch in.State {
+		case StateRunnable:
+			continue
+		case StateRunning:
+			typ = EvGoStart
+		case StateBlocked:
+			typ = EvGoWaiting
+		case StateSyscall:
+			typ = EvGoInSyscall
+		}
+		ev := &Event{Type: typ, Ts: ts, P: in.P, G: g, M: -1}
+		ev.Args[0] = g
+		if typ != EvGoStart {
+			ev.P = FakeP
+		}
+		events = append(events, ev)
+	}
+	return events
+}
@@ -0,0 +1,51 @@
+package convert
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+// TestWindowsMatchesApply checks that splitting a trace into windows gives
+// the same events as filtering each window on its own.
+func TestWindowsMatchesApply(t *testing.T) {
+	data, err := os.ReadFile("testdata/multigen.trace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParseBytes(data, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []Filter{
+		{},
+		{Start: 300 * time.Millisecond, End: 1900 * time.Millisecond},
+		{Goroutines: []uint64{10, 12}},
+		{ExcludeRuntime: true},
+	} {
+		n := 0
+		err := f.Windows(parsed, 250*time.Millisecond, func(i int, start, end time.Duration, got ParseResult) error {
+			wf := f
+			wf.Start, wf.End = start, end
+			want := wf.Apply(parsed)
+			if len(got.Events) != len(want.Events) {
+				t.Fatalf("%+v: window %d has %d events, want %d", f, i, len(got.Events), len(want.Events))
+			}
+			for j, ev := range got.Events {
+				w := want.Events[j]
+				if ev.Type != w.Type || ev.Ts != w.Ts || ev.G != w.G || ev.P != w.P || ev.Args != w.Args {
+					t.Fatalf("%+v: window %d event %d is %s %+v, want %s %+v", f, i, j,
+						EventDescriptions[ev.Type].Name, *ev, EventDescriptions[w.Type].Name, *w)
+				}
+			}
+			n++
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n < 2 {
+			t.Errorf("%+v: got %d windows, want several", f, n)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package convert
+
+import (
+	_ "embed"
+	"html/template"
+	"io"
+	"sort"
+)
+
+//go:embed flamegraph.html
+var flamegraphHTML string
+
+var flamegraphTemplate = template.Must(template.New("flamegraph").Parse(flamegraphHTML))
+
+// flameNode is a node of the call tree rendered by the flame graph. The JSON
+// field names are short since the tree is embedded in the page.
+type flameNode struct {
+	Name     string       `json:"n"`
+	Value    int64        `json:"v"`
+	Children []*flameNode `json:"c,omitempty"`
+
+	children map[string]*flameNode
+}
+
+func (n *flameNode) child(name string) *flameNode {
+	if c, ok := n.children[name]; ok {
+		return c
+	}
+	c := &flameNode{Name: name, children: make(map[string]*flameNode)}
+	n.children[name] = c
+	n.Children = append(n.Children, c)
+	return c
+}
+
+// sort orders the children of each node by name, like flamegraph.pl does,
+// so the output is stable.
+func (n *flameNode) sort() {
+	sort.Slice(n.Children, func(i, j int) bool { return n.Children[i].Name < n.Children[j].Name })
+	for _, c := range n.Children {
+		c.sort()
+	}
+}
+
+// ToFlamegraph writes the CPU samples in the parsed trace to out as a
+// self-contained HTML page with an interactive flame graph. The page has no
+// external dependencies, so it can be opened in any browser or attached to
+// a bug report. Click a frame to zoom in on it, and use the search box to
+// highlight matching functions.
+func ToFlamegraph(parsed ParseResult, title string, out io.Writer) error {
+	root := &flameNode{Name: "all", children: make(map[string]*flameNode)}
+	for _, ev := range parsed.Events {
+		if ev.Type != EvCPUSample {
+			continue
+		}
+		root.Value++
+		node := root
+		stk := parsed.Stacks[ev.StkID]
+		if len(stk) == 0 {
+			node = node.child("[unknown]")
+			node.Value++
+			continue
+		}
+		for i := len(stk) - 1; i >= 0; i-- {
+			node = node.child(stk[i].Fn)
+			node.Value++
+		}
+	}
+	root.sort()
+	return flamegraphTemplate.Execute(out, struct {
+		Title string
+		Root  *flameNode
+	}{title, root})
+}
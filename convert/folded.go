@@ -0,0 +1,56 @@
+package convert
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// ToFolded writes the CPU samples in the parsed trace to out in the
+// collapsed stack format used by flamegraph.pl and similar tools. Each line
+// is a semicolon-separated stack, starting at the root, followed by the
+// number of samples with that stack:
+//
+//	main.main;main.work;sort.Ints 12
+//
+// Lines are sorted by stack so the output is stable.
+func ToFolded(parsed ParseResult, out io.Writer) error {
+	counts := make(map[string]int64)
+	for _, ev := range parsed.Events {
+		if ev.Type != EvCPUSample {
+			continue
+		}
+		counts[foldStack(parsed.Stacks[ev.StkID])]++
+	}
+	stacks := make([]string, 0, len(counts))
+	for s := range counts {
+		stacks = append(stacks, s)
+	}
+	sort.Strings(stacks)
+	w := bufio.NewWriter(out)
+	for _, s := range stacks {
+		fmt.Fprintf(w, "%s %d\n", s, counts[s])
+	}
+	return w.Flush()
+}
+
+var foldReplacer = strings.NewReplacer(";", ":", " ", "_")
+
+// foldStack joins the function names of the stack, root first, with
+// semicolons. Semicolons and spaces in the names themselves would confuse
+// consumers of the format, so they're replaced.
+func foldStack(stk []*Frame) string {
+	if len(stk) == 0 {
+		return "[unknown]"
+	}
+	var sb strings.Builder
+	for i := len(stk) - 1; i >= 0; i-- {
+		sb.WriteString(foldReplacer.Replace(stk[i].Fn))
+		if i > 0 {
+			sb.WriteByte(';')
+		}
+	}
+	return sb.String()
+}
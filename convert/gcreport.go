@@ -0,0 +1,136 @@
+package convert
+
+import (
+	"cmp"
+	"maps"
+	"slices"
+)
+
+// GCReport summarizes the garbage collector's activity during a trace.
+// Times are in nanoseconds, relative to the start of the trace.
+type GCReport struct {
+	// Duration is the length of the trace
+	Duration int64 `json:"duration_ns"`
+	// Cycles are the GC cycles which started during the trace
+	Cycles []GCCycle `json:"cycles"`
+	// STWPauses and STW are the number and total length of the
+	// stop-the-world pauses during the GC cycles
+	STWPauses int   `json:"stw_pauses"`
+	STW       int64 `json:"stw_ns"`
+	// Assists are the goroutines which did GC mark assists, the most time
+	// first
+	Assists []GCAssists `json:"assists,omitempty"`
+	// Workers is the time background mark workers ran, and WorkerFraction
+	// that as a fraction of the CPU time GOMAXPROCS allowed over the
+	// trace. WorkerFraction is 0 if the trace doesn't record GOMAXPROCS.
+	Workers        int64   `json:"workers_ns"`
+	WorkerFraction float64 `json:"worker_fraction"`
+}
+
+// GCCycle is a single GC cycle.
+type GCCycle struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+	// Incomplete is set if the cycle hadn't finished by the end of the
+	// trace, in which case End is the end of the trace
+	Incomplete bool `json:"incomplete,omitempty"`
+	// STW is the total length of the stop-the-world pauses in the cycle
+	STW int64 `json:"stw_ns"`
+	// HeapStart and HeapEnd are the size of the live heap in bytes when the
+	// cycle started and ended, and HeapGoal the heap goal it started with.
+	// They're 0 if the trace hadn't recorded them yet.
+	HeapStart uint64 `json:"heap_start_bytes"`
+	HeapEnd   uint64 `json:"heap_end_bytes"`
+	HeapGoal  uint64 `json:"heap_goal_bytes"`
+}
+
+// GCAssists is the GC mark assist work done by one goroutine.
+type GCAssists struct {
+	G uint64 `json:"g"`
+	// Func is the function the goroutine was started with, if known
+	Func  string `json:"func,omitempty"`
+	Count int    `json:"count"`
+	Time  int64  `json:"time_ns"`
+}
+
+// NewGCReport summarizes the GC activity in the parsed trace.
+func NewGCReport(parsed ParseResult) GCReport {
+	var r GCReport
+	if len(parsed.Events) == 0 {
+		return r
+	}
+	base, last := parsed.Events[0].Ts, parsed.Events[len(parsed.Events)-1].Ts
+	r.Duration = last - base
+
+	var heap, goal uint64
+	// cur is the index of the cycle in progress, or -1
+	cur := -1
+	// procs and procsSince track GOMAXPROCS, to add up the CPU time it
+	// allowed
+	var procs, cpuTime int64
+	procsSince := base
+	for _, ev := range parsed.Events {
+		switch ev.Type {
+		case EvHeapAlloc:
+			heap = ev.Args[0]
+		case EvHeapGoal:
+			goal = ev.Args[0]
+		case EvGomaxprocs:
+			cpuTime += procs * (ev.Ts - procsSince)
+			procs, procsSince = int64(ev.Args[0]), ev.Ts
+		case EvGCStart:
+			r.Cycles = append(r.Cycles, GCCycle{Start: ev.Ts - base, HeapStart: heap, HeapGoal: goal})
+			cur = len(r.Cycles) - 1
+		case EvGCDone:
+			if cur >= 0 {
+				r.Cycles[cur].End, r.Cycles[cur].HeapEnd = ev.Ts-base, heap
+				cur = -1
+			}
+		}
+	}
+	if cur >= 0 {
+		c := &r.Cycles[cur]
+		c.End, c.HeapEnd, c.Incomplete = r.Duration, heap, true
+	}
+	cpuTime += procs * (last - procsSince)
+
+	for _, p := range STWPauses(parsed) {
+		// the pause is in the last cycle to start before it ended, if that
+		// hadn't ended before the pause started
+		start, end := p.Start-base, p.End-base
+		i, found := slices.BinarySearchFunc(r.Cycles, end, func(c GCCycle, t int64) int { return cmp.Compare(c.Start, t) })
+		if !found {
+			i--
+		}
+		if i < 0 || start > r.Cycles[i].End {
+			continue
+		}
+		r.Cycles[i].STW += p.Duration()
+		r.STWPauses++
+		r.STW += p.Duration()
+	}
+
+	assists := make(map[uint64]*GCAssists)
+	funcs := GoroutineFuncs(parsed)
+	gcAssists(parsed, func(start *Event, end int64) {
+		a, ok := assists[start.G]
+		if !ok {
+			a = &GCAssists{G: start.G, Func: funcs[start.G]}
+			assists[start.G] = a
+		}
+		a.Count++
+		a.Time += end - start.Ts
+	})
+	for _, g := range slices.Sorted(maps.Keys(assists)) {
+		r.Assists = append(r.Assists, *assists[g])
+	}
+	slices.SortStableFunc(r.Assists, func(a, b GCAssists) int { return cmp.Compare(b.Time, a.Time) })
+
+	gcWorkers(parsed, func(in Interval, _ string) {
+		r.Workers += in.Duration()
+	})
+	if cpuTime > 0 {
+		r.WorkerFraction = float64(r.Workers) / float64(cpuTime)
+	}
+	return r
+}
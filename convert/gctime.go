@@ -0,0 +1,75 @@
+package convert
+
+import (
+	"io"
+	"strings"
+	"time"
+)
+
+// ToGCTime writes a pprof profile of the time goroutines spent doing GC work
+// to out, uncompressed, with sample types gc-periods/count, the number of
+// assists and stretches of background work, and gc-time/nanoseconds. There
+// are two kinds of GC work:
+//
+//   - Mark assists, where a goroutine which allocates has to help the GC
+//     before it can continue. These are attributed to the stack where the
+//     assist started, under a "[GC assist]" leaf frame, so the allocation
+//     paths paying for GC stand out.
+//   - Background mark workers, the dedicated, fractional and idle GC
+//     workers. These don't have a meaningful stack, so each kind gets a
+//     single frame like "[GC (dedicated)]".
+//
+// Samples are labeled with the goroutine which did the work.
+func ToGCTime(parsed ParseResult, start, stop time.Time, opts PprofOptions, out io.Writer) error {
+	gcTime := valueType{"gc-time", "nanoseconds"}
+	b := newProfileBuilder(parsed, opts, gcTime, valueType{"gc-periods", "count"}, gcTime)
+
+	gcAssists(parsed, func(start *Event, end int64) {
+		b.add(sampleKey{stkID: start.StkID, leaf: "[GC assist]", g: start.G}, start.Ts, 1, end-start.Ts)
+	})
+	gcWorkers(parsed, func(in Interval, kind string) {
+		b.add(sampleKey{leaf: "[" + kind + "]", g: in.G}, in.Start, 1, in.Duration())
+	})
+	return b.write(start, stop, out)
+}
+
+// gcAssists calls f for each GC mark assist in the trace, with the event
+// which started it and when it ended.
+func gcAssists(parsed ParseResult, f func(start *Event, end int64)) {
+	assists := make(map[uint64]*Event)
+	for _, ev := range parsed.Events {
+		switch ev.Type {
+		case EvGCMarkAssistStart:
+			assists[ev.G] = ev
+		case EvGCMarkAssistDone:
+			if a, ok := assists[ev.G]; ok {
+				delete(assists, ev.G)
+				f(a, ev.Ts)
+			}
+		}
+	}
+}
+
+// gcWorkers calls f for each interval a background GC mark worker ran
+// for, with the kind of worker, such as "GC (dedicated)".
+func gcWorkers(parsed ParseResult, f func(in Interval, kind string)) {
+	type startKey struct {
+		g  uint64
+		ts int64
+	}
+	// the kind of GC worker started by each GoStartLabel event
+	workers := make(map[startKey]string)
+	for _, ev := range parsed.Events {
+		if ev.Type == EvGoStartLabel && len(ev.SArgs) > 0 && strings.HasPrefix(ev.SArgs[0], "GC (") {
+			workers[startKey{ev.G, ev.Ts}] = ev.SArgs[0]
+		}
+	}
+	for _, in := range GoroutineIntervals(parsed) {
+		if in.State != StateRunning || in.Reason != EvGoStartLabel {
+			continue
+		}
+		if kind, ok := workers[startKey{in.G, in.Start}]; ok {
+			f(in, kind)
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package convert
+
+import (
+	"io"
+	"time"
+)
+
+// ToGoCreate writes a pprof profile of goroutine creation to out,
+// uncompressed. The samples are the stacks where goroutines were created,
+// with a goroutines/count sample type. The breakdown of each sample has the
+// time of each creation, which shows the rate at which goroutines were
+// created over the course of the trace. Goroutines which already existed
+// when tracing started aren't counted.
+func ToGoCreate(parsed ParseResult, start, stop time.Time, opts PprofOptions, out io.Writer) error {
+	goroutines := valueType{"goroutines", "count"}
+	b := newProfileBuilder(parsed, opts, goroutines, goroutines)
+	for _, ev := range parsed.Events {
+		// goroutines which existed before tracing started are reported
+		// as created by goroutine 0
+		if ev.Type == EvGoCreate && ev.G != 0 {
+			b.add(sampleKey{stkID: ev.StkID}, ev.Ts, 1)
+		}
+	}
+	return b.write(start, stop, out)
+}
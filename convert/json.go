@@ -0,0 +1,170 @@
+package convert
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+)
+
+// JSONTrace is the JSON encoding of a parsed trace. Many events share the
+// same stacks, so each stack is written once, in Stacks, and the events
+// refer to it by ID.
+type JSONTrace struct {
+	// Stacks has the frames of the stacks the events refer to, leaf
+	// first, keyed by stack ID
+	Stacks map[uint64][]StackFrame
+	Events []ParsedEvent
+	// LongPauses are the stop-the-world pauses longer than the threshold
+	// the trace was encoded with
+	LongPauses []JSONPause `json:",omitempty"`
+}
+
+// JSONPause is a stop-the-world pause in a JSONTrace.
+type JSONPause struct {
+	// Timestamp is when the pause started, on the same clock as the
+	// events' timestamps, and Duration its length in nanoseconds
+	Timestamp int64
+	Duration  int64
+	Reason    string `json:",omitempty"`
+	// StackID is the ID in JSONTrace.Stacks of the stack of the goroutine
+	// which stopped the world, or zero if there's no stack
+	StackID uint64 `json:",omitempty"`
+}
+
+type ParsedEvent struct {
+	Type      string
+	Goroutine uint64
+	Timestamp int64
+	// StackID is the ID of the event's stack in JSONTrace.Stacks, or zero
+	// if the event has no stack
+	StackID uint64 `json:",omitempty"`
+	// Category and Message are set for UserLog events, from trace.Log
+	Category string `json:",omitempty"`
+	Message  string `json:",omitempty"`
+	// Args are the event's arguments, such as the heap size for
+	// HeapAlloc, the reason for GCSTWStart or the goroutine a GoUnblock
+	// unblocked, keyed by the names EventDescriptions gives them. The
+	// numeric arguments are numbers and the string ones are strings.
+	Args map[string]any `json:",omitempty"`
+}
+
+type StackFrame struct {
+	Func string
+	File string
+	Line int
+}
+
+// JSONLinesEvent is an event in JSON Lines output. Each line stands on its
+// own, so it has the frames of the event's stack rather than just its ID.
+type JSONLinesEvent struct {
+	ParsedEvent
+	Stack []StackFrame `json:",omitempty"`
+}
+
+// ToJSON writes the events of a parsed trace to out as a JSONTrace, marking
+// the stop-the-world pauses longer than pauseThreshold nanoseconds.
+func ToJSON(parsed ParseResult, pauseThreshold int64, out io.Writer) error {
+	return json.NewEncoder(out).Encode(NewJSONTrace(parsed, pauseThreshold))
+}
+
+// NewJSONTrace returns the events of the parsed trace, and their stacks.
+// Stop-the-world pauses longer than pauseThreshold nanoseconds go in
+// LongPauses, if pauseThreshold is positive.
+func NewJSONTrace(parsed ParseResult, pauseThreshold int64) *JSONTrace {
+	trace := &JSONTrace{
+		Stacks: make(map[uint64][]StackFrame),
+		Events: []ParsedEvent{},
+	}
+	for _, event := range parsed.Events {
+		thing := jsonEvent(event)
+		if stk := parsed.Stacks[event.StkID]; len(stk) > 0 {
+			thing.StackID = event.StkID
+			if _, ok := trace.Stacks[event.StkID]; !ok {
+				trace.Stacks[event.StkID] = jsonFrames(stk)
+			}
+		}
+		trace.Events = append(trace.Events, thing)
+	}
+	for _, p := range LongPauses(parsed, pauseThreshold) {
+		pause := JSONPause{Timestamp: p.Start, Duration: p.Duration(), Reason: p.Reason}
+		if stk := parsed.Stacks[p.StkID]; len(stk) > 0 {
+			pause.StackID = p.StkID
+			if _, ok := trace.Stacks[p.StkID]; !ok {
+				trace.Stacks[p.StkID] = jsonFrames(stk)
+			}
+		}
+		trace.LongPauses = append(trace.LongPauses, pause)
+	}
+	return trace
+}
+
+// ToJSONLines writes the events of a parsed trace to out as JSON Lines, one
+// JSONLinesEvent per line, for tools like jq and log pipelines.
+func ToJSONLines(parsed ParseResult, out io.Writer) error {
+	bw := bufio.NewWriter(out)
+	enc := json.NewEncoder(bw)
+	for _, event := range parsed.Events {
+		if err := enc.Encode(jsonLinesEvent(event, parsed.Stacks[event.StkID])); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// StreamJSONLines reads an execution trace from r, like Stream, and writes
+// its events to out as JSON Lines, like ToJSONLines, as they are parsed.
+// Traces from Go 1.22 and later are converted without holding all of
+// their events in memory.
+func StreamJSONLines(r io.Reader, bin string, out io.Writer) error {
+	bw := bufio.NewWriter(out)
+	enc := json.NewEncoder(bw)
+	if _, err := Stream(r, bin, func(event *Event) error {
+		return enc.Encode(jsonLinesEvent(event, event.Stk))
+	}); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+func jsonEvent(event *Event) ParsedEvent {
+	eventType := EventDescriptions[event.Type]
+	thing := ParsedEvent{
+		Type:      eventType.Name,
+		Timestamp: event.Ts,
+		Goroutine: event.G,
+	}
+	if event.Type == EvUserLog && len(event.SArgs) > 1 {
+		thing.Category, thing.Message = event.SArgs[0], event.SArgs[1]
+	}
+	if len(eventType.Args) > 0 || len(event.SArgs) > 0 {
+		thing.Args = make(map[string]any)
+		for i, name := range eventType.Args[:min(len(eventType.Args), len(event.Args))] {
+			thing.Args[name] = event.Args[i]
+		}
+		for i, name := range eventType.SArgs[:min(len(eventType.SArgs), len(event.SArgs))] {
+			thing.Args[name] = event.SArgs[i]
+		}
+	}
+	return thing
+}
+
+func jsonLinesEvent(event *Event, stk []*Frame) JSONLinesEvent {
+	thing := JSONLinesEvent{ParsedEvent: jsonEvent(event)}
+	if len(stk) > 0 {
+		thing.StackID = event.StkID
+		thing.Stack = jsonFrames(stk)
+	}
+	return thing
+}
+
+func jsonFrames(stk []*Frame) []StackFrame {
+	frames := make([]StackFrame, 0, len(stk))
+	for _, frame := range stk {
+		frames = append(frames, StackFrame{
+			File: frame.File,
+			Func: frame.Fn,
+			Line: frame.Line,
+		})
+	}
+	return frames
+}
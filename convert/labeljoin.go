@@ -0,0 +1,157 @@
+package convert
+
+import (
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/google/pprof/profile"
+)
+
+// LabelJoin is what JoinCPUProfileLabels did.
+type LabelJoin struct {
+	// Labeled is the number of trace samples which got labels
+	Labeled int
+	// Ambiguous is the number of trace samples left without labels because
+	// the profile has their stack under several label sets, none of which
+	// clearly dominates, and AmbiguousStacks the number of such stacks
+	Ambiguous       int
+	AmbiguousStacks int
+}
+
+// labelJoinDominance is the share of a stack's weight in the CPU profile a
+// label set needs for JoinCPUProfileLabels to copy it onto every trace
+// sample with the stack.
+const labelJoinDominance = 0.9
+
+// JoinCPUProfileLabels copies pprof labels from a CPU profile collected at
+// the same time as the trace onto the trace's CPU samples, as Event.Labels.
+// This recovers the labels set with pprof.Do, which traces don't record.
+//
+// CPU profiles aggregate their samples and have no timestamps for them, so
+// the samples can't be matched up in time, only by stack. When code runs
+// under several sets of labels, as shared worker code does, the profile
+// can't say which set each trace sample with the stack had. So a trace
+// sample only gets labels if the profile has its stack under a single label
+// set, or under one with at least 90% of the stack's weight; samples
+// without labels count as a set of their own. The others are left alone,
+// and counted in the result as ambiguous.
+//
+// Function names are compared with their type parameters elided, since
+// traces record them as "[...]", and the runtime.goexit frame which CPU
+// profiles leave out is ignored. Trace samples with stacks the profile
+// doesn't have are left alone.
+func JoinCPUProfileLabels(parsed ParseResult, prof *profile.Profile) LabelJoin {
+	// label sets seen for each stack, by labelSetKey, with their weight
+	type labels struct {
+		kv     []string
+		weight int64
+	}
+	byStack := make(map[string]map[string]*labels)
+	for _, s := range prof.Sample {
+		var kv []string
+		for _, k := range slices.Sorted(maps.Keys(s.Label)) {
+			if len(s.Label[k]) > 0 {
+				kv = append(kv, k, s.Label[k][0])
+			}
+		}
+		var fns []string
+		for _, loc := range s.Location {
+			for _, line := range loc.Line {
+				if line.Function != nil {
+					fns = append(fns, joinFuncName(line.Function.Name))
+				}
+			}
+		}
+		stk := strings.Join(fns, "\n")
+		sets, ok := byStack[stk]
+		if !ok {
+			sets = make(map[string]*labels)
+			byStack[stk] = sets
+		}
+		key := labelSetKey(kv)
+		l, ok := sets[key]
+		if !ok {
+			l = &labels{kv: kv}
+			sets[key] = l
+		}
+		if len(s.Value) > 0 {
+			l.weight += s.Value[0]
+		}
+	}
+
+	// the chosen labels for each trace stack, and the stacks with no
+	// dominant label set
+	chosen := make(map[uint64][]string)
+	ambiguous := make(map[uint64]bool)
+	for id, stk := range parsed.Stacks {
+		var fns []string
+		for _, frame := range stk {
+			if frame.Fn != "runtime.goexit" {
+				fns = append(fns, joinFuncName(frame.Fn))
+			}
+		}
+		sets := byStack[strings.Join(fns, "\n")]
+		var best *labels
+		var total int64
+		for _, l := range sets {
+			total += l.weight
+			if best == nil || l.weight > best.weight {
+				best = l
+			}
+		}
+		switch {
+		case best == nil:
+		case len(sets) == 1 || float64(best.weight) >= labelJoinDominance*float64(total):
+			if len(best.kv) > 0 {
+				chosen[id] = best.kv
+			}
+		default:
+			ambiguous[id] = true
+		}
+	}
+
+	var res LabelJoin
+	ambiguousSampled := make(map[uint64]bool)
+	for _, ev := range parsed.Events {
+		if ev.Type != EvCPUSample {
+			continue
+		}
+		if kv, ok := chosen[ev.StkID]; ok {
+			ev.Labels = kv
+			res.Labeled++
+		} else if ambiguous[ev.StkID] {
+			res.Ambiguous++
+			ambiguousSampled[ev.StkID] = true
+		}
+	}
+	res.AmbiguousStacks = len(ambiguousSampled)
+	return res
+}
+
+// joinFuncName elides the type parameters from a function name, e.g.
+// "slices.Sort[go.shape.[]int,go.shape.int]" becomes "slices.Sort[...]".
+func joinFuncName(name string) string {
+	if !strings.Contains(name, "[") || strings.Contains(name, "[...]") {
+		return name
+	}
+	var sb strings.Builder
+	depth := 0
+	for _, r := range name {
+		switch {
+		case r == '[':
+			if depth == 0 {
+				sb.WriteString("[...")
+			}
+			depth++
+		case r == ']' && depth > 0:
+			depth--
+			if depth == 0 {
+				sb.WriteByte(']')
+			}
+		case depth == 0:
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
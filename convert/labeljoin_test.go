@@ -0,0 +1,58 @@
+package convert
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/google/pprof/profile"
+)
+
+func TestJoinCPUProfileLabels(t *testing.T) {
+	fn := func(name string) *profile.Location {
+		return &profile.Location{Line: []profile.Line{{Function: &profile.Function{Name: name}}}}
+	}
+	sample := func(leaf string, value int64, labels map[string][]string) *profile.Sample {
+		return &profile.Sample{Location: []*profile.Location{fn(leaf), fn("main.main")}, Value: []int64{value}, Label: labels}
+	}
+	a := map[string][]string{"worker": {"a"}}
+	b := map[string][]string{"worker": {"b"}}
+	prof := &profile.Profile{Sample: []*profile.Sample{
+		// only ever run under one set of labels
+		sample("main.one", 10, a),
+		// shared by two sets evenly
+		sample("main.shared", 5, a),
+		sample("main.shared", 5, b),
+		// mostly run under one set
+		sample("main.mostly", 19, b),
+		sample("main.mostly", 1, a),
+		// mostly run without labels
+		sample("main.unlabeled", 19, nil),
+		sample("main.unlabeled", 1, a),
+	}}
+	stack := func(leaf string) []*Frame {
+		return []*Frame{{Fn: leaf}, {Fn: "main.main"}, {Fn: "runtime.goexit"}}
+	}
+	parsed := ParseResult{Stacks: map[uint64][]*Frame{
+		1: stack("main.one"),
+		2: stack("main.shared"),
+		3: stack("main.mostly"),
+		4: stack("main.unlabeled"),
+		5: stack("main.missing"),
+	}}
+	for id := range uint64(5) {
+		for range 2 {
+			parsed.Events = append(parsed.Events, &Event{Type: EvCPUSample, StkID: id + 1})
+		}
+	}
+
+	got := JoinCPUProfileLabels(parsed, prof)
+	if want := (LabelJoin{Labeled: 4, Ambiguous: 2, AmbiguousStacks: 1}); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	want := map[uint64][]string{1: {"worker", "a"}, 3: {"worker", "b"}}
+	for _, ev := range parsed.Events {
+		if !slices.Equal(ev.Labels, want[ev.StkID]) {
+			t.Errorf("sample with stack %d has labels %q, want %q", ev.StkID, ev.Labels, want[ev.StkID])
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package convert
+
+import (
+	"cmp"
+	"slices"
+)
+
+// LeakSuspect is a group of goroutines which look like they've leaked:
+// they were created during the trace, never exited, and once they first
+// blocked, stayed blocked on the same thing until the end of the trace.
+// A goroutine which waits a long time for work looks the same in a short
+// trace, so these are only suspects.
+type LeakSuspect struct {
+	// Goroutines are the IDs of the goroutines in the group, in order
+	Goroutines []uint64 `json:"goroutines"`
+	// Func is the function the goroutines were started with, if known
+	Func string `json:"func,omitempty"`
+	// CreatedAt is the stack the goroutines were created from
+	CreatedAt []StackFrame `json:"created_at,omitempty"`
+	// Reason is what the goroutines are blocked on, as Interval.WaitReason
+	// gives it, and BlockedAt the stack they blocked at
+	Reason    string       `json:"reason"`
+	BlockedAt []StackFrame `json:"blocked_at,omitempty"`
+	// MinBlocked is how long, in nanoseconds, the goroutine blocked for the
+	// shortest time had been blocked by the end of the trace
+	MinBlocked int64 `json:"min_blocked_ns"`
+}
+
+// LeakSuspects finds the goroutines in the parsed trace which look like
+// they've leaked, as LeakSuspect describes, and which had been blocked for
+// at least minBlocked nanoseconds by the end of the trace. They're grouped
+// by the stack they were created from and the stack they blocked at, and
+// the groups with the most goroutines come first. Goroutines sleeping or
+// waiting for the GC don't count as blocked, and the runtime's background
+// goroutines are left out.
+func LeakSuspects(parsed ParseResult, minBlocked int64) []LeakSuspect {
+	if len(parsed.Events) == 0 {
+		return nil
+	}
+	last := parsed.Events[len(parsed.Events)-1].Ts
+	// the stack each goroutine created during the trace was created from
+	created := make(map[uint64]uint64)
+	for _, ev := range parsed.Events {
+		switch ev.Type {
+		case EvGoCreate:
+			if ev.G != 0 {
+				created[ev.Args[0]] = ev.StkID
+			}
+		case EvGoEnd:
+			delete(created, ev.G)
+		}
+	}
+
+	// the one time each goroutine blocked, or nil if it blocked more than
+	// once
+	blocked := make(map[uint64]*Interval)
+	ended := make(map[uint64]int64)
+	for _, in := range GoroutineIntervals(parsed) {
+		if _, ok := created[in.G]; !ok {
+			continue
+		}
+		ended[in.G] = max(ended[in.G], in.End)
+		if in.State != StateBlocked {
+			continue
+		}
+		if _, ok := blocked[in.G]; ok {
+			blocked[in.G] = nil
+			continue
+		}
+		blocked[in.G] = &in
+	}
+
+	type groupKey struct {
+		created, blocked uint64
+		reason           string
+	}
+	groups := make(map[groupKey]*LeakSuspect)
+	funcs := GoroutineFuncs(parsed)
+	for g, in := range blocked {
+		if in == nil || in.End != last || ended[g] != last || in.Duration() < minBlocked {
+			continue
+		}
+		if in.Reason == EvGoSleep || in.Reason == EvGoBlockGC {
+			continue
+		}
+		// the runtime's background goroutines spend their lives blocked
+		if isRuntimeFunc(funcs[g]) {
+			continue
+		}
+		key := groupKey{created[g], in.StkID, in.WaitReason()}
+		s, ok := groups[key]
+		if !ok {
+			s = &LeakSuspect{Func: funcs[g], Reason: key.reason, MinBlocked: in.Duration()}
+			if stk := parsed.Stacks[key.created]; len(stk) > 0 {
+				s.CreatedAt = jsonFrames(stk)
+			}
+			if stk := parsed.Stacks[key.blocked]; len(stk) > 0 {
+				s.BlockedAt = jsonFrames(stk)
+			}
+			groups[key] = s
+		}
+		s.Goroutines = append(s.Goroutines, g)
+		s.MinBlocked = min(s.MinBlocked, in.Duration())
+	}
+
+	var suspects []LeakSuspect
+	for _, s := range groups {
+		slices.Sort(s.Goroutines)
+		suspects = append(suspects, *s)
+	}
+	slices.SortFunc(suspects, func(a, b LeakSuspect) int {
+		return cmp.Or(cmp.Compare(len(b.Goroutines), len(a.Goroutines)), cmp.Compare(a.Goroutines[0], b.Goroutines[0]))
+	})
+	return suspects
+}
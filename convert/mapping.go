@@ -0,0 +1,174 @@
+package convert
+
+import (
+	"bytes"
+	"debug/elf"
+	"debug/macho"
+	"debug/pe"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/richardartoul/molecule"
+)
+
+// Mapping describes the binary which produced a trace, for the mapping
+// entry of pprof profiles. Without one, profiles have a placeholder mapping
+// with no file or build ID.
+type Mapping struct {
+	// Start and Limit are the addresses of the binary's text in memory,
+	// and Offset is the offset in the file of the text. They're zero if
+	// unknown.
+	Start, Limit, Offset uint64
+	// File is the path to the binary.
+	File string
+	// BuildID identifies the binary. It's the GNU build ID, in hex, if
+	// the binary has one, and the Go build ID otherwise.
+	BuildID string
+}
+
+// BinaryMapping reads the mapping for the binary at path, which can be an
+// ELF, Mach-O or PE executable. The address range is only set for binaries
+// which are loaded at the address they're linked at, since the trace
+// doesn't record where a position-independent executable was loaded.
+func BinaryMapping(path string) (Mapping, error) {
+	m := Mapping{File: path}
+	if f, err := elf.Open(path); err == nil {
+		defer f.Close()
+		m.BuildID = elfBuildID(f)
+		if f.Type == elf.ET_EXEC {
+			for _, p := range f.Progs {
+				if p.Type == elf.PT_LOAD && p.Flags&elf.PF_X != 0 {
+					m.Start, m.Limit, m.Offset = p.Vaddr, p.Vaddr+p.Memsz, p.Off
+					break
+				}
+			}
+		}
+		return m, nil
+	}
+	if f, err := macho.Open(path); err == nil {
+		defer f.Close()
+		if s := f.Section("__text"); s != nil {
+			m.BuildID = goBuildID(s.Data())
+		}
+		if seg := f.Segment("__TEXT"); seg != nil && f.Flags&macho.FlagPIE == 0 {
+			m.Start, m.Limit, m.Offset = seg.Addr, seg.Addr+seg.Memsz, seg.Offset
+		}
+		return m, nil
+	}
+	if f, err := pe.Open(path); err == nil {
+		defer f.Close()
+		if s := f.Section(".text"); s != nil {
+			m.BuildID = goBuildID(s.Data())
+		}
+		return m, nil
+	}
+	if _, err := os.Stat(path); err != nil {
+		return Mapping{}, err
+	}
+	return Mapping{}, fmt.Errorf("%s is not an ELF, Mach-O or PE executable", path)
+}
+
+// ExecutableMapping returns the mapping for the running program's binary,
+// for profiles of traces of the program itself, or nil if the binary can't
+// be read.
+var ExecutableMapping = sync.OnceValue(func() *Mapping {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil
+	}
+	m, err := BinaryMapping(exe)
+	if err != nil {
+		return nil
+	}
+	return &m
+})
+
+// elfBuildID returns the GNU build ID of the ELF binary, or its Go build ID
+// if it doesn't have one.
+func elfBuildID(f *elf.File) string {
+	if s := f.Section(".note.gnu.build-id"); s != nil {
+		if id, err := noteDesc(f, s, "GNU", 3); err == nil {
+			return hex.EncodeToString(id)
+		}
+	}
+	if s := f.Section(".note.go.buildid"); s != nil {
+		if id, err := noteDesc(f, s, "Go", 4); err == nil {
+			return string(id)
+		}
+	}
+	return ""
+}
+
+// noteDesc returns the descriptor of the ELF note with the given name and
+// type in the section.
+func noteDesc(f *elf.File, s *elf.Section, name string, typ uint32) ([]byte, error) {
+	data, err := s.Data()
+	if err != nil {
+		return nil, err
+	}
+	align := func(n uint32) int { return int((n + 3) &^ 3) }
+	for len(data) >= 12 {
+		namesz := f.ByteOrder.Uint32(data[0:])
+		descsz := f.ByteOrder.Uint32(data[4:])
+		ntype := f.ByteOrder.Uint32(data[8:])
+		data = data[12:]
+		if len(data) < align(namesz)+int(descsz) {
+			break
+		}
+		nname := string(bytes.TrimRight(data[:namesz], "\x00"))
+		desc := data[align(namesz) : align(namesz)+int(descsz)]
+		if nname == name && ntype == typ {
+			return desc, nil
+		}
+		if len(data) < align(namesz)+align(descsz) {
+			break
+		}
+		data = data[align(namesz)+align(descsz):]
+	}
+	return nil, errors.New("no build ID note")
+}
+
+// goBuildIDPrefix starts the Go build ID, which the linker writes at the
+// start of the text of binaries which don't have a note section for it.
+const goBuildIDPrefix = "\xff Go build ID: \""
+
+// goBuildID returns the Go build ID at the start of the text section.
+func goBuildID(data []byte, err error) string {
+	if err != nil {
+		return ""
+	}
+	data = data[:min(len(data), 1024)]
+	i := bytes.Index(data, []byte(goBuildIDPrefix))
+	if i < 0 {
+		return ""
+	}
+	id := data[i+len(goBuildIDPrefix):]
+	j := bytes.IndexByte(id, '"')
+	if j < 0 {
+		return ""
+	}
+	return string(id[:j])
+}
+
+// writeMapping writes the profile's only mapping, with ID 1, which every
+// location refers to. The functions, file names and line numbers come from
+// the trace, so pprof doesn't need to symbolize the profile.
+func (o PprofOptions) writeMapping(ps *molecule.ProtoStream, strtab *StrTab) {
+	ps.Embedded(3, func(ps *molecule.ProtoStream) error {
+		ps.Uint64(1, 1) // mapping ID
+		if m := o.Mapping; m != nil {
+			ps.Uint64(2, m.Start)              // memory start
+			ps.Uint64(3, m.Limit)              // memory limit
+			ps.Uint64(4, m.Offset)             // file offset
+			ps.Int64(5, strtab.Get(m.File))    // filename
+			ps.Int64(6, strtab.Get(m.BuildID)) // build ID
+		}
+		ps.Bool(7, true) // has functions
+		ps.Bool(8, true) // has filenames
+		ps.Bool(9, true) // has line numbers
+		return nil
+	})
+}
@@ -0,0 +1,111 @@
+package convert
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Merge combines traces, such as ones from several processes or from
+// several capture windows, into one. starts are the wall clock times at
+// which each trace started, which place the traces' events on a common
+// timeline. Merge returns the combined trace and the time it starts, which
+// is the earliest of starts.
+//
+// Identical stacks from different traces share a stack ID in the result.
+// Goroutine and task IDs from each trace are offset past those from the
+// traces before it, so that goroutines and tasks from different traces stay
+// apart. The traces aren't modified.
+func Merge(traces []ParseResult, starts []time.Time) (ParseResult, time.Time) {
+	if len(traces) == 0 {
+		return ParseResult{}, time.Time{}
+	}
+	earliest := slices.MinFunc(starts, time.Time.Compare)
+	merged := ParseResult{Stacks: make(map[uint64][]*Frame)}
+	stackIDs := make(map[string]uint64)
+	var gBase, taskBase uint64
+	for i, parsed := range traces {
+		if len(parsed.Events) == 0 {
+			continue
+		}
+		shift := int64(starts[i].Sub(earliest)) - parsed.Events[0].Ts
+		stacks := make(map[uint64]uint64) // from this trace's IDs to the merged IDs
+		stack := func(id uint64) uint64 {
+			if id == 0 {
+				return 0
+			}
+			if mid, ok := stacks[id]; ok {
+				return mid
+			}
+			frames := parsed.Stacks[id]
+			key := stackKey(frames)
+			mid, ok := stackIDs[key]
+			if !ok {
+				mid = uint64(len(stackIDs) + 1)
+				stackIDs[key] = mid
+				merged.Stacks[mid] = frames
+			}
+			stacks[id] = mid
+			return mid
+		}
+		var maxG, maxTask uint64
+		g := func(id uint64) uint64 {
+			if id == 0 {
+				return 0
+			}
+			maxG = max(maxG, id)
+			return id + gBase
+		}
+		task := func(id uint64) uint64 {
+			if id == 0 {
+				return 0
+			}
+			maxTask = max(maxTask, id)
+			return id + taskBase
+		}
+
+		copies := make(map[*Event]*Event, len(parsed.Events))
+		for _, ev := range parsed.Events {
+			c := *ev
+			c.Ts += shift
+			c.G = g(c.G)
+			c.StkID = stack(c.StkID)
+			switch c.Type {
+			case EvGoCreate:
+				c.Args[0] = g(c.Args[0])
+				c.Args[1] = stack(c.Args[1])
+			case EvGoStart, EvGoStartLabel, EvGoStartLocal, EvGoUnblock,
+				EvGoUnblockLocal, EvGoSysExit, EvGoSysExitLocal, EvGoWaiting,
+				EvGoInSyscall, EvTimerGoroutine:
+				c.Args[0] = g(c.Args[0])
+			case EvUserTaskCreate:
+				c.Args[0] = task(c.Args[0])
+				c.Args[1] = task(c.Args[1])
+			case EvUserTaskEnd, EvUserRegion, EvUserLog:
+				c.Args[0] = task(c.Args[0])
+			}
+			copies[ev] = &c
+			merged.Events = append(merged.Events, &c)
+		}
+		for _, c := range copies {
+			if c.Link != nil {
+				c.Link = copies[c.Link]
+			}
+		}
+		gBase += maxG
+		taskBase += maxTask
+	}
+	slices.SortStableFunc(merged.Events, func(a, b *Event) int { return cmp.Compare(a.Ts, b.Ts) })
+	return merged, earliest
+}
+
+// stackKey identifies a stack by its frames.
+func stackKey(frames []*Frame) string {
+	var b strings.Builder
+	for _, f := range frames {
+		fmt.Fprintf(&b, "%x %s %s %d\n", f.PC, f.Fn, f.File, f.Line)
+	}
+	return b.String()
+}
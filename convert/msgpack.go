@@ -0,0 +1,35 @@
+package convert
+
+import (
+	"bufio"
+	"io"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// ToMsgpack writes the events of a parsed trace to out as a JSONTrace,
+// like ToJSON, but encoded as MessagePack, which is more compact and
+// quicker to decode. The keys are the same as in the JSON.
+func ToMsgpack(parsed ParseResult, out io.Writer) error {
+	return encodeMsgpack(out, NewJSONTrace(parsed, 0))
+}
+
+// ToTimelineMsgpack writes the per-goroutine timeline of the parsed trace,
+// which started at the given time, to out like ToTimeline, but encoded as
+// MessagePack. The start time is a MessagePack timestamp.
+func ToTimelineMsgpack(parsed ParseResult, start time.Time, out io.Writer) error {
+	return encodeMsgpack(out, NewTimeline(parsed, start, 0))
+}
+
+// encodeMsgpack writes v to out as MessagePack, with the keys its JSON
+// encoding would have.
+func encodeMsgpack(out io.Writer, v any) error {
+	bw := bufio.NewWriter(out)
+	enc := msgpack.NewEncoder(bw)
+	enc.SetCustomStructTag("json")
+	if err := enc.Encode(v); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
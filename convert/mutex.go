@@ -0,0 +1,40 @@
+package convert
+
+import (
+	"io"
+	"time"
+)
+
+// ToMutex writes a pprof profile of contention on sync primitives to out,
+// uncompressed, without needing the runtime's mutex profiling to have been
+// enabled. The sample types are contentions/count and delay/nanoseconds.
+//
+// Each time a goroutine blocks on a sync primitive, such as a sync.Mutex,
+// the delay is attributed to two stacks: the stack where the goroutine
+// blocked, under a "[lock wait]" leaf frame, and the stack of the goroutine
+// which unblocked it, if the trace has it, under an "[unlock]" leaf frame.
+// The latter is what the runtime's mutex profile reports. Since each delay
+// is counted twice, focus on one of the leaf frames when looking at totals.
+func ToMutex(parsed ParseResult, start, stop time.Time, opts PprofOptions, out io.Writer) error {
+	contentions := valueType{"contentions", "count"}
+	b := newProfileBuilder(parsed, opts, contentions, contentions, valueType{"delay", "nanoseconds"})
+	// goroutines currently blocked on a sync primitive
+	blocked := make(map[uint64]Interval)
+	for _, in := range GoroutineIntervals(parsed) {
+		if in.State == StateBlocked && (in.Reason == EvGoBlockSync || in.Reason == EvGoBlockCond) {
+			b.add(sampleKey{stkID: in.StkID, leaf: "[lock wait]"}, in.Start, 1, in.Duration())
+			blocked[in.G] = in
+			continue
+		}
+		wait, ok := blocked[in.G]
+		if !ok {
+			continue
+		}
+		delete(blocked, in.G)
+		// the interval after the wait starts with whoever unblocked it
+		if in.Reason == EvGoUnblock && in.StkID != 0 {
+			b.add(sampleKey{stkID: in.StkID, leaf: "[unlock]"}, wait.Start, 1, wait.Duration())
+		}
+	}
+	return b.write(start, stop, out)
+}
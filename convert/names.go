@@ -0,0 +1,78 @@
+package convert
+
+import (
+	"regexp"
+	"strings"
+)
+
+// NameOptions select simplifications of function names, to keep profiles
+// and flame graphs of code with long import paths or heavy use of generics
+// readable. The zero NameOptions leaves names alone.
+type NameOptions struct {
+	// TrimPrefixes are import path prefixes to remove from function
+	// names, e.g. "github.com/org/" turns
+	// "github.com/org/repo/pkg.Func" into "repo/pkg.Func". The first
+	// matching prefix is removed.
+	TrimPrefixes []string
+	// ElideTypeParams replaces the type arguments of generic functions
+	// and types with "...", e.g. "slices.Sort[go.shape.int]" becomes
+	// "slices.Sort[...]", which is how traces from recent Go versions
+	// already name them.
+	ElideTypeParams bool
+	// ShortReceivers drops the parentheses and pointer from method
+	// receivers, e.g. "net.(*TCPConn).Read" becomes "net.TCPConn.Read".
+	ShortReceivers bool
+}
+
+func (o NameOptions) isZero() bool {
+	return len(o.TrimPrefixes) == 0 && !o.ElideTypeParams && !o.ShortReceivers
+}
+
+// pointerReceiver matches a method's pointer receiver, e.g. "(*TCPConn)".
+var pointerReceiver = regexp.MustCompile(`\(\*([^()]+)\)`)
+
+// name returns the simplified function name.
+func (o NameOptions) name(fn string) string {
+	for _, prefix := range o.TrimPrefixes {
+		if trimmed, ok := strings.CutPrefix(fn, prefix); ok {
+			fn = trimmed
+			break
+		}
+	}
+	if o.ElideTypeParams {
+		fn = joinFuncName(fn)
+	}
+	if o.ShortReceivers {
+		fn = pointerReceiver.ReplaceAllString(fn, "$1")
+	}
+	return fn
+}
+
+// SimplifyNames simplifies the function names in the parsed trace's stacks.
+// Frames are copied rather than modified, since other stacks can share
+// them, and the events are updated to refer to the new stacks.
+func SimplifyNames(parsed ParseResult, opts NameOptions) {
+	if opts.isZero() {
+		return
+	}
+	names := make(map[string]string)
+	for id, stk := range parsed.Stacks {
+		frames := make([]*Frame, len(stk))
+		for i, frame := range stk {
+			name, ok := names[frame.Fn]
+			if !ok {
+				name = opts.name(frame.Fn)
+				names[frame.Fn] = name
+			}
+			f := *frame
+			f.Fn = name
+			frames[i] = &f
+		}
+		parsed.Stacks[id] = frames
+	}
+	for _, ev := range parsed.Events {
+		if ev.StkID != 0 {
+			ev.Stk = parsed.Stacks[ev.StkID]
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package convert
+
+import (
+	"io"
+	"time"
+)
+
+// ToNetWait writes a pprof profile of the time goroutines spent blocked
+// waiting for the network poller to out, uncompressed. This separates time
+// spent waiting on the network from time spent on the CPU or blocked on
+// other goroutines. The samples are the stacks where goroutines blocked,
+// labeled with the goroutine ID, with sample types waits/count and
+// delay/nanoseconds.
+func ToNetWait(parsed ParseResult, start, stop time.Time, opts PprofOptions, out io.Writer) error {
+	delay := valueType{"delay", "nanoseconds"}
+	b := newProfileBuilder(parsed, opts, delay, valueType{"waits", "count"}, delay)
+	for _, in := range GoroutineIntervals(parsed) {
+		if in.State == StateBlocked && in.Reason == EvGoBlockNet {
+			b.add(sampleKey{stkID: in.StkID, g: in.G}, in.Start, 1, in.Duration())
+		}
+	}
+	return b.write(start, stop, out)
+}
@@ -0,0 +1,50 @@
+package convert
+
+import (
+	"io"
+	"time"
+)
+
+// ToOffCPU writes a pprof profile of the time goroutines spent not running
+// to out, uncompressed. It complements the CPU profile from ToPprof: a
+// goroutine waiting on a channel, a lock, the network, a syscall, or for a
+// P to run on doesn't show up in CPU samples at all.
+//
+// Each sample is the stack where the goroutine stopped running, with a leaf
+// frame for the reason it was waiting, such as "[chan receive]". Time spent
+// runnable after being unblocked is attributed to the stack where the
+// goroutine had blocked, since that's where it resumes. The sample types are
+// the number of times goroutines waited, and the total time they waited in
+// nanoseconds.
+func ToOffCPU(parsed ParseResult, start, stop time.Time, opts PprofOptions, out io.Writer) error {
+	wait := valueType{"off-cpu", "nanoseconds"}
+	b := newProfileBuilder(parsed, opts, wait, valueType{"waits", "count"}, wait)
+	resume := make(resumeStacks)
+	for _, in := range GoroutineIntervals(parsed) {
+		stk := resume.stack(in)
+		if in.State == StateRunning {
+			continue
+		}
+		b.add(sampleKey{stkID: stk, leaf: "[" + in.WaitReason() + "]"}, in.Start, 1, in.Duration())
+	}
+	return b.write(start, stop, out)
+}
+
+// resumeStacks tracks where each goroutine blocked, so that the time it
+// then spends runnable can be attributed to where it will resume rather than
+// to the stack of the goroutine which woke it up. Intervals must be passed
+// to stack in the order GoroutineIntervals returns them.
+type resumeStacks map[uint64]uint64
+
+// stack returns the stack the goroutine will be at when it next runs.
+func (r resumeStacks) stack(in Interval) uint64 {
+	switch in.State {
+	case StateBlocked, StateSyscall:
+		r[in.G] = in.StkID
+	case StateRunnable:
+		if in.Reason == EvGoUnblock || in.Reason == EvGoSysExit {
+			return r[in.G]
+		}
+	}
+	return in.StkID
+}
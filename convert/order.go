@@ -2,7 +2,7 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
-package main
+package convert
 
 import (
 	"fmt"
@@ -96,9 +96,11 @@ func order1007(m map[int][]*Event) (events []*Event, err error) {
 		frontier[0] = frontier[len(frontier)-1]
 		frontier = frontier[:len(frontier)-1]
 		events = append(events, f.ev)
-		transition(gs, f.g, f.init, f.next)
+		if err := transition(gs, f.g, f.init, f.next); err != nil {
+			return nil, err
+		}
 		if !batches[f.batch].selected {
-			panic("frontier batch is not selected")
+			return nil, fmt.Errorf("frontier batch is not selected")
 		}
 		batches[f.batch].selected = false
 	}
@@ -203,13 +205,13 @@ func transitionReady(g uint64, curr, init gState) bool {
 	return g == unordered || (init.seq == noseq || init.seq == curr.seq) && init.status == curr.status
 }
 
-func transition(gs map[uint64]gState, g uint64, init, next gState) {
+func transition(gs map[uint64]gState, g uint64, init, next gState) error {
 	if g == unordered {
-		return
+		return nil
 	}
 	curr := gs[g]
 	if !transitionReady(g, curr, init) {
-		panic("event sequences are broken")
+		return fmt.Errorf("event sequences are broken")
 	}
 	switch next.seq {
 	case noseq:
@@ -218,6 +220,7 @@ func transition(gs map[uint64]gState, g uint64, init, next gState) {
 		next.seq = curr.seq + 1
 	}
 	gs[g] = next
+	return nil
 }
 
 // order1005 merges a set of per-P event batches into a single, consistent stream.
@@ -0,0 +1,225 @@
+package convert
+
+import (
+	"io"
+	"sort"
+	"time"
+
+	"github.com/richardartoul/molecule"
+)
+
+// ToOTLP writes the CPU samples in the parsed trace to out as an OTLP
+// ExportProfilesServiceRequest, following the development version of the
+// OpenTelemetry profiles data model
+// (opentelemetry.proto.collector.profiles.v1development, as of OTLP 1.8).
+//
+// Samples are grouped by stack and goroutine. Like the Breakdown in ToPprof,
+// each sample keeps the timestamps of the individual CPU samples, with a
+// value of 1 for each. The goroutine ID is attached to the sample as the
+// go.goroutine.id attribute. The resource attributes describe the traced
+// process, e.g. service.name.
+func ToOTLP(parsed ParseResult, start, stop time.Time, resource map[string]string, out io.Writer) error {
+	type sampleKey struct {
+		stkID uint64
+		g     uint64
+	}
+	samples := make(map[sampleKey][]uint64) // timestamps
+	var keys []sampleKey
+	base := uint64(start.UnixNano())
+	for _, ev := range parsed.Events {
+		if ev.Type != EvCPUSample {
+			continue
+		}
+		k := sampleKey{ev.StkID, ev.G}
+		if _, ok := samples[k]; !ok {
+			keys = append(keys, k)
+		}
+		samples[k] = append(samples[k], base+uint64(ev.Ts))
+	}
+
+	// The dictionary tables are shared by all the profiles in the request.
+	// Index 0 of each table must be the zero value.
+	var strtab StrTab
+	type function struct{ name, file string }
+	functions := map[function]int64{}
+	var functionList []function
+	type location struct {
+		pc       uint64
+		function int64
+		line     int
+	}
+	locations := map[location]int64{}
+	var locationList []location
+	stacks := map[uint64]int64{}
+	var stackList [][]int64
+	attributes := map[uint64]int64{} // goroutine ID to attribute index
+	var attributeList []uint64
+
+	stackIndex := func(stkID uint64) int64 {
+		if i, ok := stacks[stkID]; ok {
+			return i
+		}
+		var locs []int64
+		for _, frame := range parsed.Stacks[stkID] {
+			fn := function{frame.Fn, frame.File}
+			fi, ok := functions[fn]
+			if !ok {
+				fi = int64(len(functionList) + 1)
+				functions[fn] = fi
+				functionList = append(functionList, fn)
+			}
+			loc := location{frame.PC, fi, frame.Line}
+			li, ok := locations[loc]
+			if !ok {
+				li = int64(len(locationList) + 1)
+				locations[loc] = li
+				locationList = append(locationList, loc)
+			}
+			locs = append(locs, li)
+		}
+		i := int64(len(stackList) + 1)
+		stacks[stkID] = i
+		stackList = append(stackList, locs)
+		return i
+	}
+	attributeIndex := func(g uint64) int64 {
+		if i, ok := attributes[g]; ok {
+			return i
+		}
+		i := int64(len(attributeList) + 1)
+		attributes[g] = i
+		attributeList = append(attributeList, g)
+		return i
+	}
+
+	ps := molecule.NewProtoStream(out)
+
+	// Resource profiles, 1
+	err := ps.Embedded(1, func(ps *molecule.ProtoStream) error {
+		// Resource, 1
+		ps.Embedded(1, func(ps *molecule.ProtoStream) error {
+			var names []string
+			for k := range resource {
+				names = append(names, k)
+			}
+			sort.Strings(names)
+			for _, k := range names {
+				// Attributes, 1
+				ps.Embedded(1, func(ps *molecule.ProtoStream) error {
+					return writeOTLPAttr(ps, otlpAttr{key: k, str: resource[k]})
+				})
+			}
+			return nil
+		})
+		// Scope profiles, 2
+		return ps.Embedded(2, func(ps *molecule.ProtoStream) error {
+			// Scope, 1
+			ps.Embedded(1, func(ps *molecule.ProtoStream) error {
+				ps.String(1, "trace2timeline") // name
+				return nil
+			})
+			// Profiles, 2
+			return ps.Embedded(2, func(ps *molecule.ProtoStream) error {
+				// Sample type, 1
+				ps.Embedded(1, func(ps *molecule.ProtoStream) error {
+					ps.Int64(1, strtab.Get("samples")) // type
+					ps.Int64(2, strtab.Get("count"))   // unit
+					return nil
+				})
+				// Samples, 2
+				for _, k := range keys {
+					timestamps := samples[k]
+					values := make([]int64, len(timestamps))
+					for i := range values {
+						values[i] = 1
+					}
+					ps.Embedded(2, func(ps *molecule.ProtoStream) error {
+						ps.Int64(1, stackIndex(k.stkID))                // stack index
+						ps.Int64Packed(2, values)                       // values
+						ps.Int64Packed(3, []int64{attributeIndex(k.g)}) // attribute indices
+						ps.Fixed64Packed(5, timestamps)                 // timestamps
+						return nil
+					})
+				}
+				ps.Fixed64(3, uint64(start.UnixNano()))             // time
+				ps.Uint64(4, uint64(stop.Sub(start).Nanoseconds())) // duration
+				return nil
+			})
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	// Dictionary, 2
+	return ps.Embedded(2, func(ps *molecule.ProtoStream) error {
+		// An empty embedded message is encoded the same way as an empty
+		// string, which molecule otherwise declines to write
+		zero := func(ps *molecule.ProtoStream) error { return nil }
+
+		// Mapping table, 1
+		// The trace doesn't say anything about the binary, so there's
+		// a single placeholder mapping.
+		ps.Embedded(1, zero)
+		ps.Embedded(1, func(ps *molecule.ProtoStream) error {
+			ps.Int64(4, strtab.Get("go")) // filename
+			return nil
+		})
+		// Location table, 2
+		ps.Embedded(2, zero)
+		for _, loc := range locationList {
+			ps.Embedded(2, func(ps *molecule.ProtoStream) error {
+				ps.Int64(1, 1)       // mapping index
+				ps.Uint64(2, loc.pc) // address
+				ps.Embedded(3, func(ps *molecule.ProtoStream) error {
+					ps.Int64(1, loc.function)    // function index
+					ps.Int64(2, int64(loc.line)) // line
+					return nil
+				})
+				return nil
+			})
+		}
+		// Function table, 3
+		ps.Embedded(3, zero)
+		for _, fn := range functionList {
+			ps.Embedded(3, func(ps *molecule.ProtoStream) error {
+				ps.Int64(1, strtab.Get(fn.name)) // name
+				ps.Int64(2, strtab.Get(fn.name)) // system name
+				ps.Int64(3, strtab.Get(fn.file)) // filename
+				return nil
+			})
+		}
+		// Link table, 4
+		ps.Embedded(4, zero)
+		// Attribute table, 6
+		ps.Embedded(6, zero)
+		for _, g := range attributeList {
+			ps.Embedded(6, func(ps *molecule.ProtoStream) error {
+				ps.Int64(1, strtab.Get("go.goroutine.id")) // key
+				ps.Embedded(2, func(ps *molecule.ProtoStream) error {
+					ps.Int64(3, int64(g)) // int value
+					return nil
+				})
+				return nil
+			})
+		}
+		// Stack table, 7
+		ps.Embedded(7, zero)
+		for _, locs := range stackList {
+			ps.Embedded(7, func(ps *molecule.ProtoStream) error {
+				ps.Int64Packed(1, locs) // location indices
+				return nil
+			})
+		}
+		// String table, 5
+		// Written last, once all the strings are known
+		for _, s := range strtab.Table() {
+			if s == "" {
+				ps.Embedded(5, zero)
+				continue
+			}
+			ps.String(5, s)
+		}
+		return nil
+	})
+}
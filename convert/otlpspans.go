@@ -0,0 +1,216 @@
+package convert
+
+import (
+	"crypto/rand"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/richardartoul/molecule"
+)
+
+// otlpSpan is a span to be encoded by ToOTLPSpans.
+type otlpSpan struct {
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte // zero for a root span
+	name     string
+	start    int64
+	end      int64
+	attrs    []otlpAttr
+	events   []otlpSpanEvent
+}
+
+type otlpAttr struct {
+	key string
+	str string
+	num int64
+	// isNum selects num rather than str as the value
+	isNum bool
+}
+
+type otlpSpanEvent struct {
+	ts    int64
+	name  string
+	attrs []otlpAttr
+}
+
+// ToOTLPSpans writes the user annotations in the parsed trace to out as an
+// OTLP ExportTraceServiceRequest, so they can be sent to a distributed
+// tracing backend:
+//
+//   - Each task becomes a span, a child of its parent task's span if
+//     there is one.
+//   - Each region becomes a span, a child of the region it's nested in on
+//     its goroutine, or else of its task's span.
+//   - Each log message becomes an event on the innermost region it was
+//     logged in, or else on its task's span.
+//
+// Tasks and regions without a parent start new traces. The trace and span
+// IDs are random. The trace started at the given time, and the resource
+// attributes describe the traced process, e.g. service.name.
+func ToOTLPSpans(parsed ParseResult, start time.Time, resource map[string]string, out io.Writer) error {
+	annotations := UserAnnotations(parsed)
+	var base int64
+	if len(parsed.Events) > 0 {
+		base = parsed.Events[0].Ts
+	}
+	wall := func(ts int64) int64 { return start.UnixNano() + ts - base }
+
+	var spans []*otlpSpan
+	newSpan := func(name string, parent *otlpSpan, startTs, endTs int64) *otlpSpan {
+		s := &otlpSpan{name: name, start: wall(startTs), end: wall(endTs)}
+		rand.Read(s.spanID[:])
+		if parent != nil {
+			s.traceID = parent.traceID
+			s.parentID = parent.spanID
+		} else {
+			rand.Read(s.traceID[:])
+		}
+		spans = append(spans, s)
+		return s
+	}
+
+	taskSpans := make(map[uint64]*otlpSpan)
+	for _, t := range annotations.Tasks {
+		name := t.Name
+		if name == "" {
+			name = "task " + strconv.FormatUint(t.ID, 10)
+		}
+		s := newSpan(name, taskSpans[t.Parent], t.Start, t.End)
+		s.attrs = append(s.attrs, otlpAttr{key: "go.task.id", num: int64(t.ID), isNum: true})
+		taskSpans[t.ID] = s
+	}
+
+	type activeRegion struct {
+		Region
+		span *otlpSpan
+	}
+	active := make(map[uint64][]activeRegion) // by goroutine
+	regionsByG := make(map[uint64][]activeRegion)
+	for _, r := range annotations.Regions {
+		stack := active[r.G]
+		for len(stack) > 0 {
+			top := stack[len(stack)-1]
+			if top.End >= r.Start && top.Depth < r.Depth {
+				break
+			}
+			stack = stack[:len(stack)-1]
+		}
+		parent := taskSpans[r.Task]
+		if len(stack) > 0 {
+			parent = stack[len(stack)-1].span
+		}
+		s := newSpan(r.Name, parent, r.Start, r.End)
+		s.attrs = append(s.attrs, otlpAttr{key: "go.goroutine.id", num: int64(r.G), isNum: true})
+		ar := activeRegion{r, s}
+		active[r.G] = append(stack, ar)
+		regionsByG[r.G] = append(regionsByG[r.G], ar)
+	}
+
+	for _, l := range annotations.Logs {
+		var target *otlpSpan
+		depth := -1
+		for _, r := range regionsByG[l.G] {
+			if r.Start <= l.Ts && l.Ts <= r.End && r.Depth > depth {
+				target, depth = r.span, r.Depth
+			}
+		}
+		if target == nil {
+			target = taskSpans[l.Task]
+		}
+		if target == nil {
+			continue
+		}
+		name := l.Category
+		if name == "" {
+			name = "log"
+		}
+		target.events = append(target.events, otlpSpanEvent{
+			ts:   wall(l.Ts),
+			name: name,
+			attrs: []otlpAttr{
+				{key: "log.category", str: l.Category},
+				{key: "log.message", str: l.Message},
+				{key: "go.goroutine.id", num: int64(l.G), isNum: true},
+			},
+		})
+	}
+
+	ps := molecule.NewProtoStream(out)
+	// Resource spans, 1
+	return ps.Embedded(1, func(ps *molecule.ProtoStream) error {
+		// Resource, 1
+		ps.Embedded(1, func(ps *molecule.ProtoStream) error {
+			var names []string
+			for k := range resource {
+				names = append(names, k)
+			}
+			sort.Strings(names)
+			for _, k := range names {
+				ps.Embedded(1, func(ps *molecule.ProtoStream) error {
+					return writeOTLPAttr(ps, otlpAttr{key: k, str: resource[k]})
+				})
+			}
+			return nil
+		})
+		// Scope spans, 2
+		return ps.Embedded(2, func(ps *molecule.ProtoStream) error {
+			// Scope, 1
+			ps.Embedded(1, func(ps *molecule.ProtoStream) error {
+				ps.String(1, "trace2timeline") // name
+				return nil
+			})
+			// Spans, 2
+			for _, s := range spans {
+				ps.Embedded(2, func(ps *molecule.ProtoStream) error {
+					ps.Bytes(1, s.traceID[:]) // trace ID
+					ps.Bytes(2, s.spanID[:])  // span ID
+					if s.parentID != [8]byte{} {
+						ps.Bytes(4, s.parentID[:]) // parent span ID
+					}
+					ps.String(5, s.name)           // name
+					ps.Int32(6, 1)                 // kind, SPAN_KIND_INTERNAL
+					ps.Fixed64(7, uint64(s.start)) // start time
+					ps.Fixed64(8, uint64(s.end))   // end time
+					for _, a := range s.attrs {
+						// Attributes, 9
+						ps.Embedded(9, func(ps *molecule.ProtoStream) error {
+							return writeOTLPAttr(ps, a)
+						})
+					}
+					for _, e := range s.events {
+						// Events, 11
+						ps.Embedded(11, func(ps *molecule.ProtoStream) error {
+							ps.Fixed64(1, uint64(e.ts)) // time
+							ps.String(2, e.name)        // name
+							for _, a := range e.attrs {
+								ps.Embedded(3, func(ps *molecule.ProtoStream) error {
+									return writeOTLPAttr(ps, a)
+								})
+							}
+							return nil
+						})
+					}
+					return nil
+				})
+			}
+			return nil
+		})
+	})
+}
+
+// writeOTLPAttr writes the fields of an OTLP KeyValue.
+func writeOTLPAttr(ps *molecule.ProtoStream, a otlpAttr) error {
+	ps.String(1, a.key)
+	// AnyValue, 2
+	return ps.Embedded(2, func(ps *molecule.ProtoStream) error {
+		if a.isNum {
+			ps.Int64(3, a.num) // int value
+		} else {
+			ps.String(1, a.str) // string value
+		}
+		return nil
+	})
+}
@@ -0,0 +1,183 @@
+package convert
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"runtime"
+	"sync"
+
+	"golang.org/x/exp/trace"
+)
+
+// Batch header bytes of the trace format from Go 1.22 on, which the batches
+// of each generation start with, and the marker at the end of a generation.
+// See golang.org/x/exp/trace/internal/tracev2.
+const (
+	v2EvEventBatch        = 1
+	v2EvExperimentalBatch = 49
+	v2EvEndOfGeneration   = 52
+)
+
+// parseV2Parallel parses a trace in the format produced by Go 1.22 and
+// later, like ParseFunc. The format is split into generations, each with its
+// own string and stack tables, so the work of decoding them, which is most
+// of the work of parsing, can be done for up to GOMAXPROCS generations at
+// once. The decoded events are then translated in order, so that the state
+// of each goroutine and P carries over from one generation to the next and
+// the result is the same as translating the trace in sequence, like Stream.
+func parseV2Parallel(data []byte, keep func(*Event) bool) (ParseResult, error) {
+	if len(data) < 16 {
+		return ParseResult{}, &ParseError{Stage: "reading header", Offset: -1, Err: fmt.Errorf("trace is empty")}
+	}
+	header := data[:16]
+	if ver, err := parseHeader(header); err != nil || ver < 1022 {
+		// traces from Go 1.21 are in the old format, which has no
+		// generations to split
+		return ParseFunc(bytes.NewReader(data), "", keep)
+	}
+	batches, err := splitGenerations(data[16:])
+	if err != nil {
+		return ParseResult{}, err
+	}
+
+	gens := make([][]trace.Event, len(batches))
+	errs := make([]error, len(batches))
+	sem := make(chan struct{}, runtime.GOMAXPROCS(0))
+	var wg sync.WaitGroup
+	for i := range batches {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			r := io.MultiReader(append([]io.Reader{bytes.NewReader(header)}, batches[i]...)...)
+			gens[i], errs[i] = decodeGeneration(r)
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return ParseResult{}, err
+		}
+	}
+
+	t := newV2Translator()
+	var gen, i int
+	// last is the time of the last event, which the events of the next
+	// generation have to come after. Reading the whole trace, the reader
+	// would move them forward the same way.
+	var last trace.Time
+	next := func() (trace.Event, trace.Time, error) {
+		for gen < len(gens) && i == len(gens[gen]) {
+			gen, i = gen+1, 0
+			t.continued = true
+		}
+		if gen == len(gens) {
+			return trace.Event{}, 0, io.EOF
+		}
+		ev := gens[gen][i]
+		i++
+		ts := max(ev.Time(), last+1)
+		last = ts
+		return ev, ts, nil
+	}
+	var events []*Event
+	var final *Event
+	stacks, err := t.run(next, func(ev *Event) error {
+		if len(events) == 0 || keep == nil || keep(ev) {
+			events = append(events, ev)
+		}
+		final = ev
+		return nil
+	})
+	if err != nil {
+		return ParseResult{}, err
+	}
+	if events[len(events)-1] != final {
+		events = append(events, final)
+	}
+	return ParseResult{Events: events, Stacks: stacks}, nil
+}
+
+// decodeGeneration reads the events of a trace with a single generation
+// from r. They start with the Sync event for the generation, and leave out
+// the one which ends the trace, since in the whole trace the next
+// generation's Sync event follows instead.
+func decodeGeneration(r io.Reader) (_ []trace.Event, err error) {
+	defer recoverParse("reading events", &err)
+	tr, err := trace.NewReader(r)
+	if err != nil {
+		return nil, parseError("reading header", err)
+	}
+	var events []trace.Event
+	for {
+		ev, err := tr.ReadEvent()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, parseError("reading events", err)
+		}
+		if ev.Kind() == trace.EventSync && len(events) > 0 {
+			continue
+		}
+		events = append(events, ev)
+	}
+	return events, nil
+}
+
+// splitGenerations splits the batches of a trace in the format produced by
+// Go 1.22 and later, which follow its header, by generation. The batches of
+// each generation are returned in the order they appear.
+func splitGenerations(data []byte) ([][]io.Reader, error) {
+	batchError := func(start int, format string, args ...any) error {
+		return &ParseError{Stage: "reading batches", Offset: int64(start + 16), Err: fmt.Errorf(format, args...)}
+	}
+	var gens [][]io.Reader
+	index := make(map[uint64]int)
+	cur := -1
+	for off := 0; off < len(data); {
+		start := off
+		typ := data[off]
+		off++
+		if typ == v2EvEndOfGeneration {
+			if cur >= 0 {
+				gens[cur] = append(gens[cur], bytes.NewReader(data[start:off]))
+			}
+			continue
+		}
+		if typ != v2EvEventBatch && typ != v2EvExperimentalBatch {
+			return nil, batchError(start, "expected batch, got event type %d", typ)
+		}
+		if typ == v2EvExperimentalBatch {
+			off++ // experiment ID
+		}
+		var fields [4]uint64 // generation, M, timestamp, size
+		for i := range fields {
+			if off >= len(data) {
+				return nil, batchError(start, "truncated batch header")
+			}
+			v, n := binary.Uvarint(data[off:])
+			if n <= 0 {
+				return nil, batchError(start, "bad batch header")
+			}
+			fields[i] = v
+			off += n
+		}
+		gen, size := fields[0], fields[3]
+		if size > uint64(len(data)-off) {
+			return nil, batchError(start, "truncated batch")
+		}
+		off += int(size)
+		i, ok := index[gen]
+		if !ok {
+			i = len(gens)
+			index[gen] = i
+			gens = append(gens, nil)
+		}
+		cur = i
+		gens[i] = append(gens[i], bytes.NewReader(data[start:off]))
+	}
+	return gens, nil
+}
@@ -0,0 +1,97 @@
+package convert
+
+import (
+	"bytes"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// testdata/multigen.trace is a trace from Go 1.25 of a little over two
+// seconds, so three generations, with a goroutine blocked in a read syscall
+// from the first generation to the last.
+
+func TestParseV2ParallelMatchesStream(t *testing.T) {
+	data, err := os.ReadFile("testdata/multigen.trace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	batches, err := splitGenerations(data[16:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(batches) < 2 {
+		t.Fatalf("test trace has %d generations, want several", len(batches))
+	}
+
+	parallel, err := parseV2Parallel(data, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var streamed []*Event
+	if _, err := streamV2(bytes.NewReader(data), func(ev *Event) error {
+		streamed = append(streamed, ev)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(parallel.Events) != len(streamed) {
+		t.Errorf("parallel parse has %d events, streaming parse has %d", len(parallel.Events), len(streamed))
+	}
+	for i := range min(len(parallel.Events), len(streamed)) {
+		p, s := parallel.Events[i], streamed[i]
+		if p.Type != s.Type || p.Ts != s.Ts || p.P != s.P || p.G != s.G || p.M != s.M ||
+			p.Args != s.Args || !slices.Equal(p.SArgs, s.SArgs) || stackKey(p.Stk) != stackKey(s.Stk) {
+			t.Fatalf("event %d differs:\nparallel:  %s %+v\nstreaming: %s %+v",
+				i, EventDescriptions[p.Type].Name, *p, EventDescriptions[s.Type].Name, *s)
+		}
+	}
+}
+
+// TestBatchTypes checks the batch types splitGenerations looks for against
+// the event types of the version of golang.org/x/exp/trace in go.mod, which
+// are in an internal package, so can't be used directly.
+func TestBatchTypes(t *testing.T) {
+	out, err := exec.Command("go", "list", "-m", "-f", "{{.Dir}}", "golang.org/x/exp").Output()
+	if err != nil {
+		t.Skipf("can't find golang.org/x/exp: %v", err)
+	}
+	path := filepath.Join(strings.TrimSpace(string(out)), "trace", "internal", "tracev2", "events.go")
+	f, err := parser.ParseFile(token.NewFileSet(), path, nil, 0)
+	if err != nil {
+		t.Skipf("can't read the event types: %v", err)
+	}
+	// the event types are numbered with iota, from EvNone
+	types := make(map[string]int)
+	for _, decl := range f.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.CONST {
+			continue
+		}
+		var names []string
+		for _, spec := range gen.Specs {
+			names = append(names, spec.(*ast.ValueSpec).Names[0].Name)
+		}
+		if len(names) > 0 && names[0] == "EvNone" {
+			for i, name := range names {
+				types[name] = i
+			}
+		}
+	}
+	for name, want := range map[string]int{
+		"EvEventBatch":        v2EvEventBatch,
+		"EvExperimentalBatch": v2EvExperimentalBatch,
+		"EvEndOfGeneration":   v2EvEndOfGeneration,
+	} {
+		if got, ok := types[name]; !ok || got != want {
+			t.Errorf("%s is %d in golang.org/x/exp/trace, but %d here (found: %v)", name, got, want, ok)
+		}
+	}
+}
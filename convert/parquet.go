@@ -0,0 +1,68 @@
+package convert
+
+import (
+	"io"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// ParquetEvent is a row of ToParquet's output.
+type ParquetEvent struct {
+	// Ts is the timestamp in nanoseconds since the start of the trace
+	Ts        int64  `parquet:"ts,delta"`
+	Goroutine uint64 `parquet:"goroutine"`
+	// P is the ID of the P the event happened on, or null if it wasn't on
+	// one
+	P *int32 `parquet:"p,optional"`
+	// Type is the event type, as in EventDescriptions
+	Type string `parquet:"type,dict"`
+	// StackID is the ID of the event's stack, or null if it has none.
+	// Stack IDs are only meaningful within one trace.
+	StackID *uint64 `parquet:"stack_id,optional"`
+}
+
+// parquetBatch is how many rows ToParquet buffers before handing them to
+// the writer.
+const parquetBatch = 4096
+
+// ToParquet writes the events of a parsed trace, CPU samples included, to
+// out as a Parquet file of ParquetEvents, for DuckDB, Spark and the like.
+// The columns are compressed with zstd.
+func ToParquet(parsed ParseResult, out io.Writer) error {
+	w := parquet.NewGenericWriter[ParquetEvent](out, parquet.Compression(&parquet.Zstd))
+	var base int64
+	if len(parsed.Events) > 0 {
+		base = parsed.Events[0].Ts
+	}
+	rows := make([]ParquetEvent, 0, parquetBatch)
+	flush := func() error {
+		_, err := w.Write(rows)
+		rows = rows[:0]
+		return err
+	}
+	for _, ev := range parsed.Events {
+		row := ParquetEvent{
+			Ts:        ev.Ts - base,
+			Goroutine: ev.G,
+			Type:      EventDescriptions[ev.Type].Name,
+		}
+		if ev.P >= 0 && ev.P < FakeP {
+			p := int32(ev.P)
+			row.P = &p
+		}
+		if len(parsed.Stacks[ev.StkID]) > 0 {
+			id := ev.StkID
+			row.StackID = &id
+		}
+		rows = append(rows, row)
+		if len(rows) == parquetBatch {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+	return w.Close()
+}
@@ -2,7 +2,7 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
-package main
+package convert
 
 import (
 	"bufio"
@@ -39,10 +39,15 @@ type Event struct {
 	Ts    int64     // timestamp in nanoseconds
 	P     int       // P on which the event happened (can be one of TimerP, NetpollP, SyscallP)
 	G     uint64    // G on which the event happened
+	M     int64     // M (OS thread) on which the event happened, or -1 if unknown
 	StkID uint64    // unique stack ID
 	Stk   []*Frame  // stack trace (can be empty)
 	Args  [3]uint64 // event-type-specific arguments
 	SArgs []string  // event-type-specific string args
+	// pprof labels of the goroutine, for CPU samples, as key/value pairs
+	// sorted by key. No trace format records these so far, so they're
+	// only set if recovered some other way.
+	Labels []string
 	// linked event (can be nil), depends on event type:
 	// for GCStart: the GCStop
 	// for GCSTWStart: the GCSTWDone
@@ -66,6 +71,10 @@ type Frame struct {
 	Fn   string
 	File string
 	Line int
+	// Inlined is whether the call was inlined into the next frame, which
+	// has the same PC. It's only known for stacks symbolized with
+	// SymbolizeDWARF.
+	Inlined bool
 }
 
 const (
@@ -84,18 +93,95 @@ type ParseResult struct {
 	Events []*Event
 	// Stacks is the stack traces keyed by stack IDs from the trace.
 	Stacks map[uint64][]*Frame
+	// ignoredStacks are the IDs of stacks whose samples Filter.Apply
+	// rejected, for profiles to leave out.
+	ignoredStacks map[uint64]bool
 }
 
-// Parse parses, post-processes and verifies the trace.
+// Parse parses, post-processes and verifies the trace. The trace format
+// version is detected from the header: traces from Go 1.5 to 1.20 are read by
+// the parser here, and any later version, from Go 1.21 on, is handed off to
+// ParseV2. Traces compressed with gzip or zstd are decompressed as they are
+// read.
 func Parse(r io.Reader, bin string) (ParseResult, error) {
-	ver, res, err := parse(r, bin)
+	return ParseFunc(r, bin, nil)
+}
+
+// ParseFunc parses the trace like Parse, but only keeps the events for which
+// keep returns true, along with the first and last events so that the
+// trace's duration is unchanged. Converters which only need a few kinds of
+// events can use it to avoid holding the whole trace in memory. A nil keep
+// keeps every event.
+func ParseFunc(r io.Reader, bin string, keep func(*Event) bool) (ParseResult, error) {
+	var events []*Event
+	var last *Event
+	stacks, err := Stream(r, bin, func(ev *Event) error {
+		if len(events) == 0 || keep == nil || keep(ev) {
+			events = append(events, ev)
+		}
+		last = ev
+		return nil
+	})
 	if err != nil {
 		return ParseResult{}, err
 	}
+	if last != nil && events[len(events)-1] != last {
+		events = append(events, last)
+	}
+	return ParseResult{Events: events, Stacks: stacks}, nil
+}
+
+// ParseBytes parses a trace held in memory, like ParseFunc. The trace can be
+// a memory-mapped file, since the result doesn't refer to data. Since the
+// whole trace is at hand, traces which Parse would hand off to ParseV2 are
+// decoded in parallel, a generation at a time, where the format allows it.
+func ParseBytes(data []byte, bin string, keep func(*Event) bool) (ParseResult, error) {
+	if len(data) >= 16 && !compressed(data) {
+		if ver, err := parseHeader(data[:16]); err == nil && !legacyVersion(ver) {
+			return parseV2Parallel(data, keep)
+		}
+	}
+	return ParseFunc(bytes.NewReader(data), bin, keep)
+}
+
+// Stream parses the trace like Parse, but passes each event to fn in time
+// order as it goes rather than collecting them, and returns just the stacks.
+// Traces from Go 1.22 and later are read incrementally, so that memory use
+// is bounded by the stack and string tables and the events of one
+// generation. Older traces can only be put in order once they have been
+// read completely, so they are held in memory regardless. If fn returns an
+// error, parsing stops and Stream returns it.
+func Stream(r io.Reader, bin string, fn func(*Event) error) (_ map[uint64][]*Frame, err error) {
+	defer recoverParse("parsing", &err)
+	br, done, err := decompress(r)
+	if err != nil {
+		return nil, parseError("decompressing", err)
+	}
+	defer done()
+	header, err := br.Peek(16)
+	if err != nil {
+		return nil, parseError("reading header", fmt.Errorf("read %v bytes: %w", len(header), err))
+	}
+	hver, err := parseHeader(header)
+	if err != nil {
+		return nil, parseError("reading header", err)
+	}
+	if !legacyVersion(hver) {
+		return streamV2(br, fn)
+	}
+	ver, res, err := parse(br, bin)
+	if err != nil {
+		return nil, err
+	}
 	if ver < 1007 && bin == "" {
-		return ParseResult{}, fmt.Errorf("for traces produced by go 1.6 or below, the binary argument must be provided")
+		return nil, ErrBinaryRequired
 	}
-	return res, nil
+	for _, ev := range res.Events {
+		if err := fn(ev); err != nil {
+			return nil, err
+		}
+	}
+	return res.Stacks, nil
 }
 
 // parse parses, post-processes and verifies the trace. It returns the
@@ -103,16 +189,16 @@ func Parse(r io.Reader, bin string) (ParseResult, error) {
 func parse(r io.Reader, bin string) (int, ParseResult, error) {
 	ver, rawEvents, strings, err := readTrace(r)
 	if err != nil {
-		return 0, ParseResult{}, err
+		return 0, ParseResult{}, parseError("reading events", err)
 	}
 	events, stacks, err := parseEvents(ver, rawEvents, strings)
 	if err != nil {
-		return 0, ParseResult{}, err
+		return 0, ParseResult{}, parseError("decoding events", err)
 	}
 	events = removeFutile(events)
 	err = postProcessTrace(ver, events)
 	if err != nil {
-		return 0, ParseResult{}, err
+		return 0, ParseResult{}, parseError("checking events", err)
 	}
 	// Attach stack traces.
 	for _, ev := range events {
@@ -122,7 +208,7 @@ func parse(r io.Reader, bin string) (int, ParseResult, error) {
 	}
 	if ver < 1007 && bin != "" {
 		if err := symbolize(events, bin); err != nil {
-			return 0, ParseResult{}, err
+			return 0, ParseResult{}, fmt.Errorf("symbolizing: %w", err)
 		}
 	}
 	return ver, ParseResult{Events: events, Stacks: stacks}, nil
@@ -150,12 +236,7 @@ func readTrace(r io.Reader) (ver int, events []rawEvent, strings map[uint64]stri
 	if err != nil {
 		return
 	}
-	switch ver {
-	case 1005, 1007, 1008, 1009, 1010, 1011, 1019:
-		// Note: When adding a new version, confirm that canned traces from the
-		// old version are part of the test suite. Add them using mkcanned.bash.
-		break
-	default:
+	if !legacyVersion(ver) {
 		err = fmt.Errorf("unsupported trace file version %v.%v (update Go toolchain) %v", ver/1000, ver%1000, ver)
 		return
 	}
@@ -288,6 +369,18 @@ func readStr(r io.Reader, off0 int) (s string, off int, err error) {
 	return string(buf), off + n, nil
 }
 
+// legacyVersion reports whether traces with the given version are handled
+// by this parser rather than ParseV2.
+func legacyVersion(ver int) bool {
+	switch ver {
+	case 1005, 1007, 1008, 1009, 1010, 1011, 1019:
+		// Note: When adding a new version, confirm that canned traces from the
+		// old version are part of the test suite. Add them using mkcanned.bash.
+		return true
+	}
+	return false
+}
+
 // parseHeader parses trace header of the form "go 1.7 trace\x00\x00\x00\x00"
 // and returns parsed version as 1007.
 func parseHeader(buf []byte) (int, error) {
@@ -394,7 +487,7 @@ func parseEvents(ver int, rawEvents []rawEvent, strings map[uint64]string) (even
 				stacks[id] = stk
 			}
 		default:
-			e := &Event{Off: raw.off, Type: raw.typ, P: lastP, G: lastG}
+			e := &Event{Off: raw.off, Type: raw.typ, P: lastP, G: lastG, M: -1}
 			var argOffset int
 			if ver < 1007 {
 				e.seq = lastSeq + int64(raw.args[0])
@@ -869,11 +962,15 @@ func postProcessTrace(ver int, events []*Event) error {
 
 // symbolize attaches func/file/line info to stack traces.
 func symbolize(events []*Event, bin string) error {
-	// First, collect and dedup all pcs.
+	// First, collect and dedup all pcs. Frames without a PC are left
+	// alone: there's nothing to look up, and they'd all end up as the
+	// same frame.
 	pcs := make(map[uint64]*Frame)
 	for _, ev := range events {
 		for _, f := range ev.Stk {
-			pcs[f.PC] = nil
+			if f.PC != 0 {
+				pcs[f.PC] = nil
+			}
 		}
 	}
 
@@ -933,7 +1030,9 @@ func symbolize(events []*Event, bin string) error {
 	// Replace frames in events array.
 	for _, ev := range events {
 		for i, f := range ev.Stk {
-			ev.Stk[i] = pcs[f.PC]
+			if f.PC != 0 {
+				ev.Stk[i] = pcs[f.PC]
+			}
 		}
 	}
 
@@ -0,0 +1,480 @@
+package convert
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"golang.org/x/exp/trace"
+)
+
+// ParseV2 parses a trace in the format produced by Go 1.22 and later using
+// golang.org/x/exp/trace, which also reads the older format of Go 1.21.
+//
+// The events are translated into the same Event model that Parse produces
+// for older traces, so the rest of the conversion doesn't need to know which
+// format the trace came from. Some information is lost along the way: the
+// new format tracks goroutine and P state much more precisely than the old
+// event types can describe.
+func ParseV2(r io.Reader) (ParseResult, error) {
+	var events []*Event
+	stacks, err := streamV2(r, func(ev *Event) error {
+		events = append(events, ev)
+		return nil
+	})
+	if err != nil {
+		return ParseResult{}, err
+	}
+	return ParseResult{Events: events, Stacks: stacks}, nil
+}
+
+// streamV2 parses a trace in the format produced by Go 1.22 and later like
+// ParseV2, but passes the events to fn as it goes rather than collecting
+// them. The new format is split into generations, which the runtime starts
+// every second or so, and only one generation's events are held at once.
+func streamV2(r io.Reader, fn func(*Event) error) (map[uint64][]*Frame, error) {
+	tr, err := trace.NewReader(r)
+	if err != nil {
+		return nil, parseError("reading header", err)
+	}
+	t := newV2Translator()
+	return t.run(func() (trace.Event, trace.Time, error) {
+		ev, err := tr.ReadEvent()
+		return ev, ev.Time(), err
+	}, fn)
+}
+
+func newV2Translator() *v2Translator {
+	return &v2Translator{
+		stackIDs:   make(map[trace.Stack]uint64),
+		stacks:     make(map[uint64][]*Frame),
+		gs:         make(map[uint64]*v2G),
+		procG:      make(map[int]uint64),
+		firstEvent: true,
+	}
+}
+
+// run translates the events next returns, along with the time of each,
+// until it returns io.EOF, and passes them to fn a generation at a time.
+// It returns the stacks of the translated events.
+func (t *v2Translator) run(next func() (trace.Event, trace.Time, error), fn func(*Event) error) (map[uint64][]*Frame, error) {
+	n := 0
+	flush := func() error {
+		// Some events (e.g. syscall blocks) are only discovered after
+		// the fact and get back-dated, so put everything back in time
+		// order.
+		sort.Stable(eventList(t.events))
+		for _, ev := range t.events {
+			if ev.StkID != 0 {
+				ev.Stk = t.stacks[ev.StkID]
+			}
+			if err := fn(ev); err != nil {
+				return err
+			}
+		}
+		n += len(t.events)
+		t.events = t.events[:0]
+		return nil
+	}
+	for {
+		ev, ts, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, parseError("reading events", err)
+		}
+		if ev.Kind() == trace.EventSync {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+		}
+		if err := t.translate(ev, ts); err != nil {
+			return nil, parseError("translating events", err)
+		}
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, &ParseError{Stage: "reading events", Offset: -1, Err: fmt.Errorf("trace is empty")}
+	}
+	return t.stacks, nil
+}
+
+// v2G is the state the translator keeps for each goroutine.
+type v2G struct {
+	// start is the event which most recently started the goroutine
+	// running, so that a following label event can be attached to it.
+	start *Event
+	// block is the type of the event which most recently blocked the
+	// goroutine.
+	block byte
+	// syscall is the event which started the goroutine's current
+	// syscall, and sysBlocked is whether the goroutine has been reported
+	// as blocked in it.
+	syscall    *Event
+	sysBlocked bool
+}
+
+type v2Translator struct {
+	events     []*Event
+	stackIDs   map[trace.Stack]uint64
+	stacks     map[uint64][]*Frame
+	gs         map[uint64]*v2G
+	procG      map[int]uint64 // goroutine last running on each P
+	minTs      trace.Time
+	firstEvent bool
+	// now is the time of the event being translated
+	now trace.Time
+	// continued is set when translating the generations after the first
+	// which were read on their own, so that the states each of them
+	// starts by describing look undetermined. Those states were already
+	// reported by the generation before.
+	continued bool
+}
+
+func (t *v2Translator) g(id uint64) *v2G {
+	g, ok := t.gs[id]
+	if !ok {
+		g = new(v2G)
+		t.gs[id] = g
+	}
+	return g
+}
+
+// stackID interns the stack and returns its ID, or 0 if there is no stack.
+func (t *v2Translator) stackID(stk trace.Stack) uint64 {
+	if stk == trace.NoStack {
+		return 0
+	}
+	if id, ok := t.stackIDs[stk]; ok {
+		return id
+	}
+	var frames []*Frame
+	for f := range stk.Frames() {
+		frames = append(frames, &Frame{PC: f.PC, Fn: f.Func, File: f.File, Line: int(f.Line)})
+	}
+	if len(frames) == 0 {
+		t.stackIDs[stk] = 0
+		return 0
+	}
+	id := uint64(len(t.stacks) + 1)
+	t.stackIDs[stk] = id
+	t.stacks[id] = frames
+	return id
+}
+
+func v2Proc(p trace.ProcID) int {
+	if p == trace.NoProc {
+		return FakeP
+	}
+	return int(p)
+}
+
+func v2Goroutine(g trace.GoID) uint64 {
+	if g == trace.NoGoroutine {
+		return 0
+	}
+	return uint64(g)
+}
+
+func v2Thread(m trace.ThreadID) int64 {
+	if m == trace.NoThread {
+		return -1
+	}
+	return int64(m)
+}
+
+// emit records a new event of the given type in the context (time, P, G, M
+// and stack) of ev.
+func (t *v2Translator) emit(ev trace.Event, typ byte) *Event {
+	e := &Event{
+		Type:  typ,
+		Ts:    int64(t.now - t.minTs),
+		P:     v2Proc(ev.Proc()),
+		G:     v2Goroutine(ev.Goroutine()),
+		M:     v2Thread(ev.Thread()),
+		StkID: t.stackID(ev.Stack()),
+	}
+	t.events = append(t.events, e)
+	return e
+}
+
+// translate translates ev, which happened at ts. That's ev.Time(), unless
+// the caller has to correct it.
+func (t *v2Translator) translate(ev trace.Event, ts trace.Time) error {
+	t.now = ts
+	if t.firstEvent {
+		t.minTs = ts
+		t.firstEvent = false
+	}
+	switch ev.Kind() {
+	case trace.EventMetric:
+		m := ev.Metric()
+		var typ byte
+		switch m.Name {
+		case "/sched/gomaxprocs:threads":
+			typ = EvGomaxprocs
+		case "/memory/classes/heap/objects:bytes":
+			typ = EvHeapAlloc
+		case "/gc/heap/goal:bytes":
+			typ = EvHeapGoal
+		default:
+			return nil
+		}
+		e := t.emit(ev, typ)
+		e.Args[0] = m.Value.Uint64()
+	case trace.EventLabel:
+		l := ev.Label()
+		g := t.g(uint64(l.Resource.Goroutine()))
+		if g.start != nil {
+			g.start.Type = EvGoStartLabel
+			g.start.SArgs = []string{l.Label}
+		}
+	case trace.EventStackSample:
+		t.emit(ev, EvCPUSample)
+	case trace.EventRangeBegin, trace.EventRangeActive, trace.EventRangeEnd:
+		if ev.Kind() == trace.EventRangeActive && t.continued {
+			return nil
+		}
+		t.translateRange(ev)
+	case trace.EventTaskBegin:
+		task := ev.Task()
+		e := t.emit(ev, EvUserTaskCreate)
+		e.Args[0] = uint64(task.ID)
+		e.Args[1] = uint64(task.Parent)
+		e.SArgs = []string{task.Type}
+	case trace.EventTaskEnd:
+		e := t.emit(ev, EvUserTaskEnd)
+		e.Args[0] = uint64(ev.Task().ID)
+	case trace.EventRegionBegin, trace.EventRegionEnd:
+		r := ev.Region()
+		e := t.emit(ev, EvUserRegion)
+		e.Args[0] = uint64(r.Task)
+		if ev.Kind() == trace.EventRegionEnd {
+			e.Args[1] = 1
+		}
+		e.SArgs = []string{r.Type}
+	case trace.EventLog:
+		l := ev.Log()
+		e := t.emit(ev, EvUserLog)
+		e.Args[0] = uint64(l.Task)
+		e.SArgs = []string{l.Category, l.Message}
+	case trace.EventStateTransition:
+		st := ev.StateTransition()
+		switch st.Resource.Kind {
+		case trace.ResourceProc:
+			t.translateProc(ev, st)
+		case trace.ResourceGoroutine:
+			t.translateGoroutine(ev, st)
+		}
+	}
+	return nil
+}
+
+func (t *v2Translator) translateRange(ev trace.Event) {
+	r := ev.Range()
+	begin := ev.Kind() != trace.EventRangeEnd
+	var typ byte
+	switch {
+	case strings.HasPrefix(r.Name, "stop-the-world"):
+		typ = EvGCSTWDone
+		if begin {
+			typ = EvGCSTWStart
+		}
+	case r.Name == "GC concurrent mark phase":
+		typ = EvGCDone
+		if begin {
+			typ = EvGCStart
+		}
+	case r.Name == "GC incremental sweep":
+		typ = EvGCSweepDone
+		if begin {
+			typ = EvGCSweepStart
+		}
+	case r.Name == "GC mark assist":
+		typ = EvGCMarkAssistDone
+		if begin {
+			typ = EvGCMarkAssistStart
+		}
+	default:
+		return
+	}
+	e := t.emit(ev, typ)
+	switch typ {
+	case EvGCStart, EvGCDone:
+		e.P = GCP
+		e.G = 0
+	case EvGCSTWStart:
+		// The STW reason is only in the range name,
+		// e.g. "stop-the-world (GC mark termination)"
+		reason := strings.TrimSuffix(strings.TrimPrefix(r.Name, "stop-the-world ("), ")")
+		e.SArgs = []string{reason}
+		e.G = 0
+	case EvGCSTWDone:
+		e.G = 0
+	case EvGCMarkAssistStart, EvGCMarkAssistDone:
+		if ev.Kind() == trace.EventRangeActive {
+			e.G = v2Goroutine(r.Scope.Goroutine())
+		}
+	case EvGCSweepStart, EvGCSweepDone:
+		if ev.Kind() == trace.EventRangeActive {
+			e.P = v2Proc(r.Scope.Proc())
+		}
+	}
+}
+
+func (t *v2Translator) translateProc(ev trace.Event, st trace.StateTransition) {
+	from, to := st.Proc()
+	p := v2Proc(st.Resource.Proc())
+	switch {
+	case from == to, from == trace.ProcUndetermined && t.continued:
+	case to == trace.ProcRunning:
+		e := t.emit(ev, EvProcStart)
+		e.P = p
+		e.Args[0] = uint64(ev.Thread())
+	case from == trace.ProcRunning:
+		// The P was either stopped or stolen from a goroutine in a
+		// syscall. In the latter case, this is the moment that
+		// goroutine blocked in the old format's terms.
+		if id, ok := t.procG[p]; ok {
+			if g := t.g(id); g.syscall != nil && !g.sysBlocked {
+				e := t.emit(ev, EvGoSysBlock)
+				e.P = p
+				e.G = id
+				e.StkID = 0
+				g.sysBlocked = true
+			}
+		}
+		e := t.emit(ev, EvProcStop)
+		e.P = p
+		e.G = 0
+		e.StkID = 0
+	}
+}
+
+// blockReasons maps the block reasons in the new format to the old blocking
+// event types.
+var blockReasons = map[string]byte{
+	"forever":                      EvGoStop,
+	"network":                      EvGoBlockNet,
+	"select":                       EvGoBlockSelect,
+	"sync.(*Cond).Wait":            EvGoBlockCond,
+	"sync":                         EvGoBlockSync,
+	"chan send":                    EvGoBlockSend,
+	"chan receive":                 EvGoBlockRecv,
+	"GC mark assist wait for work": EvGoBlockGC,
+	"sleep":                        EvGoSleep,
+}
+
+func (t *v2Translator) translateGoroutine(ev trace.Event, st trace.StateTransition) {
+	from, to := st.Goroutine()
+	id := v2Goroutine(st.Resource.Goroutine())
+	g := t.g(id)
+	if from == to {
+		return
+	}
+	switch from {
+	case trace.GoUndetermined, trace.GoNotExist:
+		if to == trace.GoNotExist || from == trace.GoUndetermined && t.continued {
+			return
+		}
+		// Goroutines which existed before tracing started are reported
+		// as created by g 0, like the old format does.
+		e := t.emit(ev, EvGoCreate)
+		e.Args[0] = id
+		e.Args[1] = t.stackID(st.Stack)
+		if from == trace.GoUndetermined {
+			e.G = 0
+			e.StkID = 0
+		}
+		switch to {
+		case trace.GoWaiting:
+			e := t.emit(ev, EvGoWaiting)
+			e.G = id
+			e.Args[0] = id
+			e.StkID = 0
+		case trace.GoSyscall:
+			e := t.emit(ev, EvGoInSyscall)
+			e.G = id
+			e.Args[0] = id
+			e.StkID = 0
+			g.syscall = e
+			g.sysBlocked = true
+		case trace.GoRunning:
+			t.goStart(ev, g, id)
+		}
+	case trace.GoRunnable:
+		if to == trace.GoRunning {
+			t.goStart(ev, g, id)
+		}
+	case trace.GoRunning:
+		switch to {
+		case trace.GoRunnable:
+			typ := byte(EvGoSched)
+			if st.Reason == "preempted" {
+				typ = EvGoPreempt
+			}
+			t.emit(ev, typ).G = id
+		case trace.GoWaiting:
+			typ, ok := blockReasons[st.Reason]
+			if !ok {
+				typ = EvGoBlock
+			}
+			g.block = typ
+			t.emit(ev, typ).G = id
+		case trace.GoSyscall:
+			e := t.emit(ev, EvGoSysCall)
+			e.G = id
+			g.syscall = e
+			g.sysBlocked = false
+		case trace.GoNotExist:
+			e := t.emit(ev, EvGoEnd)
+			e.G = id
+			e.StkID = 0
+		}
+	case trace.GoWaiting:
+		if to == trace.GoRunnable {
+			e := t.emit(ev, EvGoUnblock)
+			e.Args[0] = id
+			if g.block == EvGoBlockNet && ev.Goroutine() == trace.NoGoroutine {
+				e.P = NetpollP
+			}
+		}
+	case trace.GoSyscall:
+		switch to {
+		case trace.GoRunnable:
+			if !g.sysBlocked {
+				e := t.emit(ev, EvGoSysBlock)
+				e.G = id
+				e.StkID = 0
+			}
+			e := t.emit(ev, EvGoSysExit)
+			e.P = SyscallP
+			e.G = id
+			e.Args[0] = id
+			e.StkID = 0
+			g.syscall = nil
+		case trace.GoRunning:
+			// A syscall which returned without losing its P.
+			// The old format doesn't have an event for this.
+			g.syscall = nil
+		case trace.GoNotExist:
+			e := t.emit(ev, EvGoEnd)
+			e.G = id
+			e.StkID = 0
+			g.syscall = nil
+		}
+	}
+}
+
+func (t *v2Translator) goStart(ev trace.Event, g *v2G, id uint64) {
+	e := t.emit(ev, EvGoStart)
+	e.G = id
+	e.Args[0] = id
+	e.StkID = 0
+	g.start = e
+	t.procG[e.P] = id
+}
@@ -0,0 +1,339 @@
+package convert
+
+import (
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/richardartoul/molecule"
+	"github.com/richardartoul/molecule/src/protowire"
+)
+
+// Track UUIDs for the Perfetto trace. Per-goroutine and per-P tracks are
+// offset by the ID of the goroutine or P.
+const (
+	perfettoProcessTrack    = 1
+	perfettoHeapAllocTrack  = 2
+	perfettoHeapGoalTrack   = 3
+	perfettoGoroutinesTrack = 4
+	perfettoGCTrack         = 5
+
+	perfettoGoroutineTracks = 1 << 32
+	perfettoRegionTracks    = 2 << 32
+	perfettoProcTracks      = 3 << 32
+
+	// All the packets are written on one sequence so that they can share
+	// interned data.
+	perfettoSequence = 1
+	// The pid used for the traced process
+	perfettoPid = 1
+)
+
+// TrackEvent types
+const (
+	perfettoSliceBegin = 1
+	perfettoSliceEnd   = 2
+	perfettoCounter    = 4
+)
+
+// CounterDescriptor units
+const (
+	perfettoUnitCount = 2
+	perfettoUnitBytes = 3
+)
+
+// perfettoPacket is a TracePacket waiting to be written. The packets are
+// gathered up front and sorted by time, since Perfetto expects each track's
+// slice begin and end events in order.
+type perfettoPacket struct {
+	ts int64
+	// end is set for slice end events, which need to come before any
+	// slice begin event at the same time on the same track
+	end   bool
+	write func(ps *molecule.ProtoStream) error
+}
+
+// ToPerfetto writes the parsed trace to out as a Perfetto Trace protobuf,
+// which can be loaded into ui.perfetto.dev. The trace has a track for each
+// goroutine with slices for its scheduling states, child tracks for the
+// goroutines' user regions, a track for each P showing which goroutine it
+// was running, counter tracks for the heap size and number of goroutines, and
+// perf samples for the CPU samples. Timestamps are offset from start, the
+// wall clock time at which tracing started.
+func ToPerfetto(parsed ParseResult, start time.Time, out io.Writer) error {
+	base := start.UnixNano()
+	var packets []perfettoPacket
+	add := func(ts int64, end bool, write func(ps *molecule.ProtoStream) error) {
+		packets = append(packets, perfettoPacket{ts: base + ts, end: end, write: write})
+	}
+	sliceEvent := func(ts int64, track uint64, typ int64, name string) {
+		add(ts, typ == perfettoSliceEnd, func(ps *molecule.ProtoStream) error {
+			return ps.Embedded(11, func(ps *molecule.ProtoStream) error {
+				ps.Int64(9, typ)     // type
+				ps.Uint64(11, track) // track UUID
+				ps.String(23, name)  // name
+				return nil
+			})
+		})
+	}
+	counterEvent := func(ts int64, track uint64, value int64) {
+		add(ts, false, func(ps *molecule.ProtoStream) error {
+			// Written by hand, since molecule won't write a zero
+			// counter value
+			var b []byte
+			b = protowire.AppendVarint(b, 9<<3) // field, wire type
+			b = protowire.AppendVarint(b, perfettoCounter)
+			b = protowire.AppendVarint(b, 11<<3)
+			b = protowire.AppendVarint(b, track)
+			b = protowire.AppendVarint(b, 30<<3)
+			b = protowire.AppendVarint(b, uint64(value))
+			return ps.Bytes(11, b)
+		})
+	}
+
+	var descriptors []func(ps *molecule.ProtoStream) error
+	describe := func(uuid, parent uint64, name string, thread uint64, counterUnit int64) {
+		descriptors = append(descriptors, func(ps *molecule.ProtoStream) error {
+			return ps.Embedded(60, func(ps *molecule.ProtoStream) error {
+				ps.Uint64(1, uuid)   // uuid
+				ps.Uint64(5, parent) // parent UUID
+				ps.String(2, name)   // name
+				if thread != 0 {
+					ps.Embedded(4, func(ps *molecule.ProtoStream) error {
+						ps.Int64(1, perfettoPid)   // pid
+						ps.Int64(2, int64(thread)) // tid
+						ps.String(5, name)         // thread name
+						return nil
+					})
+				}
+				if counterUnit != 0 {
+					ps.Embedded(8, func(ps *molecule.ProtoStream) error {
+						ps.Int64(3, counterUnit) // unit
+						return nil
+					})
+				}
+				return nil
+			})
+		})
+	}
+	describe(perfettoHeapAllocTrack, perfettoProcessTrack, "Heap in use", 0, perfettoUnitBytes)
+	describe(perfettoHeapGoalTrack, perfettoProcessTrack, "Heap goal", 0, perfettoUnitBytes)
+	describe(perfettoGoroutinesTrack, perfettoProcessTrack, "Goroutines", 0, perfettoUnitCount)
+	describe(perfettoGCTrack, perfettoProcessTrack, "GC", 0, 0)
+
+	// Goroutine and P tracks
+	gName := func(g uint64) string { return "G" + strconv.FormatUint(g, 10) }
+	goroutines := make(map[uint64]bool)
+	procs := make(map[int]bool)
+	for _, in := range GoroutineIntervals(parsed) {
+		if in.G == 0 {
+			continue
+		}
+		if !goroutines[in.G] {
+			goroutines[in.G] = true
+			describe(perfettoGoroutineTracks+in.G, perfettoProcessTrack, gName(in.G), in.G, 0)
+		}
+		name := in.State.String()
+		if in.State == StateBlocked {
+			name = EventDescriptions[in.Reason].Name
+		}
+		track := perfettoGoroutineTracks + in.G
+		sliceEvent(in.Start, track, perfettoSliceBegin, name)
+		sliceEvent(in.End, track, perfettoSliceEnd, "")
+		if in.State == StateRunning && in.P >= 0 && in.P < FakeP {
+			if !procs[in.P] {
+				procs[in.P] = true
+				describe(perfettoProcTracks+uint64(in.P), perfettoProcessTrack, "P"+strconv.Itoa(in.P), 0, 0)
+			}
+			track := perfettoProcTracks + uint64(in.P)
+			sliceEvent(in.Start, track, perfettoSliceBegin, gName(in.G))
+			sliceEvent(in.End, track, perfettoSliceEnd, "")
+		}
+	}
+
+	// Interned frames and callstacks for the CPU samples
+	functionIDs := make(map[string]uint64)
+	type frameKey struct {
+		pc uint64
+		fn string
+	}
+	frameIDs := make(map[frameKey]uint64)
+	callstackIDs := make(map[uint64]uint64)
+	var interned []func(ps *molecule.ProtoStream) error
+	intern := func(stkID uint64) uint64 {
+		if id, ok := callstackIDs[stkID]; ok {
+			return id
+		}
+		stk := parsed.Stacks[stkID]
+		var frames []uint64
+		// Perfetto callstacks start at the root
+		for i := len(stk) - 1; i >= 0; i-- {
+			f := stk[i]
+			fnID, ok := functionIDs[f.Fn]
+			if !ok {
+				fnID = uint64(len(functionIDs) + 1)
+				functionIDs[f.Fn] = fnID
+				interned = append(interned, func(ps *molecule.ProtoStream) error {
+					return ps.Embedded(5, func(ps *molecule.ProtoStream) error {
+						ps.Uint64(1, fnID) // iid
+						ps.String(2, f.Fn) // str
+						return nil
+					})
+				})
+			}
+			// Keyed on the function as well as the PC, since
+			// frames without PCs all have PC 0
+			key := frameKey{f.PC, f.Fn}
+			frameID, ok := frameIDs[key]
+			if !ok {
+				frameID = uint64(len(frameIDs) + 1)
+				frameIDs[key] = frameID
+				interned = append(interned, func(ps *molecule.ProtoStream) error {
+					return ps.Embedded(6, func(ps *molecule.ProtoStream) error {
+						ps.Uint64(1, frameID) // iid
+						ps.Uint64(2, fnID)    // function name ID
+						ps.Uint64(3, 1)       // mapping ID
+						ps.Uint64(4, f.PC)    // relative PC
+						return nil
+					})
+				})
+			}
+			frames = append(frames, frameID)
+		}
+		id := uint64(len(callstackIDs) + 1)
+		callstackIDs[stkID] = id
+		interned = append(interned, func(ps *molecule.ProtoStream) error {
+			return ps.Embedded(7, func(ps *molecule.ProtoStream) error {
+				ps.Uint64(1, id) // iid
+				for _, f := range frames {
+					ps.Uint64(2, f) // frame IDs
+				}
+				return nil
+			})
+		})
+		return id
+	}
+
+	// Regions, counters, GC, and CPU samples
+	regionTracks := make(map[uint64]bool)
+	live := int64(0)
+	var gcStart *Event
+	for _, ev := range parsed.Events {
+		switch ev.Type {
+		case EvUserRegion:
+			track := perfettoRegionTracks + ev.G
+			if !regionTracks[ev.G] {
+				regionTracks[ev.G] = true
+				describe(track, perfettoGoroutineTracks+ev.G, gName(ev.G)+" regions", 0, 0)
+			}
+			if ev.Args[1] == 0 {
+				sliceEvent(ev.Ts, track, perfettoSliceBegin, ev.SArgs[0])
+			} else {
+				sliceEvent(ev.Ts, track, perfettoSliceEnd, "")
+			}
+		case EvHeapAlloc:
+			counterEvent(ev.Ts, perfettoHeapAllocTrack, int64(ev.Args[0]))
+		case EvHeapGoal:
+			counterEvent(ev.Ts, perfettoHeapGoalTrack, int64(ev.Args[0]))
+		case EvGoCreate:
+			live++
+			counterEvent(ev.Ts, perfettoGoroutinesTrack, live)
+		case EvGoEnd:
+			live--
+			counterEvent(ev.Ts, perfettoGoroutinesTrack, live)
+		case EvGCStart:
+			gcStart = ev
+		case EvGCDone:
+			if gcStart != nil {
+				sliceEvent(gcStart.Ts, perfettoGCTrack, perfettoSliceBegin, "GC")
+				sliceEvent(ev.Ts, perfettoGCTrack, perfettoSliceEnd, "")
+				gcStart = nil
+			}
+		case EvCPUSample:
+			callstack := intern(ev.StkID)
+			g, p := ev.G, ev.P
+			add(ev.Ts, false, func(ps *molecule.ProtoStream) error {
+				ps.Uint32(13, 2) // sequence flags: SEQ_NEEDS_INCREMENTAL_STATE
+				return ps.Embedded(66, func(ps *molecule.ProtoStream) error {
+					if p >= 0 && p < FakeP {
+						ps.Uint32(1, uint32(p)) // cpu
+					}
+					ps.Uint32(2, perfettoPid) // pid
+					ps.Uint32(3, uint32(g))   // tid
+					ps.Uint64(4, callstack)   // callstack iid
+					return nil
+				})
+			})
+		}
+	}
+
+	sort.SliceStable(packets, func(i, j int) bool {
+		if packets[i].ts != packets[j].ts {
+			return packets[i].ts < packets[j].ts
+		}
+		return packets[i].end && !packets[j].end
+	})
+
+	ps := molecule.NewProtoStream(out)
+	// The first packet resets the incremental state for the sequence,
+	// describes the process, and carries all the interned data
+	err := ps.Embedded(1, func(ps *molecule.ProtoStream) error {
+		ps.Int64(8, base)               // timestamp
+		ps.Uint32(10, perfettoSequence) // trusted packet sequence ID
+		ps.Uint32(13, 1)                // sequence flags: SEQ_INCREMENTAL_STATE_CLEARED
+		ps.Embedded(60, func(ps *molecule.ProtoStream) error {
+			ps.Uint64(1, perfettoProcessTrack) // uuid
+			ps.Embedded(3, func(ps *molecule.ProtoStream) error {
+				ps.Int64(1, perfettoPid) // pid
+				ps.String(6, "Go")       // process name
+				return nil
+			})
+			return nil
+		})
+		return ps.Embedded(12, func(ps *molecule.ProtoStream) error {
+			// A single placeholder mapping, since the trace doesn't
+			// say anything about the binary
+			ps.Embedded(17, func(ps *molecule.ProtoStream) error {
+				ps.Uint64(1, 1)    // iid
+				ps.String(2, "go") // str
+				return nil
+			})
+			ps.Embedded(19, func(ps *molecule.ProtoStream) error {
+				ps.Uint64(1, 1) // iid
+				ps.Uint64(7, 1) // path string IDs
+				return nil
+			})
+			for _, write := range interned {
+				if err := write(ps); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+	for _, write := range descriptors {
+		err := ps.Embedded(1, func(ps *molecule.ProtoStream) error {
+			ps.Int64(8, base)               // timestamp
+			ps.Uint32(10, perfettoSequence) // trusted packet sequence ID
+			return write(ps)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for _, p := range packets {
+		err := ps.Embedded(1, func(ps *molecule.ProtoStream) error {
+			ps.Int64(8, p.ts)               // timestamp
+			ps.Uint32(10, perfettoSequence) // trusted packet sequence ID
+			return p.write(ps)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
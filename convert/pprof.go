@@ -0,0 +1,623 @@
+package convert
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/richardartoul/molecule"
+	"github.com/richardartoul/molecule/src/protowire"
+)
+
+type Breakdown struct {
+	// Timestamps is a sequence of timestamps in nanoseconds
+	// when the samples occured, relative to the start of the profile.
+	// They are in increasing order, and are delta-encoded in the profile:
+	// each encoded timestamp is the difference from the previous one.
+	Timestamps []int64
+	Values     []int64
+	LabelSets  []int64
+}
+
+type PprofInfo struct {
+	// Value is the sum of all Values in Breakdown
+	Value int64
+	// CPU is the CPU time in nanoseconds that the samples account for
+	CPU int64
+	// Values are the sample values, one per sample type, for the profiles
+	// other than the CPU profile
+	Values []int64
+	// Breakdown shows the individual timestamped events
+	Breakdown Breakdown
+}
+
+type LabelSet struct {
+	ID int64
+	// Labels are the string labels, as alternating keys and values
+	Labels []string
+	// NumLabels are the numeric labels, like the IDs of the goroutine,
+	// thread and P a sample was taken on
+	NumLabels []NumLabel
+}
+
+// NumLabel is a numeric pprof label. Numbers are kept out of the string
+// table, and pprof can compare them as numbers, e.g. with -tagfocus ranges.
+// Unit is written as the label's num_unit if it's set; the goroutine, thread
+// and P IDs have the unit "id".
+type NumLabel struct {
+	Key  string
+	Num  int64
+	Unit string
+}
+
+// defaultCPUProfileRate is the rate, in Hz, at which the runtime takes CPU
+// samples unless runtime.SetCPUProfileRate says otherwise. The trace doesn't
+// record the rate.
+const defaultCPUProfileRate = 100
+
+// PprofOptions configures the pprof encoding.
+type PprofOptions struct {
+	// Compat restricts the profile to the fields of the upstream
+	// profile.proto, leaving out the Breakdown, LabelSet and tick unit
+	// extensions. Older pprof versions ignore unknown fields, but other
+	// consumers may reject them.
+	Compat bool
+	// CPUProfileRate is the rate, in Hz, at which the traced program took
+	// CPU samples, as set by runtime.SetCPUProfileRate. Each sample
+	// accounts for 1/CPUProfileRate seconds of CPU time. If zero, the
+	// runtime's default of 100 Hz is assumed.
+	CPUProfileRate int
+	// BreakdownInterval, if positive, rolls the breakdown entries up
+	// into intervals of this length: the entries of a sample in each
+	// interval, and with the same label set, are merged into one at the
+	// start of the interval with the sum of their values. That makes
+	// profiles of long traces much smaller, at the cost of timestamp
+	// resolution.
+	BreakdownInterval time.Duration
+	// DropFrames and KeepFrames, if not empty, are regular expressions
+	// for the profile's drop_frames and keep_frames fields, which pprof
+	// applies when it reads the profile. It removes the frames with
+	// function names fully matching DropFrames but not KeepFrames from
+	// the samples, along with the frames they called, but never the
+	// frames nearer the root than the first one which doesn't match.
+	DropFrames string
+	KeepFrames string
+	// Mapping, if not nil, describes the binary which produced the
+	// trace, for the profile's mapping.
+	Mapping *Mapping
+	// Logger, if not nil, gets debug details about the samples. Otherwise
+	// they go to slog.Default.
+	Logger *slog.Logger
+}
+
+func (o PprofOptions) logger() *slog.Logger {
+	if o.Logger != nil {
+		return o.Logger
+	}
+	return slog.Default()
+}
+
+// period returns the CPU time in nanoseconds represented by one sample.
+func (o PprofOptions) period() int64 {
+	rate := o.CPUProfileRate
+	if rate <= 0 {
+		rate = defaultCPUProfileRate
+	}
+	return int64(time.Second) / int64(rate)
+}
+
+// cpuSampleKey identifies a sample in the CPU profile. Samples taken while
+// the goroutine was in a user region are kept separate from the others, and
+// labeled with the name of the innermost region and its task. Samples are
+// also split by the function their goroutine was started with.
+type cpuSampleKey struct {
+	stkID  uint64
+	region string
+	task   string
+	// goroutineFunc is the function the goroutine was started with
+	goroutineFunc string
+	// labels are the goroutine's pprof labels, keyed by labelSetKey
+	labels string
+}
+
+// ToPprof converts CPU profile samples in a runtime execution trace into a
+// pprof-encoded profile.
+//
+// The profile also includes Felix's proposed "Breakdown" field for the
+// samples. The new format also introduces a LabelSet, which identifies a
+// repeated collection of labels. The Breakdown field shows the individual
+// timestamped events which make up the overall sample. Each event in a
+// breakdown also has an associated label set, with numeric goroutine_id,
+// thread_id and p_id labels for the goroutine, OS thread and P the sample
+// was taken on. With opts.Compat set, the extensions are left out and each
+// sample only has the total value for its stack.
+//
+// Samples taken inside a user region have "region" and "task" labels with
+// the names of the region and its task, so the profile can be broken down
+// by region with pprof's -tagfocus and similar. Every sample has a
+// "goroutine_func" label with the function its goroutine was started with
+// (see GoroutineFuncs), to group goroutines doing the same kind of work.
+// The goroutine's own pprof labels, set with pprof.Do, are copied to the
+// sample and its label sets when the trace has them (see Event.Labels).
+func ToPprof(parsed ParseResult, start, stop time.Time, opts PprofOptions, out io.Writer) error {
+	period := opts.period()
+	// the start of the profile, time_nanos, is the first event
+	var base int64
+	if len(parsed.Events) > 0 {
+		base = parsed.Events[0].Ts
+	}
+	info := make(map[cpuSampleKey]*PprofInfo)
+	// the names of tasks, and the regions each goroutine is in, to label
+	// the samples taken in regions
+	taskNames := make(map[uint64]string)
+	regions := make(map[uint64][]*Event)
+	goroutineFuncs := GoroutineFuncs(parsed)
+	// labelSetIDs associates the same set of labels
+	// (keyed by labelSetKey) with the ID of that label set
+	labelSetIDs := make(map[string]*LabelSet)
+	// pprofLabels has the pprof labels for each cpuSampleKey.labels
+	pprofLabels := make(map[string][]string)
+	for _, event := range parsed.Events {
+		switch event.Type {
+		case EvUserTaskCreate:
+			if len(event.SArgs) > 0 {
+				taskNames[event.Args[0]] = event.SArgs[0]
+			}
+		case EvUserRegion:
+			active := regions[event.G]
+			if event.Args[1] == 0 {
+				regions[event.G] = append(active, event)
+			} else if len(active) > 0 {
+				regions[event.G] = active[:len(active)-1]
+			}
+		case EvCPUSample:
+			k := cpuSampleKey{stkID: event.StkID, goroutineFunc: goroutineFuncs[event.G]}
+			if active := regions[event.G]; len(active) > 0 {
+				r := active[len(active)-1]
+				k.region = r.SArgs[0]
+				k.task = taskNames[r.Args[0]]
+			}
+			if len(event.Labels) > 0 {
+				k.labels = labelSetKey(event.Labels)
+				pprofLabels[k.labels] = event.Labels
+			}
+			pp, ok := info[k]
+			if !ok {
+				pp = new(PprofInfo)
+				info[k] = pp
+			}
+			value := int64(1)
+			pp.Value += value
+			pp.CPU += period
+			bd := &pp.Breakdown
+			bd.Timestamps = append(bd.Timestamps, event.Ts-base)
+			bd.Values = append(bd.Values, value)
+			nums := []NumLabel{{Key: "goroutine_id", Num: int64(event.G), Unit: "id"}}
+			// the OS thread, which only newer traces record
+			if event.M >= 0 {
+				nums = append(nums, NumLabel{Key: "thread_id", Num: event.M, Unit: "id"})
+			}
+			// the P the sample was taken on, if it had one
+			if event.P >= 0 && event.P < FakeP {
+				nums = append(nums, NumLabel{Key: "p_id", Num: int64(event.P), Unit: "id"})
+			}
+			s := labelSetKey(event.Labels) + "|" + numLabelSetKey(nums)
+			set, ok := labelSetIDs[s]
+			if !ok {
+				set = &LabelSet{
+					ID:        int64(len(labelSetIDs)) + 1,
+					Labels:    event.Labels,
+					NumLabels: nums,
+				}
+				labelSetIDs[s] = set
+			}
+			bd.LabelSets = append(bd.LabelSets, set.ID)
+		}
+	}
+	// Map iteration order is random, so everything which ends up in the
+	// profile is written in sorted order. That way the string table and
+	// the IDs are the same every time the trace is converted.
+	sets := make([]*LabelSet, 0, len(labelSetIDs))
+	for _, set := range labelSetIDs {
+		sets = append(sets, set)
+	}
+	sort.Slice(sets, func(i, j int) bool { return sets[i].ID < sets[j].ID })
+	sampleKeys := slices.SortedFunc(maps.Keys(info), func(a, b cpuSampleKey) int {
+		return cmp.Or(cmp.Compare(a.stkID, b.stkID), cmp.Compare(a.region, b.region), cmp.Compare(a.task, b.task),
+			cmp.Compare(a.goroutineFunc, b.goroutineFunc), cmp.Compare(a.labels, b.labels))
+	})
+	stackIDs := slices.Sorted(maps.Keys(parsed.Stacks))
+
+	if logger := opts.logger(); logger.Enabled(context.Background(), slog.LevelDebug) {
+		for _, set := range sets {
+			logger.Debug("label set", "id", set.ID, "labels", set.Labels, "num_labels", set.NumLabels)
+		}
+		for _, k := range sampleKeys {
+			pp := info[k]
+			var leaf string
+			if stk := parsed.Stacks[k.stkID]; len(stk) > 0 {
+				leaf = stk[0].Fn
+			}
+			logger.Debug("sample", "stack", k.stkID, "leaf", leaf, "value", pp.Value, "events", len(pp.Breakdown.Timestamps))
+		}
+	}
+
+	// BUILDING PPROF-ENCODED PROFILE
+
+	// The profile is streamed to out as it's encoded. The string table
+	// goes last, once every string has been given its ID; protobuf
+	// doesn't care about the order of fields. bufio.Writer keeps the
+	// first write error, for Flush to return at the end.
+	bw := bufio.NewWriter(out)
+	var strtab StrTab
+
+	ps := molecule.NewProtoStream(bw)
+
+	// Functions and locations get IDs in the order they first appear.
+	// A PC isn't enough to identify a location: inlined calls share the
+	// PC of the function they were inlined into, and some traces don't
+	// have PCs at all.
+	var functions pprofTable[pprofFunction]
+	var locations pprofTable[pprofLocation]
+	stackLocations := make(map[uint64][]uint64)
+	for _, id := range stackIDs {
+		stackLocations[id] = frameLocations(parsed.Stacks[id], &functions, &locations)
+	}
+
+	// Value types, 1
+	// The same as a regular CPU profile, so pprof's views work as usual.
+	// The breakdown values are in samples.
+	ps.Embedded(1, func(ps *molecule.ProtoStream) error {
+		ps.Int64(1, strtab.Get("samples")) // type
+		ps.Int64(2, strtab.Get("count"))   // unit
+		return nil
+	})
+	ps.Embedded(1, func(ps *molecule.ProtoStream) error {
+		ps.Int64(1, strtab.Get("cpu"))         // type
+		ps.Int64(2, strtab.Get("nanoseconds")) // unit
+		return nil
+	})
+
+	// LabelSet, 16
+	if !opts.Compat {
+		for _, set := range sets {
+			ps.Embedded(16, func(ps *molecule.ProtoStream) error {
+				ps.Uint64(1, uint64(set.ID)) // id
+				for i := 0; i < len(set.Labels); i += 2 {
+					// label
+					ps.Embedded(2, func(ps *molecule.ProtoStream) error {
+						ps.Int64(1, strtab.Get(set.Labels[i]))   // key
+						ps.Int64(2, strtab.Get(set.Labels[i+1])) // value
+						return nil
+					})
+				}
+				for _, l := range set.NumLabels {
+					// label
+					ps.Embedded(2, func(ps *molecule.ProtoStream) error {
+						ps.Int64(1, strtab.Get(l.Key)) // key
+						ps.Int64(3, l.Num)             // num
+						if l.Unit != "" {
+							ps.Int64(4, strtab.Get(l.Unit)) // num unit
+						}
+						return nil
+					})
+				}
+				return nil
+			})
+		}
+	}
+
+	// Samples, 2
+	for _, k := range sampleKeys {
+		pp := info[k]
+		ps.Embedded(2, func(ps *molecule.ProtoStream) error {
+			for _, loc := range stackLocations[k.stkID] {
+				ps.Uint64(1, loc) // location ID
+			}
+			ps.Int64Packed(2, []int64{pp.Value, pp.CPU}) // values
+			label := func(key, value string) {
+				if value == "" {
+					return
+				}
+				ps.Embedded(3, func(ps *molecule.ProtoStream) error {
+					ps.Int64(1, strtab.Get(key))   // key
+					ps.Int64(2, strtab.Get(value)) // str
+					return nil
+				})
+			}
+			label("region", k.region)
+			label("task", k.task)
+			label("goroutine_func", k.goroutineFunc)
+			kv := pprofLabels[k.labels]
+			for i := 0; i+1 < len(kv); i += 2 {
+				label(kv[i], kv[i+1])
+			}
+			if opts.Compat {
+				return nil
+			}
+			pp.Breakdown.write(ps, int64(opts.BreakdownInterval))
+			return nil
+		})
+	}
+
+	// Mapping, 3
+	opts.writeMapping(ps, &strtab)
+
+	// Function, 5
+	for i, fn := range functions.list {
+		ps.Embedded(5, func(ps *molecule.ProtoStream) error {
+			ps.Uint64(1, uint64(i+1))        // unique ID
+			ps.Int64(2, strtab.Get(fn.name)) // name
+			ps.Int64(4, strtab.Get(fn.file)) // filename
+			return nil
+		})
+	}
+
+	// Location, 4
+	for i, loc := range locations.list {
+		loc.write(ps, uint64(i+1))
+	}
+
+	// Time nanos, 9
+	ps.Int64(9, start.UnixNano())
+
+	// Duration nanos, 10
+	ps.Int64(10, stop.Sub(start).Nanoseconds())
+
+	// Period type, 11
+	ps.Embedded(11, func(ps *molecule.ProtoStream) error {
+		ps.Int64(1, strtab.Get("cpu"))         // type
+		ps.Int64(2, strtab.Get("nanoseconds")) // unit
+		return nil
+	})
+
+	// Period, 12
+	ps.Int64(12, period)
+
+	// Drop frames, 7, and keep frames, 8
+	opts.writeFrameFilters(ps, &strtab)
+
+	// Default sample type, 14
+	// CPU time rather than the sample count, like go tool pprof shows
+	// for a regular CPU profile.
+	ps.Int64(14, strtab.Get("cpu"))
+
+	// Tick unit, 15
+	if !opts.Compat {
+		ps.Int64(15, strtab.Get("nanoseconds"))
+	}
+
+	// String table, 6
+	// Have to write the string table manually because the first string
+	// must be length 0, and molecule declines to write length-0 stuff
+	for i, s := range strtab.Table() {
+		if strtab.ids[s] != int64(i) {
+			return fmt.Errorf("string table index mismatch for %q: %d != %d", s, strtab.ids[s], i)
+		}
+		writeString(bw, 6, s)
+	}
+	return bw.Flush()
+}
+
+// writeFrameFilters writes the DropFrames and KeepFrames fields, if set.
+func (o PprofOptions) writeFrameFilters(ps *molecule.ProtoStream, strtab *StrTab) {
+	if o.DropFrames != "" {
+		ps.Int64(7, strtab.Get(o.DropFrames))
+	}
+	if o.KeepFrames != "" {
+		ps.Int64(8, strtab.Get(o.KeepFrames))
+	}
+}
+
+// writeString writes a string field to w, even if the string is empty,
+// which molecule skips.
+func writeString(w *bufio.Writer, field int, s string) {
+	var b [2 * binary.MaxVarintLen64]byte
+	tag := protowire.AppendVarint(b[:0], uint64(field)<<3|uint64(protowire.BytesType))
+	w.Write(protowire.AppendVarint(tag, uint64(len(s))))
+	w.WriteString(s)
+}
+
+// CPUEvents reports whether the event is one of those which the conversions
+// of CPU samples (ToPprof, ToFolded, ToFlamegraph and ToOTLP) use: the
+// samples themselves, and the goroutine creations and user annotations which
+// label them. Pass it to ParseFunc to leave everything else out when reading
+// a large trace for those conversions.
+func CPUEvents(ev *Event) bool {
+	switch ev.Type {
+	case EvCPUSample, EvGoCreate, EvUserTaskCreate, EvUserTaskEnd, EvUserRegion:
+		return true
+	}
+	return false
+}
+
+// pprofFunction identifies a pprof Function. Traces don't record the start
+// line of functions, so the name and file are all there is to go on.
+type pprofFunction struct {
+	name string
+	file string
+}
+
+// pprofLocation identifies a pprof Location. Its line is that of the
+// innermost frame at the PC. If calls were inlined there, inlined has the
+// lines of the frames they were inlined into, encoded so that
+// pprofLocation is comparable.
+type pprofLocation struct {
+	pc       uint64
+	function uint64
+	line     int
+	inlined  string
+}
+
+// frameLocations returns the IDs of the locations for the frames, adding
+// them and their functions to the tables. Frames inlined into the next
+// frame share its location.
+func frameLocations(frames []*Frame, functions *pprofTable[pprofFunction], locations *pprofTable[pprofLocation]) []uint64 {
+	var ids []uint64
+	var loc *pprofLocation
+	var inlined []byte
+	for _, frame := range frames {
+		fn := functions.id(pprofFunction{name: frame.Fn, file: frame.File})
+		if loc == nil {
+			loc = &pprofLocation{pc: frame.PC, function: fn, line: frame.Line}
+		} else {
+			inlined = binary.AppendUvarint(inlined, fn)
+			inlined = binary.AppendUvarint(inlined, uint64(frame.Line))
+		}
+		if frame.Inlined {
+			continue
+		}
+		loc.inlined = string(inlined)
+		ids = append(ids, locations.id(*loc))
+		loc, inlined = nil, inlined[:0]
+	}
+	if loc != nil {
+		loc.inlined = string(inlined)
+		ids = append(ids, locations.id(*loc))
+	}
+	return ids
+}
+
+// write writes the location, with the given ID, and its lines.
+func (loc pprofLocation) write(ps *molecule.ProtoStream, id uint64) {
+	line := func(ps *molecule.ProtoStream, function uint64, line int64) {
+		ps.Embedded(4, func(ps *molecule.ProtoStream) error {
+			ps.Uint64(1, function) // function ID
+			ps.Int64(2, line)      // line
+			return nil
+		})
+	}
+	ps.Embedded(4, func(ps *molecule.ProtoStream) error {
+		ps.Uint64(1, id)     // ID
+		ps.Uint64(2, 1)      // mapping ID
+		ps.Uint64(3, loc.pc) // address
+		line(ps, loc.function, int64(loc.line))
+		for b := []byte(loc.inlined); len(b) > 0; {
+			fn, n := binary.Uvarint(b)
+			l, m := binary.Uvarint(b[n:])
+			b = b[n+m:]
+			line(ps, fn, int64(l))
+		}
+		return nil
+	})
+}
+
+// pprofTable assigns IDs, starting at 1, to distinct values.
+type pprofTable[T comparable] struct {
+	ids  map[T]uint64
+	list []T
+}
+
+func (t *pprofTable[T]) id(v T) uint64 {
+	if id, ok := t.ids[v]; ok {
+		return id
+	}
+	if t.ids == nil {
+		t.ids = make(map[T]uint64)
+	}
+	t.list = append(t.list, v)
+	id := uint64(len(t.list))
+	t.ids[v] = id
+	return id
+}
+
+// labelSetKey returns a key which uniquely identifies the given sequence of
+// label strings. Each string is prefixed with its length so that, for
+// example, ["ab", "c"] and ["a", "bc"] get different keys.
+func labelSetKey(labels []string) string {
+	var sb strings.Builder
+	for _, l := range labels {
+		sb.WriteString(strconv.Itoa(len(l)))
+		sb.WriteByte(':')
+		sb.WriteString(l)
+	}
+	return sb.String()
+}
+
+// numLabelSetKey returns a string identifying the numeric labels, like
+// labelSetKey.
+func numLabelSetKey(labels []NumLabel) string {
+	var sb strings.Builder
+	for _, l := range labels {
+		sb.WriteString(labelSetKey([]string{l.Key, strconv.FormatInt(l.Num, 10), l.Unit}))
+	}
+	return sb.String()
+}
+
+// deltaEncode returns the differences between consecutive values of the
+// sorted slice ts, with the first value left as is. Small deltas take up
+// fewer bytes as varints than absolute timestamps. It panics if ts isn't
+// sorted, since a negative delta would make the profile's timestamps wrong
+// without anything noticing.
+func deltaEncode(ts []int64) []int64 {
+	deltas := make([]int64, len(ts))
+	var prev int64
+	for i, t := range ts {
+		if i > 0 && t < prev {
+			panic(fmt.Sprintf("breakdown timestamps out of order: %d after %d", t, prev))
+		}
+		deltas[i] = t - prev
+		prev = t
+	}
+	return deltas
+}
+
+// write writes the breakdown as a sample's Breakdown field, rolled up into
+// intervals of the given length if it's positive. Samples from different
+// batches of a trace can come out of order, and events are added to a
+// profileBuilder in the order they end, so the entries are put in timestamp
+// order first if they aren't in it already. Consumers can rely on every
+// encoded timestamp delta being non-negative.
+func (bd Breakdown) write(ps *molecule.ProtoStream, interval int64) {
+	if !slices.IsSorted(bd.Timestamps) {
+		bd = bd.sorted()
+	}
+	bd = bd.rollup(interval)
+	ps.Embedded(4, func(ps *molecule.ProtoStream) error {
+		ps.Int64Packed(1, deltaEncode(bd.Timestamps))
+		ps.Int64Packed(2, bd.Values)
+		if len(bd.LabelSets) > 0 {
+			ps.Int64Packed(3, bd.LabelSets)
+		}
+		return nil
+	})
+}
+
+// StrTab deduplicates strings, gives them unique IDs. The empty string
+// always has ID 0, as pprof requires, so the table starts with it. The zero
+// value is ready to use.
+type StrTab struct {
+	ids   map[string]int64
+	table []string
+}
+
+func (t *StrTab) Get(s string) int64 {
+	if t.ids == nil {
+		t.ids = map[string]int64{"": 0}
+		t.table = []string{""}
+	}
+	id, ok := t.ids[s]
+	if !ok {
+		id = int64(len(t.table))
+		t.ids[s] = id
+		t.table = append(t.table, s)
+	}
+	return id
+}
+
+// Table returns the strings in order of their IDs, starting with the empty
+// string.
+func (t *StrTab) Table() []string {
+	t.Get("")
+	return t.table
+}
@@ -0,0 +1,99 @@
+package convert
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/google/pprof/profile"
+)
+
+func TestStrTab(t *testing.T) {
+	var tab StrTab
+	if id := tab.Get("main"); id != 1 {
+		t.Errorf(`first string has ID %d, want 1`, id)
+	}
+	if id := tab.Get(""); id != 0 {
+		t.Errorf(`"" has ID %d, want 0`, id)
+	}
+	if id := tab.Get("main"); id != 1 {
+		t.Errorf(`repeated string has ID %d, want 1`, id)
+	}
+	table := tab.Table()
+	if len(table) != 2 || table[0] != "" || table[1] != "main" {
+		t.Errorf(`table is %q, want ["" "main"]`, table)
+	}
+	var empty StrTab
+	if table := empty.Table(); len(table) != 1 || table[0] != "" {
+		t.Errorf(`empty table is %q, want [""]`, table)
+	}
+}
+
+// TestToPprofStrings decodes the profile ToPprof writes with pprof's own
+// parser, to check that the string table lines up with the IDs the profile
+// refers to, including for user strings which are empty.
+func TestToPprofStrings(t *testing.T) {
+	parsed := ParseResult{
+		Events: []*Event{
+			{Type: EvUserTaskCreate, Ts: 100, G: 1, Args: [3]uint64{7}, SArgs: []string{"job"}},
+			{Type: EvUserRegion, Ts: 200, G: 1, Args: [3]uint64{7, 0}, SArgs: []string{"render"}},
+			{Type: EvCPUSample, Ts: 300, G: 1, P: 0, M: 5, StkID: 1, Labels: []string{"user", "", "tenant", "acme"}},
+			{Type: EvUserRegion, Ts: 400, G: 1, Args: [3]uint64{7, 1}, SArgs: []string{"render"}},
+			{Type: EvCPUSample, Ts: 500, G: 1, P: 0, M: 5, StkID: 1},
+		},
+		Stacks: map[uint64][]*Frame{
+			1: {
+				{PC: 0x1010, Fn: "main.work", File: "", Line: 12},
+				{PC: 0x1020, Fn: "main.main", File: "/src/main.go", Line: 3},
+			},
+		},
+	}
+	for _, compat := range []bool{false, true} {
+		var buf bytes.Buffer
+		opts := PprofOptions{Compat: compat, DropFrames: "runtime\\..*"}
+		if err := ToPprof(parsed, time.Time{}, time.Time{}, opts, &buf); err != nil {
+			t.Fatal(err)
+		}
+		p, err := profile.ParseData(buf.Bytes())
+		if err != nil {
+			t.Fatalf("compat %v: %v", compat, err)
+		}
+		if p.DropFrames != opts.DropFrames {
+			t.Errorf("compat %v: drop_frames is %q, want %q", compat, p.DropFrames, opts.DropFrames)
+		}
+		if len(p.SampleType) != 2 || p.SampleType[0].Type != "samples" || p.SampleType[1].Unit != "nanoseconds" {
+			t.Errorf("compat %v: sample types are %v", compat, p.SampleType)
+		}
+		if len(p.Sample) != 2 {
+			t.Fatalf("compat %v: got %d samples, want 2", compat, len(p.Sample))
+		}
+		var inRegion *profile.Sample
+		for _, s := range p.Sample {
+			if len(s.Location) != 2 {
+				t.Fatalf("compat %v: sample has %d locations, want 2", compat, len(s.Location))
+			}
+			leaf := s.Location[0].Line[0]
+			if leaf.Function.Name != "main.work" || leaf.Function.Filename != "" || leaf.Line != 12 {
+				t.Errorf("compat %v: leaf is %s %q:%d, want main.work \"\":12",
+					compat, leaf.Function.Name, leaf.Function.Filename, leaf.Line)
+			}
+			if root := s.Location[1].Line[0]; root.Function.Filename != "/src/main.go" {
+				t.Errorf("compat %v: root's file is %q, want /src/main.go", compat, root.Function.Filename)
+			}
+			if s.Label["region"] != nil {
+				inRegion = s
+			}
+		}
+		if inRegion == nil {
+			t.Fatalf("compat %v: no sample has a region label", compat)
+		}
+		for key, want := range map[string]string{"region": "render", "task": "job", "tenant": "acme"} {
+			if got := inRegion.Label[key]; len(got) != 1 || got[0] != want {
+				t.Errorf("compat %v: label %s is %q, want %q", compat, key, got, want)
+			}
+		}
+		if got, ok := inRegion.Label["user"]; ok {
+			t.Errorf("compat %v: empty label user is %q, want it left out", compat, got)
+		}
+	}
+}
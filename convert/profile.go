@@ -0,0 +1,249 @@
+package convert
+
+import (
+	"bufio"
+	"cmp"
+	"io"
+	"math"
+	"slices"
+	"time"
+
+	"github.com/richardartoul/molecule"
+)
+
+// valueType is a pprof ValueType, e.g. {"cpu", "nanoseconds"}.
+type valueType struct {
+	typ  string
+	unit string
+}
+
+// sampleKey identifies an aggregated sample in a profileBuilder: a trace
+// stack and, optionally, a synthetic leaf frame added on top of it, such as
+// the reason a goroutine was waiting, and a goroutine_id label.
+type sampleKey struct {
+	stkID uint64
+	leaf  string
+	// g, if non-zero, is added to the sample as the goroutine_id label, as
+	// ToPprof labels its breakdown
+	g uint64
+}
+
+// profileBuilder aggregates values by stack for the profiles derived from
+// goroutine states and other trace events, and writes them out as pprof
+// profiles. Like ToPprof, each sample has a Breakdown of the individual
+// events which make it up, unless opts.Compat is set. The breakdown values
+// are those of the last sample type.
+type profileBuilder struct {
+	parsed      ParseResult
+	opts        PprofOptions
+	sampleTypes []valueType
+	// period is one of the sample types, and the period of the profile
+	periodType valueType
+	period     int64
+	samples    map[sampleKey]*PprofInfo
+	base       int64
+	// comments are free-form notes about the profile, which pprof shows
+	// with -raw
+	comments []string
+}
+
+func newProfileBuilder(parsed ParseResult, opts PprofOptions, periodType valueType, sampleTypes ...valueType) *profileBuilder {
+	b := &profileBuilder{
+		parsed:      parsed,
+		opts:        opts,
+		sampleTypes: sampleTypes,
+		periodType:  periodType,
+		period:      1,
+		samples:     make(map[sampleKey]*PprofInfo),
+	}
+	if len(parsed.Events) > 0 {
+		b.base = parsed.Events[0].Ts
+	}
+	return b
+}
+
+// add adds values, one per sample type, to the sample k, for an event at
+// the given timestamp. Samples at stacks rejected by a Filter are dropped.
+func (b *profileBuilder) add(k sampleKey, ts int64, values ...int64) {
+	if b.parsed.ignoredStacks[k.stkID] {
+		return
+	}
+	pp, ok := b.samples[k]
+	if !ok {
+		pp = &PprofInfo{Values: make([]int64, len(b.sampleTypes))}
+		b.samples[k] = pp
+	}
+	for i, v := range values {
+		pp.Values[i] += v
+	}
+	bd := &pp.Breakdown
+	bd.Timestamps = append(bd.Timestamps, ts-b.base)
+	bd.Values = append(bd.Values, values[len(values)-1])
+}
+
+// sorted returns the breakdown with its entries in timestamp order, which the
+// delta encoding relies on. Entries with the same timestamp keep their
+// order.
+func (bd Breakdown) sorted() Breakdown {
+	idx := make([]int, len(bd.Timestamps))
+	for i := range idx {
+		idx[i] = i
+	}
+	slices.SortStableFunc(idx, func(i, j int) int { return cmp.Compare(bd.Timestamps[i], bd.Timestamps[j]) })
+	var out Breakdown
+	for _, i := range idx {
+		out.Timestamps = append(out.Timestamps, bd.Timestamps[i])
+		out.Values = append(out.Values, bd.Values[i])
+		if len(bd.LabelSets) > 0 {
+			out.LabelSets = append(out.LabelSets, bd.LabelSets[i])
+		}
+	}
+	return out
+}
+
+// rollup returns the breakdown, which must be in timestamp order, with the
+// entries in each interval of the given length, in nanoseconds, merged into
+// one at the start of the interval with the sum of their values. Entries
+// with different label sets are kept apart, in the order they first occur
+// in the interval. If interval isn't positive, the breakdown is returned
+// as is.
+func (bd Breakdown) rollup(interval int64) Breakdown {
+	if interval <= 0 || len(bd.Timestamps) == 0 {
+		return bd
+	}
+	var out Breakdown
+	// entries has the index in out of the current interval's entry for
+	// each label set
+	entries := make(map[int64]int)
+	start := int64(math.MinInt64)
+	for i, ts := range bd.Timestamps {
+		bucket := ts - ts%interval
+		if ts < 0 && ts%interval != 0 {
+			bucket -= interval
+		}
+		if bucket != start {
+			start = bucket
+			clear(entries)
+		}
+		var set int64
+		if len(bd.LabelSets) > 0 {
+			set = bd.LabelSets[i]
+		}
+		if j, ok := entries[set]; ok {
+			out.Values[j] += bd.Values[i]
+			continue
+		}
+		entries[set] = len(out.Timestamps)
+		out.Timestamps = append(out.Timestamps, bucket)
+		out.Values = append(out.Values, bd.Values[i])
+		if len(bd.LabelSets) > 0 {
+			out.LabelSets = append(out.LabelSets, set)
+		}
+	}
+	return out
+}
+
+// write writes the profile to out, uncompressed.
+func (b *profileBuilder) write(start, stop time.Time, out io.Writer) error {
+	keys := make([]sampleKey, 0, len(b.samples))
+	for k := range b.samples {
+		keys = append(keys, k)
+	}
+	slices.SortFunc(keys, func(a, b sampleKey) int {
+		return cmp.Or(cmp.Compare(a.stkID, b.stkID), cmp.Compare(a.leaf, b.leaf), cmp.Compare(a.g, b.g))
+	})
+
+	var functions pprofTable[pprofFunction]
+	var locations pprofTable[pprofLocation]
+	sampleLocations := make([][]uint64, len(keys))
+	for i, k := range keys {
+		if k.leaf != "" {
+			fn := functions.id(pprofFunction{name: k.leaf})
+			sampleLocations[i] = append(sampleLocations[i], locations.id(pprofLocation{function: fn}))
+		}
+		sampleLocations[i] = append(sampleLocations[i], frameLocations(b.parsed.Stacks[k.stkID], &functions, &locations)...)
+	}
+
+	// Streamed to out, like in ToPprof
+	bw := bufio.NewWriter(out)
+	var strtab StrTab
+	ps := molecule.NewProtoStream(bw)
+	valueType := func(field int, vt valueType) {
+		ps.Embedded(field, func(ps *molecule.ProtoStream) error {
+			ps.Int64(1, strtab.Get(vt.typ))  // type
+			ps.Int64(2, strtab.Get(vt.unit)) // unit
+			return nil
+		})
+	}
+
+	// Value types, 1
+	for _, vt := range b.sampleTypes {
+		valueType(1, vt)
+	}
+	// Samples, 2
+	for i, k := range keys {
+		ps.Embedded(2, func(ps *molecule.ProtoStream) error {
+			for _, loc := range sampleLocations[i] {
+				ps.Uint64(1, loc) // location ID
+			}
+			pp := b.samples[k]
+			ps.Int64Packed(2, pp.Values) // values
+			if k.g != 0 {
+				// label
+				ps.Embedded(3, func(ps *molecule.ProtoStream) error {
+					ps.Int64(1, strtab.Get("goroutine_id")) // key
+					ps.Int64(3, int64(k.g))                 // num
+					ps.Int64(4, strtab.Get("id"))           // num unit
+					return nil
+				})
+			}
+			if b.opts.Compat {
+				return nil
+			}
+			pp.Breakdown.write(ps, int64(b.opts.BreakdownInterval))
+			return nil
+		})
+	}
+	// Mapping, 3
+	b.opts.writeMapping(ps, &strtab)
+	// Location, 4
+	for i, loc := range locations.list {
+		loc.write(ps, uint64(i+1))
+	}
+	// Function, 5
+	for i, fn := range functions.list {
+		ps.Embedded(5, func(ps *molecule.ProtoStream) error {
+			ps.Uint64(1, uint64(i+1))        // unique ID
+			ps.Int64(2, strtab.Get(fn.name)) // name
+			ps.Int64(4, strtab.Get(fn.file)) // filename
+			return nil
+		})
+	}
+	// Time nanos, 9
+	ps.Int64(9, start.UnixNano())
+	// Duration nanos, 10
+	ps.Int64(10, stop.Sub(start).Nanoseconds())
+	// Period type, 11
+	valueType(11, b.periodType)
+	// Period, 12
+	ps.Int64(12, b.period)
+	// Drop frames, 7, and keep frames, 8
+	b.opts.writeFrameFilters(ps, &strtab)
+	// Default sample type, 14
+	// The last sample type, which the breakdown is in, is the time or
+	// amount the profile is about, rather than a count of events.
+	if len(b.sampleTypes) > 1 {
+		ps.Int64(14, strtab.Get(b.sampleTypes[len(b.sampleTypes)-1].typ))
+	}
+	// Comments, 13
+	for _, c := range b.comments {
+		ps.Int64(13, strtab.Get(c))
+	}
+
+	// String table, 6
+	// Written by hand, like in ToPprof, since the first string is empty
+	for _, s := range strtab.Table() {
+		writeString(bw, 6, s)
+	}
+	return bw.Flush()
+}
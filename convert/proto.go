@@ -0,0 +1,109 @@
+package convert
+
+import (
+	"bufio"
+	"io"
+	"maps"
+	"slices"
+
+	"github.com/richardartoul/molecule"
+)
+
+// ToProto writes the parsed trace to out as a protobuf-encoded Trace
+// message, as trace.proto in this package defines it: the events, like
+// ToJSON's, the stacks they refer to, and the goroutine intervals from
+// GoroutineIntervals. Services can read it with bindings generated from
+// trace.proto.
+func ToProto(parsed ParseResult, out io.Writer) error {
+	bw := bufio.NewWriter(out)
+	ps := molecule.NewProtoStream(bw)
+	intervals := GoroutineIntervals(parsed)
+	// Only the stacks something refers to are written
+	used := make(map[uint64]bool)
+	for _, ev := range parsed.Events {
+		used[ev.StkID] = true
+	}
+	for _, iv := range intervals {
+		used[iv.StkID] = true
+	}
+	stackID := func(id uint64) uint64 {
+		if len(parsed.Stacks[id]) == 0 {
+			return 0
+		}
+		return id
+	}
+	proc := func(p int) int32 {
+		if p < 0 || p >= FakeP {
+			return -1
+		}
+		return int32(p)
+	}
+
+	// Stacks, 1
+	for _, id := range slices.Sorted(maps.Keys(parsed.Stacks)) {
+		stk := parsed.Stacks[id]
+		if !used[id] || len(stk) == 0 {
+			continue
+		}
+		ps.Embedded(1, func(ps *molecule.ProtoStream) error {
+			ps.Uint64(1, id) // id
+			for _, f := range stk {
+				// frames
+				ps.Embedded(2, func(ps *molecule.ProtoStream) error {
+					ps.String(1, f.Fn)         // func
+					ps.String(2, f.File)       // file
+					ps.Int64(3, int64(f.Line)) // line
+					return nil
+				})
+			}
+			return nil
+		})
+	}
+	// Events, 2
+	for _, ev := range parsed.Events {
+		e := jsonEvent(ev)
+		thread := max(ev.M, -1)
+		ps.Embedded(2, func(ps *molecule.ProtoStream) error {
+			ps.String(1, e.Type)            // type
+			ps.Uint64(2, e.Goroutine)       // goroutine
+			ps.Int64(3, e.Timestamp)        // timestamp
+			ps.Uint64(4, stackID(ev.StkID)) // stack ID
+			ps.Int32(5, proc(ev.P))         // p
+			ps.Int64(6, thread)             // thread
+			ps.String(7, e.Category)        // category
+			ps.String(8, e.Message)         // message
+			for _, k := range slices.Sorted(maps.Keys(e.Args)) {
+				// args
+				ps.Embedded(9, func(ps *molecule.ProtoStream) error {
+					ps.String(1, k) // key
+					switch v := e.Args[k].(type) {
+					case uint64:
+						ps.Uint64(2, v) // num
+					case string:
+						ps.String(3, v) // str
+					}
+					return nil
+				})
+			}
+			return nil
+		})
+	}
+	// Intervals, 3
+	for _, iv := range intervals {
+		ps.Embedded(3, func(ps *molecule.ProtoStream) error {
+			ps.Uint64(1, iv.G)              // goroutine
+			ps.String(2, iv.State.String()) // state
+			ps.String(3, iv.WaitReason())   // reason
+			ps.Int64(4, iv.Start)           // start
+			ps.Int64(5, iv.End)             // end
+			ps.Uint64(6, stackID(iv.StkID)) // stack ID
+			p := int32(-1)
+			if iv.State == StateRunning {
+				p = proc(iv.P)
+			}
+			ps.Int32(7, p) // p
+			return nil
+		})
+	}
+	return bw.Flush()
+}
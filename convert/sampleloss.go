@@ -0,0 +1,133 @@
+package convert
+
+import (
+	"cmp"
+	"slices"
+)
+
+// SampleLoss is how many CPU samples a trace is likely missing. The runtime
+// drops samples when the buffer it passes them to the tracer in overflows,
+// and leaves no record of those, so the loss is estimated from how long
+// goroutines ran against how many samples they got. Times are in
+// nanoseconds.
+type SampleLoss struct {
+	// Period is the time between samples the estimate assumes, from the
+	// CPU profiling rate
+	Period int64 `json:"period_ns"`
+	// Running is the total time goroutines spent running, and Expected
+	// the number of samples that much running should have taken
+	Running  int64   `json:"running_ns"`
+	Expected float64 `json:"expected"`
+	// Samples is the number of samples taken on goroutines
+	Samples int `json:"samples"`
+	// Lost is the number of samples the runtime marked as standing for
+	// lost samples, with stacks like runtime._LostExternalCode. Each
+	// stands for one or more samples the profiler couldn't record.
+	Lost int `json:"lost"`
+	// EstimatedLost is how many fewer samples there were than Expected,
+	// and EstimatedLostFraction that as a fraction of Expected
+	EstimatedLost         float64 `json:"estimated_lost"`
+	EstimatedLostFraction float64 `json:"estimated_lost_fraction"`
+	// Gaps are the stretches in which a goroutine ran for a long time
+	// without being sampled, the longest first
+	Gaps []SampleGap `json:"gaps"`
+}
+
+// SampleGap is a stretch of a trace during which a goroutine ran for longer
+// than several sampling periods, but wasn't sampled.
+type SampleGap struct {
+	G uint64 `json:"g"`
+	// Func is the function the goroutine was started with, if known
+	Func string `json:"func,omitempty"`
+	// Start and End are the samples, or the start and end of the
+	// goroutine's running, on either side of the gap
+	Start int64 `json:"start_ns"`
+	End   int64 `json:"end_ns"`
+	// Running is how long the goroutine ran during the gap, and Expected
+	// how many samples that should have taken
+	Running  int64   `json:"running_ns"`
+	Expected float64 `json:"expected"`
+}
+
+// lostSampleFuncs are the functions the runtime puts in the stacks of the
+// samples which stand for samples it lost.
+var lostSampleFuncs = []string{
+	"runtime._LostExternalCode",
+	"runtime._LostSIGPROFDuringAtomic64",
+	"runtime._LostContendedRuntimeLock",
+}
+
+// NewSampleLoss estimates how many CPU samples the parsed trace is missing,
+// as SampleLoss describes, assuming the given sampling period in
+// nanoseconds. Gaps are the stretches of at least minGap nanoseconds of a
+// goroutine's running without a sample. A trace without any samples, from
+// a program which wasn't running the CPU profiler, has no loss.
+func NewSampleLoss(parsed ParseResult, period, minGap int64) SampleLoss {
+	loss := SampleLoss{Period: period, Gaps: []SampleGap{}}
+	samples := make(map[uint64][]int64)
+	for _, ev := range parsed.Events {
+		if ev.Type != EvCPUSample {
+			continue
+		}
+		if slices.ContainsFunc(parsed.Stacks[ev.StkID], func(f *Frame) bool { return slices.Contains(lostSampleFuncs, f.Fn) }) {
+			loss.Lost++
+			continue
+		}
+		if ev.G != 0 {
+			samples[ev.G] = append(samples[ev.G], ev.Ts)
+			loss.Samples++
+		}
+	}
+	running := make(map[uint64][]Interval)
+	for _, in := range GoroutineIntervals(parsed) {
+		if in.State == StateRunning {
+			running[in.G] = append(running[in.G], in)
+			loss.Running += in.Duration()
+		}
+	}
+	if (loss.Samples == 0 && loss.Lost == 0) || period <= 0 {
+		return loss
+	}
+	loss.Expected = float64(loss.Running) / float64(period)
+	loss.EstimatedLost = max(loss.Expected-float64(loss.Samples), 0)
+	if loss.Expected > 0 {
+		loss.EstimatedLostFraction = loss.EstimatedLost / loss.Expected
+	}
+
+	funcs := GoroutineFuncs(parsed)
+	for g, ins := range running {
+		slices.SortFunc(ins, func(a, b Interval) int { return cmp.Compare(a.Start, b.Start) })
+		ts := samples[g]
+		slices.Sort(ts)
+		// acc is the running time since the sample, or the start of
+		// running, at from
+		var acc int64
+		from := ins[0].Start
+		gap := func(to int64) {
+			if acc >= minGap {
+				loss.Gaps = append(loss.Gaps, SampleGap{
+					G: g, Func: funcs[g], Start: from, End: to,
+					Running: acc, Expected: float64(acc) / float64(period),
+				})
+			}
+		}
+		j := 0
+		for _, in := range ins {
+			t := in.Start
+			for ; j < len(ts) && ts[j] <= in.End; j++ {
+				// samples just outside of running intervals, from clock
+				// skew, count as taken at their edge
+				at := max(ts[j], t)
+				acc += at - t
+				gap(at)
+				acc, from, t = 0, at, at
+			}
+			acc += in.End - t
+		}
+		gap(ins[len(ins)-1].End)
+	}
+	slices.SortFunc(loss.Gaps, func(a, b SampleGap) int {
+		return cmp.Or(cmp.Compare(b.Running, a.Running), cmp.Compare(a.Start, b.Start), cmp.Compare(a.G, b.G))
+	})
+	return loss
+}
@@ -0,0 +1,173 @@
+package convert
+
+import (
+	"fmt"
+	"io"
+	"math/bits"
+	"slices"
+	"time"
+)
+
+// ToSchedLatency writes a pprof profile of scheduling latency, the time
+// goroutines spent runnable before getting to run, to out, uncompressed.
+// The samples are the stacks the goroutines ran from once scheduled: where
+// they blocked or were preempted, or where they were created for new
+// goroutines. The sample types are the number of times goroutines were
+// scheduled and the total delay in nanoseconds. A histogram of the
+// latencies is included in the profile's comments, which pprof shows with
+// -raw.
+func ToSchedLatency(parsed ParseResult, start, stop time.Time, opts PprofOptions, out io.Writer) error {
+	delay := valueType{"delay", "nanoseconds"}
+	b := newProfileBuilder(parsed, opts, delay, valueType{"wakeups", "count"}, delay)
+	resume := make(resumeStacks)
+	var latencies []time.Duration
+	for _, in := range GoroutineIntervals(parsed) {
+		stk := resume.stack(in)
+		if in.State != StateRunnable {
+			continue
+		}
+		b.add(sampleKey{stkID: stk}, in.Start, 1, in.Duration())
+		latencies = append(latencies, time.Duration(in.Duration()))
+	}
+	b.comments = NewLatencyHistogram(latencies).Lines()
+	return b.write(start, stop, out)
+}
+
+// LatencyHistogram summarizes a set of latencies.
+type LatencyHistogram struct {
+	Count int
+	Min   time.Duration
+	Max   time.Duration
+	P50   time.Duration
+	P90   time.Duration
+	P99   time.Duration
+	// Buckets counts the latencies below each bound in LatencyBuckets,
+	// with one more bucket for anything longer
+	Buckets []int
+}
+
+// LatencyBuckets are the upper bounds of the buckets of a LatencyHistogram.
+var LatencyBuckets = []time.Duration{
+	time.Microsecond,
+	10 * time.Microsecond,
+	100 * time.Microsecond,
+	time.Millisecond,
+	10 * time.Millisecond,
+	100 * time.Millisecond,
+}
+
+// NewLatencyHistogram computes the histogram of the given latencies.
+func NewLatencyHistogram(latencies []time.Duration) LatencyHistogram {
+	h := LatencyHistogram{Count: len(latencies), Buckets: make([]int, len(LatencyBuckets)+1)}
+	if len(latencies) == 0 {
+		return h
+	}
+	sorted := slices.Sorted(slices.Values(latencies))
+	quantile := func(q float64) time.Duration { return sorted[int(q*float64(len(sorted)-1))] }
+	h.Min, h.Max = sorted[0], sorted[len(sorted)-1]
+	h.P50, h.P90, h.P99 = quantile(0.5), quantile(0.9), quantile(0.99)
+	for _, l := range sorted {
+		i, _ := slices.BinarySearch(LatencyBuckets, l+1)
+		h.Buckets[i]++
+	}
+	return h
+}
+
+// Lines formats the histogram as lines of text, one per bucket, after a
+// line with the summary statistics.
+func (h LatencyHistogram) Lines() []string {
+	lines := []string{fmt.Sprintf("count %d, min %v, p50 %v, p90 %v, p99 %v, max %v",
+		h.Count, h.Min, h.P50, h.P90, h.P99, h.Max)}
+	for i, n := range h.Buckets {
+		var bucket string
+		if i < len(LatencyBuckets) {
+			bucket = "< " + LatencyBuckets[i].String()
+		} else {
+			bucket = ">= " + LatencyBuckets[i-1].String()
+		}
+		pct := 0.0
+		if h.Count > 0 {
+			pct = 100 * float64(n) / float64(h.Count)
+		}
+		lines = append(lines, fmt.Sprintf("%-10s %8d %6.2f%%", bucket, n, pct))
+	}
+	return lines
+}
+
+// SchedLatencies returns the scheduling latencies in the parsed trace: how
+// long goroutines were runnable each time before they got to run, in the
+// order they got to run. Goroutines still runnable at the end of the trace
+// count as having waited until then.
+func SchedLatencies(parsed ParseResult) []time.Duration {
+	var latencies []time.Duration
+	for _, in := range GoroutineIntervals(parsed) {
+		if in.State == StateRunnable {
+			latencies = append(latencies, time.Duration(in.Duration()))
+		}
+	}
+	return latencies
+}
+
+// hdrSubBucketBits is the log2 of the number of buckets each power of two
+// is split into by an HDRHistogram.
+const hdrSubBucketBits = 2
+
+// HDRHistogram is a histogram of latencies with log-linear buckets, like
+// those of the runtime/metrics /sched/latencies:seconds metric: each power
+// of two range of nanoseconds is split into four equal buckets, so the
+// relative error is the same at every scale. It has the same fields as
+// metrics.Float64Histogram, to be compared with the runtime's.
+type HDRHistogram struct {
+	// Counts are the number of latencies in each bucket
+	Counts []uint64 `json:"counts"`
+	// Buckets are the boundaries of the buckets in seconds, with bucket i
+	// from Buckets[i], inclusive, to Buckets[i+1]. Only the buckets from
+	// the shortest latency to the longest are included.
+	Buckets []float64 `json:"buckets"`
+}
+
+// hdrBucket returns the index of the HDRHistogram bucket of the latency in
+// nanoseconds. Latencies below 1<<hdrSubBucketBits each get their own
+// bucket.
+func hdrBucket(ns uint64) int {
+	if ns < 1<<hdrSubBucketBits {
+		return int(ns)
+	}
+	e := bits.Len64(ns) - 1
+	sub := (ns >> (e - hdrSubBucketBits)) & (1<<hdrSubBucketBits - 1)
+	return (e-hdrSubBucketBits+1)<<hdrSubBucketBits + int(sub)
+}
+
+// hdrLowerBound returns the shortest latency, in nanoseconds, in bucket i.
+func hdrLowerBound(i int) uint64 {
+	if i < 1<<hdrSubBucketBits {
+		return uint64(i)
+	}
+	e := i>>hdrSubBucketBits + hdrSubBucketBits - 1
+	sub := uint64(i & (1<<hdrSubBucketBits - 1))
+	return (1<<hdrSubBucketBits + sub) << (e - hdrSubBucketBits)
+}
+
+// NewHDRHistogram computes the HDRHistogram of the given latencies.
+func NewHDRHistogram(latencies []time.Duration) HDRHistogram {
+	var h HDRHistogram
+	if len(latencies) == 0 {
+		return h
+	}
+	lo, hi := -1, -1
+	counts := make(map[int]uint64)
+	for _, l := range latencies {
+		i := hdrBucket(uint64(max(l, 0)))
+		counts[i]++
+		if lo < 0 || i < lo {
+			lo = i
+		}
+		hi = max(hi, i)
+	}
+	for i := lo; i <= hi; i++ {
+		h.Counts = append(h.Counts, counts[i])
+		h.Buckets = append(h.Buckets, float64(hdrLowerBound(i))/1e9)
+	}
+	h.Buckets = append(h.Buckets, float64(hdrLowerBound(hi+1))/1e9)
+	return h
+}
@@ -0,0 +1,187 @@
+package convert
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ChannelMessage is one goroutine handing a value to another over a
+// channel, as seen from one of them having blocked waiting for the other.
+type ChannelMessage struct {
+	// Ts is when the waiting goroutine was unblocked, in nanoseconds
+	Ts int64 `json:"ts"`
+	// From is the sending goroutine and To the receiving one. For a
+	// close, From closed the channel To was waiting on.
+	From uint64 `json:"from"`
+	To   uint64 `json:"to"`
+	// Op is the operation which unblocked the waiting goroutine: "send",
+	// "recv" or "close", or "select" for a select whose direction the
+	// trace doesn't show, in which case From is the goroutine which
+	// completed the select
+	Op string `json:"op"`
+	// Site is the function, file and line of the unblocking operation, if
+	// the trace has its stack
+	Site string `json:"site,omitempty"`
+}
+
+// ChannelMessages pairs up the channel sends and receives in the parsed
+// trace between start and end, offsets from the start of the trace with
+// zero for no limit. If goroutines isn't empty, only messages to or from
+// those goroutines are kept. The trace only records operations which block,
+// so a send to a receiver which was already waiting shows up, but a send
+// into a buffered channel with room to spare doesn't. Messages are in order
+// of time.
+func ChannelMessages(parsed ParseResult, start, end time.Duration, goroutines []uint64) []ChannelMessage {
+	if len(parsed.Events) == 0 {
+		return nil
+	}
+	base := parsed.Events[0].Ts
+	lo, hi := base+int64(start), parsed.Events[len(parsed.Events)-1].Ts
+	if end > 0 {
+		hi = min(hi, base+int64(end))
+	}
+	type wait struct {
+		g   uint64
+		end int64
+	}
+	reasons := make(map[wait]byte)
+	for _, in := range GoroutineIntervals(parsed) {
+		if in.State == StateBlocked {
+			reasons[wait{in.G, in.End}] = in.Reason
+		}
+	}
+	include := idSet(goroutines)
+
+	var msgs []ChannelMessage
+	for _, ev := range parsed.Events {
+		if ev.Type != EvGoUnblock || ev.G == 0 || ev.Ts < lo || ev.Ts > hi {
+			continue
+		}
+		waiter := ev.Args[0]
+		reason := reasons[wait{waiter, ev.Ts}]
+		if reason != EvGoBlockRecv && reason != EvGoBlockSend && reason != EvGoBlockSelect {
+			continue
+		}
+		stk := parsed.Stacks[ev.StkID]
+		op := channelOp(stk)
+		if op == "" || op == "select" {
+			// without a stack, or from a select, the blocking event
+			// still tells which side the waiter was on, unless it was
+			// in a select too
+			switch reason {
+			case EvGoBlockRecv:
+				op = "send"
+			case EvGoBlockSend:
+				op = "recv"
+			}
+		}
+		if op == "" {
+			continue
+		}
+		msg := ChannelMessage{Ts: ev.Ts, From: ev.G, To: waiter, Op: op, Site: messageSite(stk)}
+		if op == "recv" {
+			msg.From, msg.To = waiter, ev.G
+		}
+		if len(include) > 0 && !include[msg.From] && !include[msg.To] {
+			continue
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs
+}
+
+// channelOp returns which channel operation the unblocking stack is in, or
+// "" if it's none of them. A select hands the value over in runtime.send or
+// runtime.recv, called from runtime.selectgo, as the plain operations do.
+// Newer runtimes leave those frames out of the stack, though, so a select
+// whose direction the stack doesn't show is "select".
+func channelOp(stk []*Frame) string {
+	for _, f := range stk {
+		switch f.Fn {
+		case "runtime.selectgo":
+			return "select"
+		case "runtime.send", "runtime.chansend", "runtime.chansend1", "runtime.selectnbsend":
+			return "send"
+		case "runtime.recv", "runtime.chanrecv", "runtime.chanrecv1", "runtime.chanrecv2", "runtime.selectnbrecv":
+			return "recv"
+		case "runtime.closechan":
+			return "close"
+		}
+	}
+	return ""
+}
+
+// messageSite describes the innermost frame of the stack outside of the
+// runtime, as the function and its file's base name and line.
+func messageSite(stk []*Frame) string {
+	for _, f := range stk {
+		if !strings.HasPrefix(f.Fn, "runtime.") {
+			return fmt.Sprintf("%s %s:%d", f.Fn, path.Base(f.File), f.Line)
+		}
+	}
+	return ""
+}
+
+// ToSequenceDiagram writes msgs, from ChannelMessages of the parsed trace,
+// to out as a sequence diagram with a participant for each goroutine, in
+// order of ID. The format is "mermaid" or "plantuml".
+func ToSequenceDiagram(parsed ParseResult, msgs []ChannelMessage, format string, out io.Writer) error {
+	if format != "mermaid" && format != "plantuml" {
+		return fmt.Errorf("unknown sequence diagram format %q", format)
+	}
+	funcs := GoroutineFuncs(parsed)
+	var gs []uint64
+	for _, m := range msgs {
+		gs = append(gs, m.From, m.To)
+	}
+	slices.Sort(gs)
+	gs = slices.Compact(gs)
+	var base int64
+	if len(parsed.Events) > 0 {
+		base = parsed.Events[0].Ts
+	}
+
+	w := bufio.NewWriter(out)
+	if format == "mermaid" {
+		fmt.Fprintln(w, "sequenceDiagram")
+	} else {
+		fmt.Fprintln(w, "@startuml")
+	}
+	for _, g := range gs {
+		name := fmt.Sprintf("g%d", g)
+		if fn, ok := funcs[g]; ok {
+			name += " " + fn
+		}
+		if format == "mermaid" {
+			fmt.Fprintf(w, "    participant g%d as %s\n", g, mermaidText(name))
+		} else {
+			fmt.Fprintf(w, "participant %q as g%d\n", name, g)
+		}
+	}
+	for _, m := range msgs {
+		label := fmt.Sprintf("%s at %v", m.Op, time.Duration(m.Ts-base).Round(time.Microsecond))
+		if m.Site != "" {
+			label += " in " + m.Site
+		}
+		if format == "mermaid" {
+			fmt.Fprintf(w, "    g%d->>g%d: %s\n", m.From, m.To, mermaidText(label))
+		} else {
+			fmt.Fprintf(w, "g%d -> g%d : %s\n", m.From, m.To, label)
+		}
+	}
+	if format == "plantuml" {
+		fmt.Fprintln(w, "@enduml")
+	}
+	return w.Flush()
+}
+
+// mermaidText escapes the characters which end a statement or start a
+// comment in Mermaid, as its HTML entity codes.
+func mermaidText(s string) string {
+	return strings.NewReplacer(";", "#59;", "%", "#37;", "#", "#35;").Replace(s)
+}
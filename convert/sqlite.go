@@ -0,0 +1,191 @@
+package convert
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+
+	_ "modernc.org/sqlite" // the "sqlite" database/sql driver
+)
+
+// sqliteSchema creates the tables of ToSQLite's database. The indexes are
+// created once the rows are in, which is faster than updating them as
+// they're inserted.
+const sqliteSchema = `
+CREATE TABLE stacks (
+	id INTEGER PRIMARY KEY,
+	hash TEXT NOT NULL
+);
+CREATE TABLE frames (
+	stack_id INTEGER NOT NULL REFERENCES stacks (id),
+	depth INTEGER NOT NULL,
+	func TEXT NOT NULL,
+	file TEXT NOT NULL,
+	line INTEGER NOT NULL,
+	PRIMARY KEY (stack_id, depth)
+) WITHOUT ROWID;
+CREATE TABLE events (
+	ts INTEGER NOT NULL,
+	type TEXT NOT NULL,
+	goroutine INTEGER NOT NULL,
+	p INTEGER,
+	thread INTEGER,
+	stack_id INTEGER REFERENCES stacks (id)
+);
+CREATE TABLE goroutine_intervals (
+	goroutine INTEGER NOT NULL,
+	state TEXT NOT NULL,
+	reason TEXT NOT NULL,
+	start_ts INTEGER NOT NULL,
+	end_ts INTEGER NOT NULL,
+	p INTEGER,
+	stack_id INTEGER REFERENCES stacks (id)
+);
+`
+
+const sqliteIndexes = `
+CREATE INDEX events_ts ON events (ts);
+CREATE INDEX events_goroutine ON events (goroutine, ts);
+CREATE INDEX goroutine_intervals_start ON goroutine_intervals (start_ts);
+CREATE INDEX goroutine_intervals_goroutine ON goroutine_intervals (goroutine, start_ts);
+`
+
+// ToSQLite writes the parsed trace to out as a SQLite database, to explore
+// with SQL. It has these tables:
+//
+//   - events: every event, CPU samples included, with its timestamp, type,
+//     goroutine, P, OS thread and stack
+//   - goroutine_intervals: the state of each goroutine over time, from
+//     GoroutineIntervals, with the reason it was waiting, as
+//     Interval.WaitReason gives it
+//   - stacks: the stacks, with the hash ToCSV gives them
+//   - frames: the frames of each stack, with depth 0 the innermost
+//
+// Timestamps are in nanoseconds since the start of the trace. The p, thread
+// and stack_id columns are NULL when the event wasn't on a P, the thread
+// isn't known, or there's no stack. The events and intervals are indexed by
+// timestamp, and by goroutine and timestamp.
+//
+// SQLite needs a file to write the database to, so it's built in a
+// temporary file, which is then copied to out.
+func ToSQLite(parsed ParseResult, out io.Writer) error {
+	f, err := os.CreateTemp("", "trace2timeline-*.db")
+	if err != nil {
+		return err
+	}
+	name := f.Name()
+	defer os.Remove(name)
+	f.Close()
+	if err := writeSQLite(parsed, name); err != nil {
+		return fmt.Errorf("writing SQLite database: %w", err)
+	}
+	f, err = os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(out, f)
+	return err
+}
+
+// writeSQLite writes the parsed trace to a new SQLite database in the named
+// file, as ToSQLite describes.
+func writeSQLite(parsed ParseResult, name string) error {
+	db, err := sql.Open("sqlite", name)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	// The database is thrown away if anything goes wrong, so there's no
+	// need for a journal or for syncing as it's written.
+	if _, err := db.Exec("PRAGMA journal_mode = OFF; PRAGMA synchronous = OFF;"); err != nil {
+		return err
+	}
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var base int64
+	if len(parsed.Events) > 0 {
+		base = parsed.Events[0].Ts
+	}
+	stackID := func(id uint64) any {
+		if len(parsed.Stacks[id]) == 0 {
+			return nil
+		}
+		return id
+	}
+	proc := func(p int) any {
+		if p < 0 || p >= FakeP {
+			return nil
+		}
+		return p
+	}
+
+	stmt, err := tx.Prepare("INSERT INTO stacks (id, hash) VALUES (?, ?)")
+	if err != nil {
+		return err
+	}
+	frame, err := tx.Prepare("INSERT INTO frames (stack_id, depth, func, file, line) VALUES (?, ?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	for _, id := range slices.Sorted(maps.Keys(parsed.Stacks)) {
+		stk := parsed.Stacks[id]
+		if len(stk) == 0 {
+			continue
+		}
+		if _, err := stmt.Exec(id, StackHash(stk)); err != nil {
+			return err
+		}
+		for depth, f := range stk {
+			if _, err := frame.Exec(id, depth, f.Fn, f.File, f.Line); err != nil {
+				return err
+			}
+		}
+	}
+
+	stmt, err = tx.Prepare("INSERT INTO events (ts, type, goroutine, p, thread, stack_id) VALUES (?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	for _, ev := range parsed.Events {
+		var thread any
+		if ev.M >= 0 {
+			thread = ev.M
+		}
+		if _, err := stmt.Exec(ev.Ts-base, EventDescriptions[ev.Type].Name, ev.G, proc(ev.P), thread, stackID(ev.StkID)); err != nil {
+			return err
+		}
+	}
+
+	stmt, err = tx.Prepare("INSERT INTO goroutine_intervals (goroutine, state, reason, start_ts, end_ts, p, stack_id) VALUES (?, ?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	for _, iv := range GoroutineIntervals(parsed) {
+		var p any
+		if iv.State == StateRunning {
+			p = proc(iv.P)
+		}
+		if _, err := stmt.Exec(iv.G, iv.State.String(), iv.WaitReason(), iv.Start-base, iv.End-base, p, stackID(iv.StkID)); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec(sqliteIndexes); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	return db.Close()
+}
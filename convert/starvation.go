@@ -0,0 +1,163 @@
+package convert
+
+import (
+	"cmp"
+	"maps"
+	"slices"
+	"sort"
+)
+
+// Starvation is a period of a trace during which goroutines had been
+// runnable for longer than a threshold, while every P was busy running
+// other goroutines. Times are in nanoseconds.
+type Starvation struct {
+	Start int64 `json:"start_ns"`
+	End   int64 `json:"end_ns"`
+	// Waiting are the goroutines which were starved, in order of ID, and
+	// LongestWait the longest any of them had been runnable by the time it
+	// ran
+	Waiting     []uint64 `json:"waiting"`
+	LongestWait int64    `json:"longest_wait_ns"`
+	// Hogs are the goroutines which ran during the period, those which ran
+	// the longest first
+	Hogs []CPUHog `json:"hogs"`
+}
+
+// CPUHog is a goroutine which ran during a Starvation period.
+type CPUHog struct {
+	G uint64 `json:"g"`
+	// Func is the function the goroutine was started with, if known
+	Func string `json:"func,omitempty"`
+	// Running is how long the goroutine ran during the period
+	Running int64 `json:"running_ns"`
+}
+
+// RunQueueStarvation finds the periods of the parsed trace when goroutines
+// waited in the run queue for more than threshold nanoseconds because the
+// Ps were all busy, as Starvation describes. A period starts once the
+// first goroutine has waited past the threshold, so it's when the excess
+// wait happened. Periods less than threshold apart are merged, since a P
+// which is idle only briefly, between one goroutine and the next, doesn't
+// relieve the starvation. The periods are in order of time. If the trace
+// doesn't record GOMAXPROCS, the number of Ps which ran goroutines stands
+// in for it.
+func RunQueueStarvation(parsed ParseResult, threshold int64) []Starvation {
+	if len(parsed.Events) == 0 {
+		return nil
+	}
+	intervals := GoroutineIntervals(parsed)
+	saturated := saturatedSpans(parsed, intervals)
+
+	type piece struct {
+		start, end int64
+		in         Interval
+	}
+	var pieces []piece
+	for _, in := range intervals {
+		if in.State != StateRunnable || in.Duration() <= threshold {
+			continue
+		}
+		from := in.Start + threshold
+		i := sort.Search(len(saturated), func(i int) bool { return saturated[i].End > from })
+		for ; i < len(saturated) && saturated[i].Start < in.End; i++ {
+			pieces = append(pieces, piece{max(from, saturated[i].Start), min(in.End, saturated[i].End), in})
+		}
+	}
+	slices.SortFunc(pieces, func(a, b piece) int { return cmp.Compare(a.start, b.start) })
+
+	var periods []Starvation
+	waiting := make(map[uint64]bool)
+	for _, p := range pieces {
+		if n := len(periods); n == 0 || p.start > periods[n-1].End+threshold {
+			if n > 0 {
+				periods[n-1].Waiting = slices.Sorted(maps.Keys(waiting))
+				clear(waiting)
+			}
+			periods = append(periods, Starvation{Start: p.start, End: p.end})
+		}
+		cur := &periods[len(periods)-1]
+		cur.End = max(cur.End, p.end)
+		cur.LongestWait = max(cur.LongestWait, p.in.Duration())
+		waiting[p.in.G] = true
+	}
+	if len(periods) == 0 {
+		return nil
+	}
+	periods[len(periods)-1].Waiting = slices.Sorted(maps.Keys(waiting))
+
+	funcs := GoroutineFuncs(parsed)
+	for i := range periods {
+		cur := &periods[i]
+		running := make(map[uint64]int64)
+		for _, in := range intervals {
+			if in.State == StateRunning && in.Start < cur.End && in.End > cur.Start {
+				running[in.G] += min(in.End, cur.End) - max(in.Start, cur.Start)
+			}
+		}
+		for g, d := range running {
+			cur.Hogs = append(cur.Hogs, CPUHog{G: g, Func: funcs[g], Running: d})
+		}
+		slices.SortFunc(cur.Hogs, func(a, b CPUHog) int {
+			return cmp.Or(cmp.Compare(b.Running, a.Running), cmp.Compare(a.G, b.G))
+		})
+	}
+	return periods
+}
+
+// saturatedSpans returns the spans of time, in order, during which as
+// many goroutines were running as GOMAXPROCS allowed, from the running
+// intervals of the parsed trace.
+func saturatedSpans(parsed ParseResult, intervals []Interval) []Interval {
+	type change struct {
+		ts      int64
+		running int
+		procs   int
+	}
+	var changes []change
+	ps := make(map[int]bool)
+	for _, in := range intervals {
+		if in.State == StateRunning {
+			changes = append(changes, change{ts: in.Start, running: 1}, change{ts: in.End, running: -1})
+			ps[in.P] = true
+		}
+	}
+	procs := 0
+	for _, ev := range parsed.Events {
+		if ev.Type == EvGomaxprocs {
+			changes = append(changes, change{ts: ev.Ts, procs: int(ev.Args[0])})
+		}
+	}
+	if !slices.ContainsFunc(changes, func(c change) bool { return c.procs > 0 }) {
+		procs = max(len(ps), 1)
+	}
+	// at the same time, stopping goroutines come before starting ones, so
+	// a P handing over from one goroutine to the next doesn't look
+	// oversubscribed
+	slices.SortStableFunc(changes, func(a, b change) int {
+		return cmp.Or(cmp.Compare(a.ts, b.ts), cmp.Compare(a.running, b.running))
+	})
+
+	var spans []Interval
+	running, open := 0, false
+	for i, c := range changes {
+		running += c.running
+		if c.procs > 0 {
+			procs = c.procs
+		}
+		if i+1 < len(changes) && changes[i+1].ts == c.ts {
+			continue
+		}
+		busy := procs > 0 && running >= procs
+		switch {
+		case busy && !open:
+			spans = append(spans, Interval{Start: c.ts})
+		case !busy && open:
+			spans[len(spans)-1].End = c.ts
+		}
+		open = busy
+	}
+	if open {
+		spans[len(spans)-1].End = parsed.Events[len(parsed.Events)-1].Ts
+	}
+	return spans
+}
@@ -0,0 +1,189 @@
+package convert
+
+import "sort"
+
+// GoroutineState is the scheduling state of a goroutine over an Interval.
+type GoroutineState int
+
+const (
+	StateRunnable GoroutineState = iota
+	StateRunning
+	StateBlocked
+	StateSyscall
+)
+
+func (s GoroutineState) String() string {
+	switch s {
+	case StateRunnable:
+		return "runnable"
+	case StateRunning:
+		return "running"
+	case StateBlocked:
+		return "blocked"
+	case StateSyscall:
+		return "syscall"
+	}
+	return "unknown"
+}
+
+// Interval is a span of time a goroutine spent in a single state.
+type Interval struct {
+	G     uint64
+	State GoroutineState
+	// Start and End are timestamps in nanoseconds
+	Start int64
+	End   int64
+	// Reason is the type of the event which put the goroutine in this
+	// state, e.g. EvGoBlockRecv for a goroutine blocked on a channel
+	// receive.
+	Reason byte
+	// StkID is the stack of the event which put the goroutine in this
+	// state. For a blocked goroutine, this is where it blocked. For a
+	// runnable goroutine, this is where it was created, unblocked or
+	// preempted.
+	StkID uint64
+	// P is the P the goroutine ran on, for running intervals.
+	P int
+}
+
+// Duration returns the length of the interval in nanoseconds.
+func (i Interval) Duration() int64 {
+	return i.End - i.Start
+}
+
+// WaitReason describes why the goroutine wasn't running during the
+// interval, e.g. "chan receive" or "runnable". It returns "running" for
+// running intervals.
+func (i Interval) WaitReason() string {
+	switch i.State {
+	case StateRunning, StateRunnable, StateSyscall:
+		return i.State.String()
+	}
+	switch i.Reason {
+	case EvGoSleep:
+		return "sleep"
+	case EvGoBlockSend:
+		return "chan send"
+	case EvGoBlockRecv:
+		return "chan receive"
+	case EvGoBlockSelect:
+		return "select"
+	case EvGoBlockSync:
+		return "sync"
+	case EvGoBlockCond:
+		return "sync.Cond"
+	case EvGoBlockNet:
+		return "network"
+	case EvGoBlockGC:
+		return "GC assist wait"
+	case EvGoStop:
+		return "stopped"
+	case EvGoWaiting:
+		return "waiting"
+	}
+	return "blocked"
+}
+
+// GoroutineIntervals reconstructs the state of every goroutine over the
+// course of the trace from its scheduling events. The intervals are returned
+// in the order that they ended. Intervals still open at the end of the trace
+// are closed at the timestamp of the last event.
+func GoroutineIntervals(parsed ParseResult) []Interval {
+	var intervals []Interval
+	open := make(map[uint64]*Interval)
+	// the stack of each goroutine's most recent syscall, since the
+	// syscall event and the event marking the syscall as blocking are
+	// separate
+	syscalls := make(map[uint64]uint64)
+
+	transition := func(g uint64, ts int64, state GoroutineState, reason byte, stk uint64, p int) {
+		if cur, ok := open[g]; ok {
+			cur.End = ts
+			intervals = append(intervals, *cur)
+		}
+		open[g] = &Interval{G: g, State: state, Start: ts, Reason: reason, StkID: stk, P: p}
+	}
+	end := func(g uint64, ts int64) {
+		if cur, ok := open[g]; ok {
+			cur.End = ts
+			intervals = append(intervals, *cur)
+			delete(open, g)
+		}
+	}
+
+	var last int64
+	for _, ev := range parsed.Events {
+		last = ev.Ts
+		switch ev.Type {
+		case EvGoCreate:
+			transition(ev.Args[0], ev.Ts, StateRunnable, ev.Type, ev.StkID, 0)
+		case EvGoWaiting:
+			transition(ev.G, ev.Ts, StateBlocked, ev.Type, 0, 0)
+		case EvGoInSyscall:
+			transition(ev.G, ev.Ts, StateSyscall, ev.Type, 0, 0)
+		case EvGoStart, EvGoStartLabel:
+			transition(ev.G, ev.Ts, StateRunning, ev.Type, 0, ev.P)
+		case EvGoEnd:
+			end(ev.G, ev.Ts)
+		case EvGoSched, EvGoPreempt:
+			transition(ev.G, ev.Ts, StateRunnable, ev.Type, ev.StkID, 0)
+		case EvGoStop, EvGoSleep, EvGoBlock, EvGoBlockSend, EvGoBlockRecv,
+			EvGoBlockSelect, EvGoBlockSync, EvGoBlockCond, EvGoBlockNet,
+			EvGoBlockGC:
+			transition(ev.G, ev.Ts, StateBlocked, ev.Type, ev.StkID, 0)
+		case EvGoUnblock:
+			transition(ev.Args[0], ev.Ts, StateRunnable, ev.Type, ev.StkID, 0)
+		case EvGoSysCall:
+			syscalls[ev.G] = ev.StkID
+		case EvGoSysBlock:
+			transition(ev.G, ev.Ts, StateSyscall, ev.Type, syscalls[ev.G], 0)
+		case EvGoSysExit:
+			transition(ev.G, ev.Ts, StateRunnable, ev.Type, 0, 0)
+		}
+	}
+	var still []uint64
+	for g := range open {
+		still = append(still, g)
+	}
+	sort.Slice(still, func(i, j int) bool { return still[i] < still[j] })
+	for _, g := range still {
+		end(g, last)
+	}
+	return intervals
+}
+
+// GoroutineFuncs returns the name of the function each goroutine in the
+// trace was started with, such as "net/http.(*conn).serve", which says more
+// about what a goroutine does than its ID. It's taken from the stack the
+// goroutine was created with if the trace has it, or else from the root
+// frame of the first stack seen on the goroutine. Goroutines with no stacks
+// at all are left out.
+func GoroutineFuncs(parsed ParseResult) map[uint64]string {
+	funcs := make(map[uint64]string)
+	root := func(stkID uint64) string {
+		stk := parsed.Stacks[stkID]
+		for i := len(stk) - 1; i >= 0; i-- {
+			if stk[i].Fn != "runtime.goexit" {
+				return stk[i].Fn
+			}
+		}
+		return ""
+	}
+	for _, ev := range parsed.Events {
+		if ev.Type == EvGoCreate {
+			if fn := root(ev.Args[1]); fn != "" {
+				funcs[ev.Args[0]] = fn
+			}
+			continue
+		}
+		if ev.G == 0 || ev.StkID == 0 {
+			continue
+		}
+		if _, ok := funcs[ev.G]; !ok {
+			if fn := root(ev.StkID); fn != "" {
+				funcs[ev.G] = fn
+			}
+		}
+	}
+	return funcs
+}
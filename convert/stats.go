@@ -0,0 +1,78 @@
+package convert
+
+import (
+	"maps"
+	"slices"
+)
+
+// GoroutineStats are the totals for one goroutine over the trace. Times are
+// in nanoseconds, and only count the part of the trace the goroutine was
+// seen in.
+type GoroutineStats struct {
+	ID uint64 `json:"id"`
+	// Func is the function the goroutine was started with, if known
+	Func     string `json:"func,omitempty"`
+	Running  int64  `json:"running_ns"`
+	Runnable int64  `json:"runnable_ns"`
+	// Blocked is the total time the goroutine was blocked, and BlockedBy
+	// breaks it down by reason, as Interval.WaitReason gives it
+	Blocked   int64            `json:"blocked_ns"`
+	BlockedBy map[string]int64 `json:"blocked_by,omitempty"`
+	Syscall   int64            `json:"syscall_ns"`
+	// CPUSamples is the number of CPU samples taken on the goroutine
+	CPUSamples int `json:"cpu_samples"`
+	// CreatedBy is the goroutine which created this one, and CreatedAt the
+	// stack it did so from, if the trace has them. Goroutines which existed
+	// before the trace started don't have them.
+	CreatedBy uint64       `json:"created_by,omitempty"`
+	CreatedAt []StackFrame `json:"created_at,omitempty"`
+}
+
+// NewGoroutineStats computes the totals for each goroutine in the parsed
+// trace, ordered by goroutine ID.
+func NewGoroutineStats(parsed ParseResult) []GoroutineStats {
+	stats := make(map[uint64]*GoroutineStats)
+	funcs := GoroutineFuncs(parsed)
+	get := func(g uint64) *GoroutineStats {
+		s, ok := stats[g]
+		if !ok {
+			s = &GoroutineStats{ID: g, Func: funcs[g]}
+			stats[g] = s
+		}
+		return s
+	}
+	for _, in := range GoroutineIntervals(parsed) {
+		s := get(in.G)
+		switch in.State {
+		case StateRunning:
+			s.Running += in.Duration()
+		case StateRunnable:
+			s.Runnable += in.Duration()
+		case StateSyscall:
+			s.Syscall += in.Duration()
+		case StateBlocked:
+			s.Blocked += in.Duration()
+			if s.BlockedBy == nil {
+				s.BlockedBy = make(map[string]int64)
+			}
+			s.BlockedBy[in.WaitReason()] += in.Duration()
+		}
+	}
+	for _, ev := range parsed.Events {
+		switch {
+		case ev.Type == EvCPUSample && ev.G != 0:
+			get(ev.G).CPUSamples++
+		case ev.Type == EvGoCreate && ev.G != 0:
+			s := get(ev.Args[0])
+			s.CreatedBy = ev.G
+			if stk := parsed.Stacks[ev.StkID]; len(stk) > 0 {
+				s.CreatedAt = jsonFrames(stk)
+			}
+		}
+	}
+	out := make([]GoroutineStats, 0, len(stats))
+	for _, g := range slices.Sorted(maps.Keys(stats)) {
+		out = append(out, *stats[g])
+	}
+	return out
+}
@@ -0,0 +1,113 @@
+package convert
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// STWPause is a stop-the-world pause during the trace.
+type STWPause struct {
+	// Start and End are timestamps in nanoseconds
+	Start int64
+	End   int64
+	// Reason is why the world was stopped, e.g. "GC mark termination"
+	Reason string
+	// StkID is the stack of the goroutine which stopped the world, if
+	// the trace records it
+	StkID uint64
+}
+
+// Duration returns the length of the pause in nanoseconds.
+func (p STWPause) Duration() int64 {
+	return p.End - p.Start
+}
+
+// STWPauses returns the stop-the-world pauses in the trace, in order.
+func STWPauses(parsed ParseResult) []STWPause {
+	var pauses []STWPause
+	var cur *Event
+	for _, ev := range parsed.Events {
+		switch ev.Type {
+		case EvGCSTWStart:
+			cur = ev
+		case EvGCSTWDone:
+			if cur == nil {
+				continue
+			}
+			p := STWPause{Start: cur.Ts, End: ev.Ts, StkID: cur.StkID}
+			if len(cur.SArgs) > 0 {
+				p.Reason = cur.SArgs[0]
+			}
+			pauses = append(pauses, p)
+			cur = nil
+		}
+	}
+	return pauses
+}
+
+// LongPauses returns the stop-the-world pauses in the trace which took
+// longer than threshold nanoseconds, in order. These are the anomalies the
+// timeline, JSON and Chrome outputs mark. A threshold of zero or less
+// selects none of them.
+func LongPauses(parsed ParseResult, threshold int64) []STWPause {
+	if threshold <= 0 {
+		return nil
+	}
+	var long []STWPause
+	for _, p := range STWPauses(parsed) {
+		if p.Duration() > threshold {
+			long = append(long, p)
+		}
+	}
+	return long
+}
+
+// STWPauseJSON is the JSON representation of an STWPause.
+type STWPauseJSON struct {
+	// Start is the wall clock time the pause started
+	Start time.Time `json:"start"`
+	// Offset is when the pause started relative to the start of the
+	// trace, in nanoseconds
+	Offset   int64        `json:"offset_ns"`
+	Duration int64        `json:"duration_ns"`
+	Reason   string       `json:"reason"`
+	Stack    []StackFrame `json:"stack,omitempty"`
+}
+
+// ToSTWJSON writes the stop-the-world pauses in the parsed trace to out as a
+// JSON array of STWPauseJSON. The trace started at the given time.
+func ToSTWJSON(parsed ParseResult, start time.Time, out io.Writer) error {
+	var base int64
+	if len(parsed.Events) > 0 {
+		base = parsed.Events[0].Ts
+	}
+	pauses := []STWPauseJSON{}
+	for _, p := range STWPauses(parsed) {
+		pj := STWPauseJSON{
+			Start:    start.Add(time.Duration(p.Start - base)),
+			Offset:   p.Start - base,
+			Duration: p.Duration(),
+			Reason:   p.Reason,
+		}
+		for _, frame := range parsed.Stacks[p.StkID] {
+			pj.Stack = append(pj.Stack, StackFrame{Func: frame.Fn, File: frame.File, Line: frame.Line})
+		}
+		pauses = append(pauses, pj)
+	}
+	return json.NewEncoder(out).Encode(pauses)
+}
+
+// ToSTW writes a pprof profile of the stop-the-world pauses in the trace to
+// out, uncompressed, with sample types pauses/count and pause/nanoseconds.
+// Each sample is the stack which stopped the world, if known, under a leaf
+// frame with the reason, such as "[STW: GC mark termination]". The breakdown
+// has the start time and duration of each pause.
+func ToSTW(parsed ParseResult, start, stop time.Time, opts PprofOptions, out io.Writer) error {
+	pause := valueType{"pause", "nanoseconds"}
+	b := newProfileBuilder(parsed, opts, pause, valueType{"pauses", "count"}, pause)
+	for _, p := range STWPauses(parsed) {
+		b.add(sampleKey{stkID: p.StkID, leaf: "[STW: " + p.Reason + "]"}, p.Start, 1, p.Duration())
+	}
+	return b.write(start, stop, out)
+}
@@ -0,0 +1,82 @@
+package convert
+
+import (
+	"cmp"
+	"slices"
+)
+
+// LongSyscall is a syscall during which the goroutine lost its P, because
+// sysmon retook it once the syscall had run long enough, or because the
+// syscall was known to block. The runtime starts another thread to run the
+// P, so syscalls like these grow the number of threads, and the time they
+// take doesn't show up in CPU profiles. Times are in nanoseconds.
+type LongSyscall struct {
+	G uint64 `json:"g"`
+	// Func is the function the goroutine was started with, if known
+	Func string `json:"func,omitempty"`
+	// Start is when the syscall started, Retaken when the goroutine lost
+	// its P, and End when the syscall returned. A syscall already in
+	// progress when the trace started has the same Start as Retaken.
+	Start   int64 `json:"start_ns"`
+	Retaken int64 `json:"retaken_ns"`
+	End     int64 `json:"end_ns"`
+	// Incomplete is whether the syscall was still in progress at the end
+	// of the trace, so that End is the end of the trace
+	Incomplete bool         `json:"incomplete,omitempty"`
+	Stack      []StackFrame `json:"stack,omitempty"`
+}
+
+// Duration returns the length of the syscall in nanoseconds.
+func (s LongSyscall) Duration() int64 {
+	return s.End - s.Start
+}
+
+// LongSyscalls finds the syscalls in the parsed trace which lost their P,
+// as LongSyscall describes, and which took at least minDuration
+// nanoseconds. The longest come first.
+func LongSyscalls(parsed ParseResult, minDuration int64) []LongSyscall {
+	if len(parsed.Events) == 0 {
+		return nil
+	}
+	funcs := GoroutineFuncs(parsed)
+	// the syscall event each goroutine last entered a syscall with
+	entered := make(map[uint64]*Event)
+	blocked := make(map[uint64]*LongSyscall)
+	var syscalls []LongSyscall
+	for _, ev := range parsed.Events {
+		switch ev.Type {
+		case EvGoSysCall:
+			entered[ev.G] = ev
+		case EvGoInSyscall:
+			blocked[ev.G] = &LongSyscall{G: ev.G, Start: ev.Ts, Retaken: ev.Ts}
+		case EvGoSysBlock:
+			s := &LongSyscall{G: ev.G, Start: ev.Ts, Retaken: ev.Ts}
+			if enter, ok := entered[ev.G]; ok {
+				s.Start = enter.Ts
+				s.Stack = jsonFrames(parsed.Stacks[enter.StkID])
+			}
+			blocked[ev.G] = s
+			delete(entered, ev.G)
+		case EvGoSysExit:
+			if s, ok := blocked[ev.G]; ok {
+				s.End = ev.Ts
+				syscalls = append(syscalls, *s)
+				delete(blocked, ev.G)
+			}
+		}
+	}
+	last := parsed.Events[len(parsed.Events)-1].Ts
+	for _, s := range blocked {
+		s.End, s.Incomplete = last, true
+		syscalls = append(syscalls, *s)
+	}
+
+	syscalls = slices.DeleteFunc(syscalls, func(s LongSyscall) bool { return s.Duration() < minDuration })
+	for i := range syscalls {
+		syscalls[i].Func = funcs[syscalls[i].G]
+	}
+	slices.SortFunc(syscalls, func(a, b LongSyscall) int {
+		return cmp.Or(cmp.Compare(b.Duration(), a.Duration()), cmp.Compare(a.Start, b.Start), cmp.Compare(a.G, b.G))
+	})
+	return syscalls
+}
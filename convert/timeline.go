@@ -0,0 +1,160 @@
+package convert
+
+import (
+	"cmp"
+	"encoding/json"
+	"io"
+	"maps"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// Timeline is a per-goroutine view of a trace: each goroutine is a lane of
+// the intervals it spent in each state.
+type Timeline struct {
+	// Start is the wall clock time at which the trace started
+	Start time.Time `json:"start"`
+	// Duration is the length of the trace in nanoseconds
+	Duration   int64                   `json:"duration_ns"`
+	Goroutines []TimelineGoroutine     `json:"goroutines"`
+	Stacks     map[string][]StackFrame `json:"stacks"`
+	// LongPauses are the stop-the-world pauses longer than the threshold
+	// the timeline was built with, which are worth a closer look
+	LongPauses []TimelinePause `json:"long_pauses,omitempty"`
+}
+
+// TimelinePause is a stop-the-world pause in a Timeline.
+type TimelinePause struct {
+	// Start and End are nanoseconds since the start of the trace
+	Start  int64  `json:"start"`
+	End    int64  `json:"end"`
+	Reason string `json:"reason,omitempty"`
+	// Stack is the key in Timeline.Stacks of the stack of the goroutine
+	// which stopped the world
+	Stack string `json:"stack,omitempty"`
+}
+
+// TimelineGoroutine is the lane of a single goroutine.
+type TimelineGoroutine struct {
+	ID uint64 `json:"id"`
+	// Func is the function the goroutine was started with, if known
+	Func      string             `json:"func,omitempty"`
+	Intervals []TimelineInterval `json:"intervals"`
+	Logs      []TimelineLog      `json:"logs,omitempty"`
+}
+
+// TimelineLog is a message the goroutine logged with trace.Log.
+type TimelineLog struct {
+	// Ts is nanoseconds since the start of the trace
+	Ts       int64  `json:"ts"`
+	Category string `json:"category,omitempty"`
+	Message  string `json:"message"`
+	// Task is the ID of the task the message was logged in, if any
+	Task  uint64 `json:"task,omitempty"`
+	Stack string `json:"stack,omitempty"`
+}
+
+// TimelineInterval is a span of time a goroutine spent in a single state.
+type TimelineInterval struct {
+	State string `json:"state"`
+	// Start and End are nanoseconds since the start of the trace
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+	// Reason is why a goroutine was blocked, e.g. "chan receive"
+	Reason string `json:"reason,omitempty"`
+	// Stack is the key of the interval's stack in Timeline.Stacks. See
+	// Interval.StkID for which stack that is.
+	Stack string `json:"stack,omitempty"`
+	// P is the P a running goroutine ran on
+	P *int `json:"p,omitempty"`
+}
+
+// NewTimeline builds the timeline of the parsed trace, which started at the
+// given time. Stop-the-world pauses longer than pauseThreshold nanoseconds
+// go in LongPauses, if pauseThreshold is positive.
+func NewTimeline(parsed ParseResult, start time.Time, pauseThreshold int64) *Timeline {
+	var base int64
+	if len(parsed.Events) > 0 {
+		base = parsed.Events[0].Ts
+	}
+	tl := &Timeline{
+		Start:    start,
+		Duration: int64(Duration(parsed)),
+		Stacks:   make(map[string][]StackFrame),
+	}
+	lanes := make(map[uint64]*TimelineGoroutine)
+	funcs := GoroutineFuncs(parsed)
+	lane := func(g uint64) *TimelineGoroutine {
+		l, ok := lanes[g]
+		if !ok {
+			l = &TimelineGoroutine{ID: g, Func: funcs[g]}
+			lanes[g] = l
+		}
+		return l
+	}
+	stack := func(stkID uint64) string { return tl.stack(parsed, stkID) }
+	for _, in := range GoroutineIntervals(parsed) {
+		ti := TimelineInterval{
+			State: in.State.String(),
+			Start: in.Start - base,
+			End:   in.End - base,
+		}
+		switch in.State {
+		case StateRunning:
+			p := in.P
+			ti.P = &p
+		case StateBlocked:
+			ti.Reason = in.WaitReason()
+		}
+		ti.Stack = stack(in.StkID)
+		l := lane(in.G)
+		l.Intervals = append(l.Intervals, ti)
+	}
+	for _, log := range UserAnnotations(parsed).Logs {
+		l := lane(log.G)
+		l.Logs = append(l.Logs, TimelineLog{
+			Ts:       log.Ts - base,
+			Category: log.Category,
+			Message:  log.Message,
+			Task:     log.Task,
+			Stack:    stack(log.StkID),
+		})
+	}
+	for _, p := range LongPauses(parsed, pauseThreshold) {
+		tl.LongPauses = append(tl.LongPauses, TimelinePause{
+			Start:  p.Start - base,
+			End:    p.End - base,
+			Reason: p.Reason,
+			Stack:  stack(p.StkID),
+		})
+	}
+	for _, g := range slices.Sorted(maps.Keys(lanes)) {
+		l := lanes[g]
+		slices.SortStableFunc(l.Intervals, func(a, b TimelineInterval) int { return cmp.Compare(a.Start, b.Start) })
+		tl.Goroutines = append(tl.Goroutines, *l)
+	}
+	return tl
+}
+
+// stack adds the stack with the given ID to tl.Stacks, if it isn't there
+// yet, and returns its key, or "" if there's no such stack.
+func (tl *Timeline) stack(parsed ParseResult, stkID uint64) string {
+	stk := parsed.Stacks[stkID]
+	if len(stk) == 0 {
+		return ""
+	}
+	key := strconv.FormatUint(stkID, 10)
+	if _, ok := tl.Stacks[key]; !ok {
+		tl.Stacks[key] = jsonFrames(stk)
+	}
+	return key
+}
+
+// ToTimeline writes the per-goroutine timeline of the parsed trace, which
+// started at the given time, to out as JSON, marking the stop-the-world
+// pauses longer than pauseThreshold nanoseconds. See Timeline for the
+// structure.
+func ToTimeline(parsed ParseResult, start time.Time, pauseThreshold int64, out io.Writer) error {
+	return json.NewEncoder(out).Encode(NewTimeline(parsed, start, pauseThreshold))
+}
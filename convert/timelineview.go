@@ -0,0 +1,94 @@
+package convert
+
+import (
+	_ "embed"
+	"html/template"
+	"io"
+	"time"
+)
+
+//go:embed timelineview.html
+var timelineViewHTML string
+
+var timelineViewTemplate = template.Must(template.New("timeline").Parse(timelineViewHTML))
+
+// timelineView is the data the timeline page draws: the goroutine lanes of
+// a Timeline, with the CPU samples, stop-the-world pauses and user regions
+// of the trace on top. Stacks are keys of Timeline.Stacks, and times are
+// nanoseconds since the start of the trace, as in the Timeline.
+type timelineView struct {
+	*Timeline
+	Samples []timelineSample `json:"samples"`
+	Pauses  []timelinePause  `json:"pauses"`
+	Regions []timelineRegion `json:"regions"`
+}
+
+type timelineSample struct {
+	Ts int64 `json:"ts"`
+	// G is the goroutine the sample was taken on, or 0 if none was running
+	G     uint64 `json:"g"`
+	Stack string `json:"stack,omitempty"`
+}
+
+type timelinePause struct {
+	Start  int64  `json:"start"`
+	End    int64  `json:"end"`
+	Reason string `json:"reason,omitempty"`
+	Stack  string `json:"stack,omitempty"`
+}
+
+type timelineRegion struct {
+	G     uint64 `json:"g"`
+	Name  string `json:"name"`
+	Start int64  `json:"start"`
+	End   int64  `json:"end"`
+	Depth int    `json:"depth"`
+	Stack string `json:"stack,omitempty"`
+}
+
+// ToTimelineHTML writes the timeline of the parsed trace, which started at
+// the given time, to out as a self-contained HTML page which draws it: a
+// lane per goroutine showing its states, CPU samples and user regions, and
+// the stop-the-world pauses, such as those of the GC, above them. Scroll
+// through the goroutines, zoom with ctrl and the mouse wheel or W and S,
+// pan by dragging or with A and D, and hover over anything to see its
+// stack. Like ToFlamegraph's, the page has no external dependencies.
+func ToTimelineHTML(parsed ParseResult, start time.Time, title string, out io.Writer) error {
+	var base int64
+	if len(parsed.Events) > 0 {
+		base = parsed.Events[0].Ts
+	}
+	view := timelineView{Timeline: NewTimeline(parsed, start, 0)}
+	for _, ev := range parsed.Events {
+		if ev.Type != EvCPUSample {
+			continue
+		}
+		view.Samples = append(view.Samples, timelineSample{
+			Ts:    ev.Ts - base,
+			G:     ev.G,
+			Stack: view.stack(parsed, ev.StkID),
+		})
+	}
+	for _, p := range STWPauses(parsed) {
+		view.Pauses = append(view.Pauses, timelinePause{
+			Start:  p.Start - base,
+			End:    p.End - base,
+			Reason: p.Reason,
+			Stack:  view.stack(parsed, p.StkID),
+		})
+	}
+	for _, r := range UserAnnotations(parsed).Regions {
+		view.Regions = append(view.Regions, timelineRegion{
+			G:     r.G,
+			Name:  r.Name,
+			Start: r.Start - base,
+			End:   r.End - base,
+			Depth: r.Depth,
+			Stack: view.stack(parsed, r.StkID),
+		})
+	}
+	return timelineViewTemplate.Execute(out, struct {
+		Title string
+		Data  timelineView
+	}{title, view})
+}
@@ -0,0 +1,62 @@
+package convert
+
+import (
+	"cmp"
+	"slices"
+)
+
+// TopFunc is the number of CPU samples in a function, as in pprof's top
+// report.
+type TopFunc struct {
+	Func string
+	// Flat is the number of samples in the function itself, and Cum the
+	// number in it or anything it called
+	Flat int
+	Cum  int
+}
+
+// TopFuncs counts the CPU samples in the parsed trace by function, and
+// returns the functions ordered by flat count, then by cumulative count,
+// along with the total number of samples. Samples without a stack are
+// counted under "[unknown]". A function which appears more than once in a
+// stack, through recursion, only counts once towards its cumulative count.
+func TopFuncs(parsed ParseResult) (funcs []TopFunc, total int) {
+	byName := make(map[string]*TopFunc)
+	get := func(fn string) *TopFunc {
+		f, ok := byName[fn]
+		if !ok {
+			f = &TopFunc{Func: fn}
+			byName[fn] = f
+		}
+		return f
+	}
+	seen := make(map[string]bool)
+	for _, ev := range parsed.Events {
+		if ev.Type != EvCPUSample {
+			continue
+		}
+		total++
+		stk := parsed.Stacks[ev.StkID]
+		if len(stk) == 0 {
+			f := get("[unknown]")
+			f.Flat++
+			f.Cum++
+			continue
+		}
+		get(stk[0].Fn).Flat++
+		clear(seen)
+		for _, frame := range stk {
+			if !seen[frame.Fn] {
+				seen[frame.Fn] = true
+				get(frame.Fn).Cum++
+			}
+		}
+	}
+	for _, f := range byName {
+		funcs = append(funcs, *f)
+	}
+	slices.SortFunc(funcs, func(a, b TopFunc) int {
+		return cmp.Or(cmp.Compare(b.Flat, a.Flat), cmp.Compare(b.Cum, a.Cum), cmp.Compare(a.Func, b.Func))
+	})
+	return funcs, total
+}
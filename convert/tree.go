@@ -0,0 +1,105 @@
+package convert
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"time"
+)
+
+// GoroutineNode is a goroutine in the tree of which goroutine created which.
+// Times are in nanoseconds.
+type GoroutineNode struct {
+	ID uint64 `json:"id"`
+	// Func is the function the goroutine was started with, if known
+	Func       string `json:"func,omitempty"`
+	Running    int64  `json:"running_ns"`
+	Blocked    int64  `json:"blocked_ns"`
+	CPUSamples int    `json:"cpu_samples"`
+	// SubtreeRunning, SubtreeBlocked and SubtreeSamples add up the same for
+	// the goroutine and all of its descendants, to see which goroutines
+	// are responsible for the load of those they start
+	SubtreeRunning int64 `json:"subtree_running_ns"`
+	SubtreeBlocked int64 `json:"subtree_blocked_ns"`
+	SubtreeSamples int   `json:"subtree_cpu_samples"`
+	// Children are the goroutines this one created, in order of ID
+	Children []*GoroutineNode `json:"children,omitempty"`
+}
+
+// GoroutineTree builds the tree of which goroutine created which in the
+// parsed trace, from NewGoroutineStats, and returns its roots in order of
+// ID. Goroutines which existed before the trace started are roots, since
+// there's no telling what created them.
+func GoroutineTree(parsed ParseResult) []*GoroutineNode {
+	stats := NewGoroutineStats(parsed)
+	nodes := make(map[uint64]*GoroutineNode, len(stats))
+	for _, s := range stats {
+		nodes[s.ID] = &GoroutineNode{
+			ID:         s.ID,
+			Func:       s.Func,
+			Running:    s.Running,
+			Blocked:    s.Blocked,
+			CPUSamples: s.CPUSamples,
+		}
+	}
+	var roots []*GoroutineNode
+	// stats are ordered by ID, so the children are too
+	for _, s := range stats {
+		n := nodes[s.ID]
+		if parent, ok := nodes[s.CreatedBy]; ok && s.CreatedBy != 0 {
+			parent.Children = append(parent.Children, n)
+		} else {
+			roots = append(roots, n)
+		}
+	}
+	var sum func(n *GoroutineNode)
+	sum = func(n *GoroutineNode) {
+		n.SubtreeRunning, n.SubtreeBlocked, n.SubtreeSamples = n.Running, n.Blocked, n.CPUSamples
+		for _, c := range n.Children {
+			sum(c)
+			n.SubtreeRunning += c.SubtreeRunning
+			n.SubtreeBlocked += c.SubtreeBlocked
+			n.SubtreeSamples += c.SubtreeSamples
+		}
+	}
+	for _, r := range roots {
+		sum(r)
+	}
+	return roots
+}
+
+// ToGoroutineTreeDOT writes the tree of which goroutine created which in the
+// parsed trace to out as a Graphviz graph, with an edge from each goroutine
+// to those it created. Each goroutine is labeled with its function and the
+// running and blocked time of its subtree, and the more running time its
+// subtree has, the redder it is.
+func ToGoroutineTreeDOT(parsed ParseResult, out io.Writer) error {
+	roots := GoroutineTree(parsed)
+	var total int64
+	for _, r := range roots {
+		total += r.SubtreeRunning
+	}
+	w := bufio.NewWriter(out)
+	fmt.Fprintln(w, "digraph goroutines {")
+	fmt.Fprintln(w, "\tnode [shape=box style=filled fontname=Helvetica];")
+	var write func(n *GoroutineNode)
+	write = func(n *GoroutineNode) {
+		share := 0.0
+		if total > 0 {
+			share = float64(n.SubtreeRunning) / float64(total)
+		}
+		label := fmt.Sprintf("g%d\n%s\nrunning %v, blocked %v", n.ID, n.Func,
+			time.Duration(n.SubtreeRunning).Round(time.Microsecond), time.Duration(n.SubtreeBlocked).Round(time.Microsecond))
+		// white for none of the running time, through to red for all of it
+		fmt.Fprintf(w, "\tg%d [label=%q fillcolor=\"0.000 %.3f 1.000\"];\n", n.ID, label, share)
+		for _, c := range n.Children {
+			fmt.Fprintf(w, "\tg%d -> g%d;\n", n.ID, c.ID)
+			write(c)
+		}
+	}
+	for _, r := range roots {
+		write(r)
+	}
+	fmt.Fprintln(w, "}")
+	return w.Flush()
+}
@@ -0,0 +1,36 @@
+package convert
+
+// TruncatedFrame is the name of the frame TruncateStacks puts at the root
+// of the stacks it truncates.
+const TruncatedFrame = "[truncated]"
+
+// TruncateStacks truncates the parsed trace's stacks which are deeper than
+// depth frames, to bound the size of profiles of deeply recursive code. The
+// depth frames nearest the leaf are kept, and the rest are replaced with a
+// single TruncatedFrame root. The events are updated to refer to the new
+// stacks.
+func TruncateStacks(parsed ParseResult, depth int) {
+	if depth <= 0 {
+		return
+	}
+	for id, stk := range parsed.Stacks {
+		if len(stk) <= depth {
+			continue
+		}
+		frames := make([]*Frame, depth+1)
+		copy(frames, stk[:depth])
+		// the frame the last one kept was inlined into is gone
+		if last := frames[depth-1]; last.Inlined {
+			f := *last
+			f.Inlined = false
+			frames[depth-1] = &f
+		}
+		frames[depth] = &Frame{Fn: TruncatedFrame}
+		parsed.Stacks[id] = frames
+	}
+	for _, ev := range parsed.Events {
+		if ev.StkID != 0 {
+			ev.Stk = parsed.Stacks[ev.StkID]
+		}
+	}
+}
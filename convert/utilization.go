@@ -0,0 +1,124 @@
+package convert
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// UtilizationPoint is how busy the Ps were over one interval of a trace.
+type UtilizationPoint struct {
+	// Start is the start of the interval, in nanoseconds since the start of
+	// the trace
+	Start int64
+	// Utilization is the fraction of the CPU time GOMAXPROCS allowed which
+	// was spent running goroutines
+	Utilization float64
+	// GC is the fraction of the same CPU time spent by the GC's background
+	// mark workers and mark assists
+	GC float64
+}
+
+// Utilization splits the parsed trace into intervals of the given length, in
+// nanoseconds, and returns how busy the Ps were over each, in order. The
+// last interval is cut short by the end of the trace. If the trace doesn't
+// record GOMAXPROCS, the number of Ps which ran goroutines stands in for
+// it.
+func Utilization(parsed ParseResult, interval int64) []UtilizationPoint {
+	if len(parsed.Events) == 0 || interval <= 0 {
+		return nil
+	}
+	base, last := parsed.Events[0].Ts, parsed.Events[len(parsed.Events)-1].Ts
+	n := int((last-base)/interval) + 1
+	running := make([]float64, n)
+	gc := make([]float64, n)
+	capacity := make([]float64, n)
+	// spread adds weight for each nanosecond of [start, end) to the
+	// intervals it overlaps
+	spread := func(into []float64, start, end int64, weight float64) {
+		start, end = start-base, end-base
+		for i := start / interval; i < int64(n) && i*interval < end; i++ {
+			from, to := max(start, i*interval), min(end, (i+1)*interval)
+			into[i] += float64(to-from) * weight
+		}
+	}
+
+	ps := make(map[int]bool)
+	for _, in := range GoroutineIntervals(parsed) {
+		if in.State == StateRunning {
+			spread(running, in.Start, in.End, 1)
+			ps[in.P] = true
+		}
+	}
+	gcWorkers(parsed, func(in Interval, _ string) {
+		spread(gc, in.Start, in.End, 1)
+	})
+	gcAssists(parsed, func(start *Event, end int64) {
+		spread(gc, start.Ts, end, 1)
+	})
+
+	procs, since, seen := 0, base, false
+	for _, ev := range parsed.Events {
+		if ev.Type == EvGomaxprocs {
+			spread(capacity, since, ev.Ts, float64(procs))
+			procs, since, seen = int(ev.Args[0]), ev.Ts, true
+		}
+	}
+	if !seen {
+		procs = max(len(ps), 1)
+	}
+	spread(capacity, since, last, float64(procs))
+
+	points := make([]UtilizationPoint, n)
+	for i := range points {
+		points[i].Start = int64(i) * interval
+		if capacity[i] > 0 {
+			points[i].Utilization = running[i] / capacity[i]
+			points[i].GC = gc[i] / capacity[i]
+		}
+	}
+	return points
+}
+
+// ToPrometheusUtilization writes the Utilization of the parsed trace, which
+// started at the given time, to out in the OpenMetrics text format, the
+// timestamped flavor of the Prometheus exposition format, which
+// "promtool tsdb create-blocks-from openmetrics" loads into Prometheus. There
+// are two gauges, trace_cpu_utilization and trace_gc_cpu_fraction, with a
+// sample per interval timestamped with the interval's start.
+func ToPrometheusUtilization(parsed ParseResult, start time.Time, interval time.Duration, out io.Writer) error {
+	points := Utilization(parsed, int64(interval))
+	w := bufio.NewWriter(out)
+	gauge := func(name, help string, value func(UtilizationPoint) float64) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+		for _, p := range points {
+			// OpenMetrics timestamps are in seconds, which a float64
+			// can't hold to the nanosecond, so they're formatted as
+			// seconds and milliseconds
+			ts := start.Add(time.Duration(p.Start)).UnixMilli()
+			fmt.Fprintf(w, "%s %s %d.%03d\n", name, strconv.FormatFloat(value(p), 'g', -1, 64), ts/1000, ts%1000)
+		}
+	}
+	gauge("trace_cpu_utilization", "Fraction of GOMAXPROCS spent running goroutines.",
+		func(p UtilizationPoint) float64 { return p.Utilization })
+	gauge("trace_gc_cpu_fraction", "Fraction of GOMAXPROCS spent on GC mark work.",
+		func(p UtilizationPoint) float64 { return p.GC })
+	fmt.Fprintln(w, "# EOF")
+	return w.Flush()
+}
+
+// ToInfluxUtilization writes the Utilization of the parsed trace, which
+// started at the given time, to out in the InfluxDB line protocol: a
+// trace_cpu measurement per interval, timestamped in nanoseconds with the
+// interval's start, with utilization and gc fields.
+func ToInfluxUtilization(parsed ParseResult, start time.Time, interval time.Duration, out io.Writer) error {
+	w := bufio.NewWriter(out)
+	for _, p := range Utilization(parsed, int64(interval)) {
+		fmt.Fprintf(w, "trace_cpu utilization=%s,gc=%s %d\n",
+			strconv.FormatFloat(p.Utilization, 'g', -1, 64), strconv.FormatFloat(p.GC, 'g', -1, 64),
+			start.Add(time.Duration(p.Start)).UnixNano())
+	}
+	return w.Flush()
+}
@@ -0,0 +1,28 @@
+package convert
+
+import (
+	"fmt"
+
+	"github.com/google/pprof/profile"
+)
+
+// ValidatePprof decodes a pprof-encoded profile, which may be gzipped, with
+// the pprof package's decoder, and checks that it's well-formed: the first
+// entry of the string table is empty and every string index is in range,
+// the location and function IDs which samples and locations refer to
+// resolve, IDs aren't reused, and each sample has a value for every sample
+// type. Some pprof UIs misrender profiles which break these rules instead
+// of rejecting them.
+func ValidatePprof(data []byte) error {
+	p, err := profile.ParseData(data)
+	if err != nil {
+		return fmt.Errorf("decoding profile: %w", err)
+	}
+	if err := p.CheckValid(); err != nil {
+		return fmt.Errorf("invalid profile: %w", err)
+	}
+	if len(p.Sample) > 0 && p.PeriodType == nil {
+		return fmt.Errorf("invalid profile: missing period type")
+	}
+	return nil
+}
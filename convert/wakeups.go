@@ -0,0 +1,119 @@
+package convert
+
+import (
+	"bufio"
+	"cmp"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"time"
+)
+
+// Wakeup is a kind of wake-up in a trace: one goroutine, or group of
+// goroutines, unblocking another.
+type Wakeup struct {
+	// From and To name the goroutines, or their function when goroutines
+	// are grouped. Wake-ups without a goroutine, such as from the network
+	// poller or timers, are from "[no goroutine]".
+	From, To string
+	Count    int
+	// Latency is the total time in nanoseconds the woken goroutines waited
+	// to run once unblocked
+	Latency int64
+	// Site is the innermost function of the stack the wake-ups happened at
+	// most often, if the trace has stacks for them
+	Site string
+}
+
+// Wakeups finds which goroutines unblocked which in the parsed trace. With
+// byFunc, goroutines are grouped by the function they were started with,
+// which shows the topology of producers and consumers better than each
+// goroutine on its own. The most frequent wake-ups come first.
+func Wakeups(parsed ParseResult, byFunc bool) []Wakeup {
+	funcs := GoroutineFuncs(parsed)
+	name := func(g uint64) string {
+		if g == 0 {
+			return "[no goroutine]"
+		}
+		if byFunc {
+			if fn, ok := funcs[g]; ok {
+				return fn
+			}
+		}
+		return fmt.Sprintf("g%d", g)
+	}
+	type wake struct {
+		g  uint64
+		ts int64
+	}
+	latencies := make(map[wake]int64)
+	for _, in := range GoroutineIntervals(parsed) {
+		if in.State == StateRunnable && in.Reason == EvGoUnblock {
+			latencies[wake{in.G, in.Start}] = in.Duration()
+		}
+	}
+
+	type edgeKey struct{ from, to string }
+	edges := make(map[edgeKey]*Wakeup)
+	sites := make(map[edgeKey]map[string]int)
+	for _, ev := range parsed.Events {
+		if ev.Type != EvGoUnblock {
+			continue
+		}
+		key := edgeKey{name(ev.G), name(ev.Args[0])}
+		e, ok := edges[key]
+		if !ok {
+			e = &Wakeup{From: key.from, To: key.to}
+			edges[key] = e
+			sites[key] = make(map[string]int)
+		}
+		e.Count++
+		e.Latency += latencies[wake{ev.Args[0], ev.Ts}]
+		if stk := parsed.Stacks[ev.StkID]; len(stk) > 0 {
+			sites[key][stk[0].Fn]++
+		}
+	}
+
+	var wakeups []Wakeup
+	for key, e := range edges {
+		best := 0
+		for _, site := range slices.Sorted(maps.Keys(sites[key])) {
+			if n := sites[key][site]; n > best {
+				e.Site, best = site, n
+			}
+		}
+		wakeups = append(wakeups, *e)
+	}
+	slices.SortFunc(wakeups, func(a, b Wakeup) int {
+		return cmp.Or(cmp.Compare(b.Count, a.Count), cmp.Compare(a.From, b.From), cmp.Compare(a.To, b.To))
+	})
+	return wakeups
+}
+
+// ToWakeupDOT writes the Wakeups of the parsed trace to out as a Graphviz
+// graph, with an edge from each goroutine to those it woke up. Edges are
+// labeled with the number of wake-ups, the total latency from being woken
+// to running, and where the wake-ups happened, and the more wake-ups an
+// edge has, the thicker it is.
+func ToWakeupDOT(parsed ParseResult, byFunc bool, out io.Writer) error {
+	wakeups := Wakeups(parsed, byFunc)
+	most := 1
+	for _, e := range wakeups {
+		most = max(most, e.Count)
+	}
+	w := bufio.NewWriter(out)
+	fmt.Fprintln(w, "digraph wakeups {")
+	fmt.Fprintln(w, "\tnode [shape=box fontname=Helvetica];")
+	fmt.Fprintln(w, "\tedge [fontname=Helvetica fontsize=10];")
+	for _, e := range wakeups {
+		label := fmt.Sprintf("%d wake-ups\nlatency %v", e.Count, time.Duration(e.Latency).Round(time.Microsecond))
+		if e.Site != "" {
+			label += "\nat " + e.Site
+		}
+		width := 1 + 7*float64(e.Count)/float64(most)
+		fmt.Fprintf(w, "\t%q -> %q [label=%q penwidth=%.2f];\n", e.From, e.To, label, width)
+	}
+	fmt.Fprintln(w, "}")
+	return w.Flush()
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/nsrip-dd/trace2timeline/convert"
+)
+
+// runConvoys implements the convoys command, which reports where
+// goroutines in an execution trace convoyed on a lock.
+func runConvoys(args []string) error {
+	fs := flag.NewFlagSet("convoys", flag.ExitOnError)
+	input := fs.String("i", "", "execution trace `file`, or - for stdin")
+	output := fs.String("o", "-", "output `file`, or - for stdout")
+	binary := fs.String("binary", "", "binary which produced the trace (required for traces from Go 1.6 and below)")
+	gap := fs.Duration("gap", 100*time.Microsecond, "count blocks at the same stack as one convoy while each starts within this `duration` of the ones before it ending")
+	minGoroutines := fs.Int("min-goroutines", 3, "only count convoys of at least this `many` different goroutines")
+	limit := fs.Int("n", 10, "report the `n` worst stacks, or all of them if 0")
+	asJSON := fs.Bool("json", false, "write a JSON array of the convoys rather than text")
+	addLogFlags(fs)
+	fs.Parse(args)
+
+	if *input == "" && fs.NArg() == 1 {
+		*input = fs.Arg(0)
+	}
+	if *input == "" {
+		fs.Usage()
+		return usageError("-i is required")
+	}
+
+	res, _, err := parseFile(*input, *binary, nil)
+	if err != nil {
+		return err
+	}
+	convoys := convert.LockConvoys(res, int64(*gap), *minGoroutines)
+	if *limit > 0 && len(convoys) > *limit {
+		convoys = convoys[:*limit]
+	}
+
+	out, err := createOutput(*output)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		if convoys == nil {
+			convoys = []convert.LockConvoy{}
+		}
+		err = json.NewEncoder(out).Encode(convoys)
+	} else {
+		var base int64
+		if len(res.Events) > 0 {
+			base = res.Events[0].Ts
+		}
+		err = writeConvoys(out, convoys, base)
+	}
+	if err != nil {
+		discard(out)
+		return err
+	}
+	return out.Close()
+}
+
+// writeConvoys writes the convoys as text, with when the longest at each
+// stack happened relative to base, the start of the trace, and the stack.
+func writeConvoys(out io.Writer, convoys []convert.LockConvoy, base int64) error {
+	w := bufio.NewWriter(out)
+	if len(convoys) == 0 {
+		fmt.Fprintln(w, "No lock convoys")
+		return w.Flush()
+	}
+	for i, c := range convoys {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "%s: %d convoys, %d blocks by %d goroutines, blocked %v\n",
+			c.Reason, c.Episodes, c.Blocks, c.Goroutines, statsDuration(c.Blocked))
+		fmt.Fprintf(w, "  longest from %v to %v\n", statsDuration(c.LongestStart-base), statsDuration(c.LongestEnd-base))
+		fmt.Fprintln(w, "  blocked at:")
+		if len(c.Stack) == 0 {
+			fmt.Fprintln(w, "    (no stack)")
+		}
+		for _, f := range c.Stack {
+			fmt.Fprintf(w, "    %s\n        %s:%d\n", f.Func, f.File, f.Line)
+		}
+	}
+	return w.Flush()
+}
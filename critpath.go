@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/nsrip-dd/trace2timeline/convert"
+)
+
+// runCritPath implements the critpath command, which reports what the
+// critical path of each user task in an execution trace spent its time on.
+func runCritPath(args []string) error {
+	fs := flag.NewFlagSet("critpath", flag.ExitOnError)
+	input := fs.String("i", "", "execution trace `file`, or - for stdin")
+	output := fs.String("o", "-", "output `file`, or - for stdout")
+	binary := fs.String("binary", "", "binary which produced the trace (required for traces from Go 1.6 and below)")
+	task := fs.String("task", "", "only report the user task with this `name or ID`, and its subtasks")
+	steps := fs.Bool("steps", false, "list each step of the critical paths, not just what they add up to")
+	asJSON := fs.Bool("json", false, "write a JSON array of the critical paths, steps included, rather than text")
+	addLogFlags(fs)
+	fs.Parse(args)
+
+	if *input == "" && fs.NArg() == 1 {
+		*input = fs.Arg(0)
+	}
+	if *input == "" {
+		fs.Usage()
+		return usageError("-i is required")
+	}
+
+	res, _, err := parseFile(*input, *binary, nil)
+	if err != nil {
+		return err
+	}
+	var ids []uint64
+	if *task != "" {
+		if ids = convert.TaskIDs(convert.UserAnnotations(res), *task); len(ids) == 0 {
+			return fmt.Errorf("no task %q in %s", *task, displayName(*input))
+		}
+	}
+	paths := convert.CriticalPaths(res, ids)
+
+	out, err := createOutput(*output)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		if paths == nil {
+			paths = []convert.CriticalPath{}
+		}
+		err = json.NewEncoder(out).Encode(paths)
+	} else {
+		err = writeCritPaths(out, paths, *steps)
+	}
+	if err != nil {
+		discard(out)
+		return err
+	}
+	return out.Close()
+}
+
+// writeCritPaths writes each task's critical path as text: what its steps
+// add up to, and the steps themselves if asked for.
+func writeCritPaths(out io.Writer, paths []convert.CriticalPath, steps bool) error {
+	w := bufio.NewWriter(out)
+	if len(paths) == 0 {
+		fmt.Fprintln(w, "No user tasks in the trace")
+		return w.Flush()
+	}
+	for i, p := range paths {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		latency := p.End - p.Start
+		fmt.Fprintf(w, "task %d %q: %v\n", p.Task, p.Name, statsDuration(latency))
+		for _, s := range p.Breakdown {
+			pct := 0.0
+			if latency > 0 {
+				pct = 100 * float64(s.Time) / float64(latency)
+			}
+			fmt.Fprintf(w, "  %10v %6.2f%%  %s\n", statsDuration(s.Time), pct, s.What)
+		}
+		if !steps {
+			continue
+		}
+		fmt.Fprintln(w, "  steps:")
+		for _, s := range p.Steps {
+			fmt.Fprintf(w, "  %10v %10v  g%d %s\n", statsDuration(s.Start-p.Start), statsDuration(s.End-s.Start), s.G, s.What())
+		}
+	}
+	return w.Flush()
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"flag"
+	"log/slog"
+	"os"
+	"runtime/pprof"
+	"runtime/trace"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/pprof/profile"
+	"github.com/nsrip-dd/trace2timeline/convert"
+)
+
+// runDemo traces some busy work in this process and writes the raw trace
+// (trace.out) and a CPU profile collected alongside it (cpu.pprof), along
+// with the trace's JSON (trace.json) and pprof (trace.pprof) conversions, to
+// the current directory. The pprof labels from the CPU profile are joined
+// onto the trace's samples, though since the workers all run the same code
+// under different labels, most of their samples are ambiguous and left
+// without them.
+func runDemo(args []string) error {
+	fs := flag.NewFlagSet("demo", flag.ExitOnError)
+	workers := fs.Int("workers", 4, "number of goroutines doing work")
+	addLogFlags(fs)
+	fs.Parse(args)
+
+	// start this so that we get CPU samples added to the trace
+	// (requires Go >= 1.19), and so there's a profile with the labels
+	// which the trace doesn't have. StartCPUProfile uses the default rate.
+	const cpuProfileRate = 100
+	cpuBuf := new(bytes.Buffer)
+	if err := pprof.StartCPUProfile(cpuBuf); err != nil {
+		return err
+	}
+
+	buf := new(bytes.Buffer)
+	start := time.Now()
+	if err := trace.Start(buf); err != nil {
+		pprof.StopCPUProfile()
+		return err
+	}
+
+	var wg sync.WaitGroup
+	for j := 0; j < *workers; j++ {
+		wg.Add(1)
+		// just do some work
+		thingy := make([]int, 1_000_000)
+		go pprof.Do(context.Background(), pprof.Labels("worker", strconv.Itoa(j)), func(_ context.Context) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				sort.Ints(thingy)
+			}
+		})
+	}
+	wg.Wait()
+
+	trace.Stop()
+	stop := time.Now()
+	pprof.StopCPUProfile()
+
+	if err := os.WriteFile("trace.out", buf.Bytes(), 0660); err != nil {
+		return err
+	}
+	if err := os.WriteFile("cpu.pprof", cpuBuf.Bytes(), 0660); err != nil {
+		return err
+	}
+
+	res, err := convert.Parse(buf, "")
+	if err != nil {
+		return err
+	}
+	cpuProf, err := profile.Parse(cpuBuf)
+	if err != nil {
+		return err
+	}
+	joined := convert.JoinCPUProfileLabels(res, cpuProf)
+	slog.Info("joined the CPU profile's labels", "labeled", joined.Labeled, "ambiguous", joined.Ambiguous)
+
+	jf, err := os.Create("trace.json")
+	if err != nil {
+		return err
+	}
+	defer jf.Close()
+	if err := convert.ToJSON(res, int64(defaultPauseThreshold), jf); err != nil {
+		return err
+	}
+
+	// PPROF version
+
+	f, err := os.Create("trace.pprof")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	if err := convert.ToPprof(res, start, stop, convert.PprofOptions{CPUProfileRate: cpuProfileRate, Mapping: convert.ExecutableMapping()}, gz); err != nil {
+		return err
+	}
+	return gz.Close()
+}
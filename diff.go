@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/google/pprof/profile"
+	"github.com/nsrip-dd/trace2timeline/convert"
+)
+
+// runDiff implements the diff command, which converts two execution traces
+// into the same kind of profile and writes the difference between them, the
+// comparison minus the base, as a pprof profile. Samples which grew are
+// positive and those which shrank are negative, as with pprof's -diff_base
+// option.
+func runDiff(args []string) error {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	base := fs.String("base", "", "execution trace `file` to compare against, or - for stdin")
+	input := fs.String("i", "", "execution trace `file` to compare, or - for stdin")
+	output := fs.String("o", "", "output `file`, or - for stdout, or an s3://, gs:// or azblob:// object URL")
+	binary := fs.String("binary", "", "binary which produced the traces (required for traces from Go 1.6 and below)")
+	kind := fs.String("profile", "cpu", "`kind` of profile to compare: "+strings.Join(slices.Sorted(maps.Keys(convert.Profiles)), ", "))
+	opts := convert.PprofOptions{Compat: true}
+	fs.IntVar(&opts.CPUProfileRate, "cpu-rate", 100, "CPU profiling `rate` in Hz the traced programs used, set with runtime.SetCPUProfileRate")
+	addLogFlags(fs)
+	fs.Parse(args)
+
+	if *base == "" || *input == "" || *output == "" {
+		fs.Usage()
+		return usageError("-base, -i and -o are all required")
+	}
+	if *base == "-" && *input == "-" {
+		return usageError("stdin can only be read once")
+	}
+	write, ok := convert.Profiles[*kind]
+	if !ok {
+		return usageError(fmt.Sprintf("unknown profile %q", *kind))
+	}
+
+	// The per-sample breakdowns don't mean anything once samples are
+	// subtracted, so the profiles are converted without the extensions,
+	// which the pprof library doesn't know about anyway.
+	toProfile := func(name string) (*profile.Profile, error) {
+		var keep func(*convert.Event) bool
+		if *kind == "cpu" {
+			keep = convert.CPUEvents
+		}
+		res, start, err := parseFile(name, *binary, keep)
+		if err != nil {
+			return nil, err
+		}
+		buf := new(bytes.Buffer)
+		if err := write(res, start, start.Add(convert.Duration(res)), opts, buf); err != nil {
+			return nil, err
+		}
+		prof, err := profile.Parse(buf)
+		if err != nil {
+			return nil, err
+		}
+		// Goroutine IDs differ from one run to the next, so they'd keep
+		// the same samples from the two traces from cancelling out.
+		for _, s := range prof.Sample {
+			delete(s.NumLabel, "goroutine_id")
+			delete(s.NumUnit, "goroutine_id")
+		}
+		return prof, nil
+	}
+	baseProf, err := toProfile(*base)
+	if err != nil {
+		return err
+	}
+	cmpProf, err := toProfile(*input)
+	if err != nil {
+		return err
+	}
+	baseProf.Scale(-1)
+	diff, err := profile.Merge([]*profile.Profile{cmpProf, baseProf})
+	if err != nil {
+		return fmt.Errorf("comparing %s to %s: %w", *input, *base, err)
+	}
+	// Drop the samples which didn't change at all
+	diff.Sample = slices.DeleteFunc(diff.Sample, func(s *profile.Sample) bool {
+		return !slices.ContainsFunc(s.Value, func(v int64) bool { return v != 0 })
+	})
+	diff = diff.Compact()
+
+	out, err := createOutput(*output)
+	if err != nil {
+		return err
+	}
+	if err := diff.Write(out); err != nil {
+		discard(out)
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,196 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/nsrip-dd/trace2timeline/convert"
+)
+
+// ClickHouseSchema is the statement which creates the table ClickHouseSink
+// inserts into, with %s for the table's name. Each row is an event from a
+// trace, CPU samples included:
+//
+//   - trace_start: when the trace the event is from started, to tell the
+//     traces apart
+//   - timestamp: the wall clock time of the event
+//   - service: ClickHouseSink.Service
+//   - type: the event type, as in convert.EventDescriptions
+//   - goroutine, p, thread: where the event happened, with p and thread
+//     NULL if it wasn't on a P or the thread isn't known
+//   - leaf_function: the innermost function of the event's stack
+//   - stack_hash: the stack's convert.StackHash, to group events by stack
+//     across traces
+//   - stack: the functions of the stack, innermost first
+const ClickHouseSchema = `CREATE TABLE IF NOT EXISTS %s (
+	trace_start DateTime64(9, 'UTC'),
+	timestamp DateTime64(9, 'UTC'),
+	service LowCardinality(String),
+	type LowCardinality(String),
+	goroutine UInt64,
+	p Nullable(Int32),
+	thread Nullable(Int64),
+	leaf_function String,
+	stack_hash String,
+	stack Array(String)
+) ENGINE = MergeTree
+ORDER BY (service, type, timestamp)`
+
+// ClickHouseSink inserts the events of each batch's trace into a ClickHouse
+// table, through ClickHouse's HTTP interface, as rows of the table
+// ClickHouseSchema creates. The batch's pprof profiles aren't used. The
+// native TCP protocol isn't supported: use the HTTP port, 8123 by default,
+// rather than 9000.
+type ClickHouseSink struct {
+	// URL is the base URL of the HTTP interface, e.g.
+	// http://localhost:8123.
+	URL string
+	// Database is the database the table is in. If empty, it's the
+	// user's default database.
+	Database string
+	// Table is the name of the table.
+	Table string
+	// CreateTable creates the table, with ClickHouseSchema, if it doesn't
+	// exist yet.
+	CreateTable bool
+	// User and Password authenticate the requests.
+	User     string
+	Password string
+	// Service is the value of the service column.
+	Service string
+	// Client is used to send requests. If nil, http.DefaultClient is used.
+	Client *http.Client
+	// Retry says how to retry failed requests.
+	Retry RetryPolicy
+
+	created atomic.Bool
+}
+
+// clickHouseRow is a row of the table, in ClickHouse's JSONEachRow format
+type clickHouseRow struct {
+	TraceStart   string   `json:"trace_start"`
+	Timestamp    string   `json:"timestamp"`
+	Service      string   `json:"service"`
+	Type         string   `json:"type"`
+	Goroutine    uint64   `json:"goroutine"`
+	P            *int     `json:"p"`
+	Thread       *int64   `json:"thread"`
+	LeafFunction string   `json:"leaf_function"`
+	StackHash    string   `json:"stack_hash"`
+	Stack        []string `json:"stack"`
+}
+
+// clickHouseTime is the format of DateTime64(9) values
+const clickHouseTime = "2006-01-02 15:04:05.000000000"
+
+func (s *ClickHouseSink) Send(ctx context.Context, b *Batch) error {
+	return s.SendBatches(ctx, []*Batch{b})
+}
+
+// SendBatches inserts the events of several batches in one request.
+func (s *ClickHouseSink) SendBatches(ctx context.Context, batches []*Batch) error {
+	if s.CreateTable && !s.created.Load() {
+		if err := s.query(ctx, fmt.Sprintf(ClickHouseSchema, s.Table), nil); err != nil {
+			return fmt.Errorf("creating ClickHouse table %s: %w", s.Table, err)
+		}
+		s.created.Store(true)
+	}
+	body := new(bytes.Buffer)
+	enc := json.NewEncoder(body)
+	for _, b := range batches {
+		res := b.Trace
+		var base int64
+		if len(res.Events) > 0 {
+			base = res.Events[0].Ts
+		}
+		hashes := make(map[uint64]string)
+		for _, ev := range res.Events {
+			row := clickHouseRow{
+				TraceStart: b.Start.UTC().Format(clickHouseTime),
+				Timestamp:  b.Start.Add(time.Duration(ev.Ts - base)).UTC().Format(clickHouseTime),
+				Service:    s.Service,
+				Type:       convert.EventDescriptions[ev.Type].Name,
+				Goroutine:  ev.G,
+				Stack:      []string{},
+			}
+			if ev.P >= 0 && ev.P < convert.FakeP {
+				row.P = &ev.P
+			}
+			if ev.M >= 0 {
+				row.Thread = &ev.M
+			}
+			if stk := res.Stacks[ev.StkID]; len(stk) > 0 {
+				h, ok := hashes[ev.StkID]
+				if !ok {
+					h = convert.StackHash(stk)
+					hashes[ev.StkID] = h
+				}
+				row.LeafFunction, row.StackHash = stk[0].Fn, h
+				for _, f := range stk {
+					row.Stack = append(row.Stack, f.Fn)
+				}
+			}
+			if err := enc.Encode(row); err != nil {
+				return err
+			}
+		}
+	}
+	if body.Len() == 0 {
+		return nil
+	}
+	if err := s.query(ctx, "INSERT INTO "+s.Table+" FORMAT JSONEachRow", body.Bytes()); err != nil {
+		return fmt.Errorf("inserting events into ClickHouse: %w", err)
+	}
+	return nil
+}
+
+// query runs the query, with data as the input of INSERT queries.
+func (s *ClickHouseSink) query(ctx context.Context, query string, data []byte) error {
+	q := url.Values{}
+	q.Set("query", query)
+	if s.Database != "" {
+		q.Set("database", s.Database)
+	}
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return s.Retry.do(ctx, func() error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(s.URL, "/")+"/?"+q.Encode(), bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		if s.User != "" {
+			req.Header.Set("X-ClickHouse-User", s.User)
+		}
+		if s.Password != "" {
+			req.Header.Set("X-ClickHouse-Key", s.Password)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			if ctx.Err() != nil {
+				return err
+			}
+			return &retryableError{err: err}
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+			err := fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(msg))
+			if retryableStatus(resp.StatusCode) {
+				return &retryableError{err: err, after: retryAfter(resp)}
+			}
+			return err
+		}
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	})
+}
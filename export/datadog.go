@@ -0,0 +1,140 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"slices"
+	"strings"
+	"time"
+)
+
+// DatadogSink uploads the profiles in each batch to Datadog, in the
+// profile intake format the Go profiler uses: a multipart form with an
+// event describing the profiles, and the profiles as attachments named like
+// cpu.pprof. Their Breakdown extensions are what the timeline view shows.
+//
+// The profiles are sent either through a Datadog Agent, or straight to the
+// intake with an API key.
+type DatadogSink struct {
+	// URL is where to send the profiles. If empty, it's the Agent's
+	// endpoint on localhost, http://localhost:8126/profiling/v1/input, or
+	// the intake for Site if APIKey is set.
+	URL string
+	// APIKey authenticates requests sent straight to the intake.
+	APIKey string
+	// Site is the Datadog site, datadoghq.com by default.
+	Site string
+	// Service, Env and Version are the unified service tags.
+	Service string
+	Env     string
+	Version string
+	// Tags are added to the profiles, e.g. team=foo.
+	Tags map[string]string
+	// Client is used to send requests. If nil, http.DefaultClient is used.
+	Client *http.Client
+}
+
+// datadogEvent is the event part of a profile upload
+type datadogEvent struct {
+	Attachments []string `json:"attachments"`
+	Tags        string   `json:"tags_profiler"`
+	Start       string   `json:"start"`
+	End         string   `json:"end"`
+	Family      string   `json:"family"`
+	Version     string   `json:"version"`
+}
+
+func (s *DatadogSink) Send(ctx context.Context, b *Batch) error {
+	event := datadogEvent{
+		Tags:    strings.Join(s.tags(), ","),
+		Start:   b.Start.UTC().Format(time.RFC3339Nano),
+		End:     b.End.UTC().Format(time.RFC3339Nano),
+		Family:  "go",
+		Version: "4",
+	}
+	for _, p := range b.Profiles {
+		event.Attachments = append(event.Attachments, p.Kind+".pprof")
+	}
+	body := new(bytes.Buffer)
+	mw := multipart.NewWriter(body)
+	h := make(textproto.MIMEHeader)
+	h.Set("Content-Disposition", `form-data; name="event"; filename="event.json"`)
+	h.Set("Content-Type", "application/json")
+	ew, err := mw.CreatePart(h)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(ew).Encode(event); err != nil {
+		return err
+	}
+	for i, p := range b.Profiles {
+		fw, err := mw.CreateFormFile(event.Attachments[i], event.Attachments[i])
+		if err != nil {
+			return err
+		}
+		fw.Write(p.Data)
+	}
+	if err := mw.Close(); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url(), body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.Header.Set("DD-EVP-ORIGIN", "trace2timeline")
+	if s.APIKey != "" {
+		req.Header.Set("DD-API-KEY", s.APIKey)
+	}
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("uploading profiles to Datadog failed: %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+func (s *DatadogSink) url() string {
+	switch {
+	case s.URL != "":
+		return s.URL
+	case s.APIKey == "":
+		return "http://localhost:8126/profiling/v1/input"
+	}
+	site := s.Site
+	if site == "" {
+		site = "datadoghq.com"
+	}
+	return "https://intake.profile." + site + "/api/v2/profile"
+}
+
+// tags returns the profile's tags in key:value form.
+func (s *DatadogSink) tags() []string {
+	var tags []string
+	for _, t := range []struct{ k, v string }{{"service", s.Service}, {"env", s.Env}, {"version", s.Version}} {
+		if t.v != "" {
+			tags = append(tags, t.k+":"+t.v)
+		}
+	}
+	for _, k := range slices.Sorted(maps.Keys(s.Tags)) {
+		tags = append(tags, k+":"+s.Tags[k])
+	}
+	return append(tags, "profiler_source:trace2timeline")
+}
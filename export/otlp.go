@@ -0,0 +1,98 @@
+// Package export sends converted profiles to external services.
+package export
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Paths of the OTLP/HTTP endpoints, relative to the collector's base URL.
+const (
+	OTLPProfilesPath = "/v1development/profiles"
+	OTLPTracesPath   = "/v1/traces"
+)
+
+// OTLPExporter pushes profiles and spans to an OpenTelemetry collector over
+// OTLP/HTTP using the binary protobuf encoding.
+type OTLPExporter struct {
+	// Endpoint is the base URL of the collector, e.g.
+	// http://localhost:4318. The path for each signal, like
+	// OTLPProfilesPath, is appended to it unless it already ends with it.
+	Endpoint string
+	// Headers are added to each request, e.g. for authentication.
+	Headers map[string]string
+	// Client is used to send requests. If nil, http.DefaultClient is used.
+	Client *http.Client
+	// Gzip compresses the requests.
+	Gzip bool
+	// Retry says how to retry failed requests.
+	Retry RetryPolicy
+}
+
+// ExportProfiles sends an encoded ExportProfilesServiceRequest, such as the
+// output of convert.ToOTLP, to the collector.
+func (e *OTLPExporter) ExportProfiles(ctx context.Context, request []byte) error {
+	return e.post(ctx, OTLPProfilesPath, request)
+}
+
+// ExportTraces sends an encoded ExportTraceServiceRequest, such as the output
+// of convert.ToOTLPSpans, to the collector.
+func (e *OTLPExporter) ExportTraces(ctx context.Context, request []byte) error {
+	return e.post(ctx, OTLPTracesPath, request)
+}
+
+func (e *OTLPExporter) post(ctx context.Context, path string, request []byte) error {
+	url := e.Endpoint
+	if !strings.HasSuffix(url, path) {
+		url = strings.TrimSuffix(url, "/") + path
+	}
+	if e.Gzip {
+		buf := new(bytes.Buffer)
+		gz := gzip.NewWriter(buf)
+		gz.Write(request)
+		if err := gz.Close(); err != nil {
+			return err
+		}
+		request = buf.Bytes()
+	}
+	client := e.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return e.Retry.do(ctx, func() error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(request))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/x-protobuf")
+		if e.Gzip {
+			req.Header.Set("Content-Encoding", "gzip")
+		}
+		for k, v := range e.Headers {
+			req.Header.Set(k, v)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			if ctx.Err() != nil {
+				return err
+			}
+			return &retryableError{err: err}
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+			err := fmt.Errorf("OTLP export to %s failed: %s: %s", url, resp.Status, bytes.TrimSpace(body))
+			if retryableStatus(resp.StatusCode) {
+				return &retryableError{err: err, after: retryAfter(resp)}
+			}
+			return err
+		}
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	})
+}
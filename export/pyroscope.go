@@ -0,0 +1,110 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// PyroscopeSink uploads the profiles in each batch to a Pyroscope or Grafana
+// Pyroscope server, through its /ingest API. Each kind of profile is
+// uploaded as its own application, named like AppName.cpu.
+type PyroscopeSink struct {
+	// ServerURL is the base URL of the server, e.g. http://localhost:4040.
+	ServerURL string
+	// AppName is the application the profiles belong to.
+	AppName string
+	// Tags are added to each profile, e.g. env=prod.
+	Tags map[string]string
+	// BasicAuthUser and BasicAuthPassword, if set, authenticate the
+	// requests, as Grafana Cloud requires.
+	BasicAuthUser     string
+	BasicAuthPassword string
+	// TenantID, if set, is sent as the X-Scope-OrgID header, for
+	// multi-tenant servers.
+	TenantID string
+	// Client is used to send requests. If nil, http.DefaultClient is used.
+	Client *http.Client
+}
+
+func (s *PyroscopeSink) Send(ctx context.Context, b *Batch) error {
+	for _, p := range b.Profiles {
+		if err := s.upload(ctx, b, p); err != nil {
+			return fmt.Errorf("uploading %s profile to Pyroscope: %w", p.Kind, err)
+		}
+	}
+	return nil
+}
+
+func (s *PyroscopeSink) upload(ctx context.Context, b *Batch, p Profile) error {
+	body := new(bytes.Buffer)
+	mw := multipart.NewWriter(body)
+	fw, err := mw.CreateFormFile("profile", "profile.pprof")
+	if err != nil {
+		return err
+	}
+	fw.Write(p.Data)
+	if err := mw.Close(); err != nil {
+		return err
+	}
+
+	q := url.Values{}
+	q.Set("name", s.name(p.Kind))
+	q.Set("from", strconv.FormatInt(b.Start.Unix(), 10))
+	q.Set("until", strconv.FormatInt(b.End.Unix(), 10))
+	q.Set("format", "pprof")
+	q.Set("spyName", "trace2timeline")
+	u := strings.TrimSuffix(s.ServerURL, "/") + "/ingest?" + q.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	if s.BasicAuthUser != "" || s.BasicAuthPassword != "" {
+		req.SetBasicAuth(s.BasicAuthUser, s.BasicAuthPassword)
+	}
+	if s.TenantID != "" {
+		req.Header.Set("X-Scope-OrgID", s.TenantID)
+	}
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+// name returns the application name for a kind of profile, with the tags,
+// e.g. myapp.cpu{env=prod,region=us}.
+func (s *PyroscopeSink) name(kind string) string {
+	var sb strings.Builder
+	sb.WriteString(s.AppName)
+	sb.WriteString(".")
+	sb.WriteString(kind)
+	sb.WriteString("{")
+	for i, k := range slices.Sorted(maps.Keys(s.Tags)) {
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		sb.WriteString(k + "=" + s.Tags[k])
+	}
+	sb.WriteString("}")
+	return sb.String()
+}
@@ -0,0 +1,89 @@
+package export
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy says how to retry requests which failed in a way that might
+// not happen again, like network errors and overloaded servers. The zero
+// RetryPolicy doesn't retry.
+type RetryPolicy struct {
+	// MaxAttempts is the most times to try a request. Zero or one means
+	// just once.
+	MaxAttempts int
+	// InitialBackoff is how long to wait before the first retry, 1s if
+	// zero. The wait doubles after each retry, up to MaxBackoff, 30s if
+	// zero. A Retry-After header from the server takes precedence.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// retryableError is a failure worth retrying
+type retryableError struct {
+	err error
+	// after is how long the server asked to wait, if it did
+	after time.Duration
+}
+
+func (e *retryableError) Error() string { return e.err.Error() }
+func (e *retryableError) Unwrap() error { return e.err }
+
+// retryableStatus reports whether a request which got the HTTP status code
+// might succeed if tried again.
+func retryableStatus(code int) bool {
+	switch code {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// retryAfter returns the wait the response's Retry-After header asks for,
+// in the delay-seconds form.
+func retryAfter(resp *http.Response) time.Duration {
+	secs, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || secs < 0 {
+		return 0
+	}
+	return time.Duration(secs) * time.Second
+}
+
+// do calls try until it succeeds, fails with an error which isn't a
+// retryableError, or the attempts run out, and returns its last error.
+func (p RetryPolicy) do(ctx context.Context, try func() error) error {
+	backoff := p.InitialBackoff
+	if backoff <= 0 {
+		backoff = time.Second
+	}
+	maxBackoff := p.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = 30 * time.Second
+	}
+	for attempt := 1; ; attempt++ {
+		err := try()
+		var re *retryableError
+		if !errors.As(err, &re) {
+			return err
+		}
+		if attempt >= p.MaxAttempts {
+			return re.err
+		}
+		wait := re.after
+		if wait == 0 {
+			// With jitter, so that clients which failed together
+			// don't all retry together
+			wait = backoff/2 + rand.N(backoff/2+1)
+		}
+		select {
+		case <-time.After(min(wait, maxBackoff)):
+		case <-ctx.Done():
+			return re.err
+		}
+		backoff = min(2*backoff, maxBackoff)
+	}
+}
@@ -0,0 +1,139 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/nsrip-dd/trace2timeline/convert"
+)
+
+// Batch is what's sent to a Sink for each captured trace: the trace itself,
+// and the pprof profiles converted from it.
+type Batch struct {
+	// Start and End are the wall clock times the trace covers.
+	Start, End time.Time
+	// Trace is the parsed trace.
+	Trace convert.ParseResult
+	// Profiles are the gzipped pprof profiles converted from the trace.
+	Profiles []Profile
+}
+
+// Profile is a gzipped pprof profile converted from a trace.
+type Profile struct {
+	// Kind is the kind of profile, one of the keys of convert.Profiles.
+	Kind string
+	Data []byte
+}
+
+// Sink is somewhere to send converted traces.
+type Sink interface {
+	Send(ctx context.Context, b *Batch) error
+}
+
+// DirSink writes the profiles in each batch to files in a directory, named
+// after their kind and the time the trace started, e.g.
+// cpu_20060102T150405Z.pprof.
+type DirSink struct {
+	Dir string
+}
+
+func (s *DirSink) Send(ctx context.Context, b *Batch) error {
+	if err := os.MkdirAll(s.Dir, 0o755); err != nil {
+		return err
+	}
+	stamp := b.Start.UTC().Format("20060102T150405Z")
+	for _, p := range b.Profiles {
+		name := filepath.Join(s.Dir, fmt.Sprintf("%s_%s.pprof", p.Kind, stamp))
+		if err := os.WriteFile(name, p.Data, 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// OTLPSink sends the CPU samples of each batch's trace to an OpenTelemetry
+// collector, converted with convert.ToOTLP. The batch's pprof profiles
+// aren't used.
+type OTLPSink struct {
+	Exporter *OTLPExporter
+	// Resource are the resource attributes describing the traced
+	// process, e.g. service.name.
+	Resource map[string]string
+}
+
+func (s *OTLPSink) Send(ctx context.Context, b *Batch) error {
+	return s.SendBatches(ctx, []*Batch{b})
+}
+
+// SendBatches sends several batches in one request. Their traces are
+// combined with convert.Merge, so the goroutine IDs from all but the first
+// are offset.
+func (s *OTLPSink) SendBatches(ctx context.Context, batches []*Batch) error {
+	if len(batches) == 0 {
+		return nil
+	}
+	res, start, end := batches[0].Trace, batches[0].Start, batches[0].End
+	if len(batches) > 1 {
+		var traces []convert.ParseResult
+		var starts []time.Time
+		for _, b := range batches {
+			traces = append(traces, b.Trace)
+			starts = append(starts, b.Start)
+			if b.End.After(end) {
+				end = b.End
+			}
+		}
+		res, start = convert.Merge(traces, starts)
+	}
+	buf := new(bytes.Buffer)
+	if err := convert.ToOTLP(res, start, end, s.Resource, buf); err != nil {
+		return err
+	}
+	return s.Exporter.ExportProfiles(ctx, buf.Bytes())
+}
+
+// MultiSink is a Sink which can send several batches at once.
+type MultiSink interface {
+	Sink
+	SendBatches(ctx context.Context, batches []*Batch) error
+}
+
+// BufferedSink collects batches, and sends them to the underlying sink Size
+// at a time, to make fewer, larger requests. Batches which fail to send are
+// dropped rather than kept around to try again, so that an unreachable
+// destination doesn't use up ever more memory.
+type BufferedSink struct {
+	Sink MultiSink
+	Size int
+
+	mu      sync.Mutex
+	pending []*Batch
+}
+
+func (s *BufferedSink) Send(ctx context.Context, b *Batch) error {
+	s.mu.Lock()
+	s.pending = append(s.pending, b)
+	full := len(s.pending) >= s.Size
+	s.mu.Unlock()
+	if !full {
+		return nil
+	}
+	return s.Flush(ctx)
+}
+
+// Flush sends the batches collected so far.
+func (s *BufferedSink) Flush(ctx context.Context) error {
+	s.mu.Lock()
+	pending := s.pending
+	s.pending = nil
+	s.mu.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+	return s.Sink.SendBatches(ctx, pending)
+}
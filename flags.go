@@ -0,0 +1,161 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nsrip-dd/trace2timeline/convert"
+)
+
+// keyValueFlag collects repeated key=value flags, e.g. -header K=V.
+type keyValueFlag map[string]string
+
+func (f keyValueFlag) String() string {
+	var parts []string
+	for k, v := range f {
+		parts = append(parts, k+"="+v)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (f keyValueFlag) Set(s string) error {
+	k, v, ok := strings.Cut(s, "=")
+	if !ok {
+		return fmt.Errorf("%q is not of the form key=value", s)
+	}
+	f[k] = v
+	return nil
+}
+
+// timeFlag is a point in a trace, given either as an offset from the start
+// of the trace, like 1m30s, or as an absolute RFC 3339 wall clock time.
+type timeFlag struct {
+	offset time.Duration
+	wall   time.Time
+}
+
+func (f *timeFlag) String() string {
+	if !f.wall.IsZero() {
+		return f.wall.Format(time.RFC3339Nano)
+	}
+	if f.offset == 0 {
+		return ""
+	}
+	return f.offset.String()
+}
+
+func (f *timeFlag) Set(s string) error {
+	if d, err := time.ParseDuration(s); err == nil {
+		f.offset, f.wall = d, time.Time{}
+		return nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return fmt.Errorf("%q is neither a duration nor an RFC 3339 time", s)
+	}
+	f.offset, f.wall = 0, t
+	return nil
+}
+
+// resolve returns the flag's time as an offset from the start of a trace
+// which started at the given wall clock time.
+func (f *timeFlag) resolve(traceStart time.Time) time.Duration {
+	if f.wall.IsZero() {
+		return f.offset
+	}
+	return max(f.wall.Sub(traceStart), 0)
+}
+
+// idListFlag collects comma-separated lists of IDs, e.g. -goroutines 1,2,3.
+// The flag can also be repeated.
+type idListFlag []uint64
+
+func (f *idListFlag) String() string {
+	var parts []string
+	for _, id := range *f {
+		parts = append(parts, strconv.FormatUint(id, 10))
+	}
+	return strings.Join(parts, ",")
+}
+
+func (f *idListFlag) Set(s string) error {
+	for _, part := range strings.Split(s, ",") {
+		id, err := strconv.ParseUint(strings.TrimSpace(part), 10, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a list of IDs", s)
+		}
+		*f = append(*f, id)
+	}
+	return nil
+}
+
+// stringsFlag collects the values of a repeated flag, e.g. -i a -i b.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(s string) error {
+	*f = append(*f, s)
+	return nil
+}
+
+// compressionFlag is an output compression, like gzip:9.
+type compressionFlag struct {
+	c *convert.Compression
+}
+
+func (f compressionFlag) String() string {
+	if f.c == nil || f.c.Format == "" {
+		return ""
+	}
+	return f.c.String()
+}
+
+func (f compressionFlag) Set(s string) error {
+	c, err := convert.ParseCompression(s)
+	if err != nil {
+		return err
+	}
+	*f.c = c
+	return nil
+}
+
+// addCompatFlags adds -compat, and -no-breakdown, which does the same, to
+// fs. Both leave the Breakdown, LabelSet and tick unit extensions out of
+// pprof profiles, and keep the rest of the profile as it is.
+func addCompatFlags(fs *flag.FlagSet, opts *convert.PprofOptions) {
+	fs.BoolVar(&opts.Compat, "compat", false, "write pprof profiles without the Breakdown and LabelSet extensions")
+	fs.BoolVar(&opts.Compat, "no-breakdown", false, "same as -compat: leave out the Breakdown, LabelSet and tick unit extensions, for smaller profiles")
+}
+
+// addFrameFlags adds the -drop-frames and -keep-frames flags, which set the
+// drop_frames and keep_frames fields of pprof profiles, to fs.
+func addFrameFlags(fs *flag.FlagSet, opts *convert.PprofOptions) {
+	regexpFunc := func(s *string) func(string) error {
+		return func(v string) error {
+			if _, err := regexp.Compile(v); err != nil {
+				return err
+			}
+			*s = v
+			return nil
+		}
+	}
+	fs.Func("drop-frames", "`regexp` of functions for pprof to hide, with the frames they call, like runtime\\.mallocgc", regexpFunc(&opts.DropFrames))
+	fs.Func("keep-frames", "`regexp` of functions matching -drop-frames which pprof should still show", regexpFunc(&opts.KeepFrames))
+}
+
+// addNameFlags adds the flags which simplify function names to fs.
+func addNameFlags(fs *flag.FlagSet, opts *convert.NameOptions) {
+	fs.Func("trim-prefix", "import path `prefix` to remove from function names, like github.com/org/ (repeatable)", func(s string) error {
+		opts.TrimPrefixes = append(opts.TrimPrefixes, s)
+		return nil
+	})
+	fs.BoolVar(&opts.ElideTypeParams, "elide-type-params", false, "replace the type arguments of generic functions with [...]")
+	fs.BoolVar(&opts.ShortReceivers, "short-receivers", false, "write method receivers like (*T) as T")
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/nsrip-dd/trace2timeline/convert"
+)
+
+// runGC implements the gc command, which summarizes the garbage collector's
+// activity in an execution trace, as text or as JSON.
+func runGC(args []string) error {
+	fs := flag.NewFlagSet("gc", flag.ExitOnError)
+	input := fs.String("i", "", "execution trace `file`, or - for stdin")
+	output := fs.String("o", "-", "output `file`, or - for stdout")
+	binary := fs.String("binary", "", "binary which produced the trace (required for traces from Go 1.6 and below)")
+	asJSON := fs.Bool("json", false, "write the report as JSON rather than text")
+	addLogFlags(fs)
+	fs.Parse(args)
+
+	if *input == "" && fs.NArg() == 1 {
+		*input = fs.Arg(0)
+	}
+	if *input == "" {
+		fs.Usage()
+		return usageError("-i is required")
+	}
+
+	res, _, err := parseFile(*input, *binary, nil)
+	if err != nil {
+		return err
+	}
+	report := convert.NewGCReport(res)
+
+	out, err := createOutput(*output)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		err = json.NewEncoder(out).Encode(report)
+	} else {
+		err = writeGCReport(out, report)
+	}
+	if err != nil {
+		discard(out)
+		return err
+	}
+	return out.Close()
+}
+
+// writeGCReport writes the report as text: the totals, then a table of the
+// cycles and one of the goroutines which did mark assists.
+func writeGCReport(out io.Writer, r convert.GCReport) error {
+	tw := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	complete := 0
+	for _, c := range r.Cycles {
+		if !c.Incomplete {
+			complete++
+		}
+	}
+	var assists int
+	var assistTime int64
+	for _, a := range r.Assists {
+		assists += a.Count
+		assistTime += a.Time
+	}
+	fmt.Fprintf(tw, "Trace:\t%v\n", statsDuration(r.Duration))
+	fmt.Fprintf(tw, "GC cycles:\t%d (%d complete)\n", len(r.Cycles), complete)
+	fmt.Fprintf(tw, "STW pauses:\t%d, %v total\n", r.STWPauses, statsDuration(r.STW))
+	fmt.Fprintf(tw, "Mark assists:\t%d by %d goroutines, %v total\n", assists, len(r.Assists), statsDuration(assistTime))
+	fmt.Fprintf(tw, "Mark workers:\t%v", statsDuration(r.Workers))
+	if r.WorkerFraction > 0 {
+		fmt.Fprintf(tw, ", %.2f%% of the CPU time GOMAXPROCS allowed", 100*r.WorkerFraction)
+	}
+	fmt.Fprintln(tw)
+
+	if len(r.Cycles) > 0 {
+		fmt.Fprintln(tw, "\nCYCLE\tSTART\tDURATION\tSTW\tHEAP START\tHEAP END\tHEAP GOAL")
+		for i, c := range r.Cycles {
+			duration := statsDuration(c.End - c.Start).String()
+			if c.Incomplete {
+				duration += " (incomplete)"
+			}
+			fmt.Fprintf(tw, "%d\t%v\t%s\t%v\t%s\t%s\t%s\n", i+1, statsDuration(c.Start), duration,
+				statsDuration(c.STW), formatBytes(c.HeapStart), formatBytes(c.HeapEnd), formatBytes(c.HeapGoal))
+		}
+	}
+	if len(r.Assists) > 0 {
+		fmt.Fprintln(tw, "\nGOROUTINE\tASSISTS\tTIME\tFUNC")
+		for _, a := range r.Assists {
+			fmt.Fprintf(tw, "%d\t%d\t%v\t%s\n", a.G, a.Count, statsDuration(a.Time), a.Func)
+		}
+	}
+	return tw.Flush()
+}
+
+// formatBytes formats a size in bytes in the largest binary unit it's at
+// least one of, or "-" for 0, which means the size isn't known.
+func formatBytes(n uint64) string {
+	if n == 0 {
+		return "-"
+	}
+	const units = "KMGTPE"
+	if n < 1024 {
+		return fmt.Sprintf("%d B", n)
+	}
+	f, i := float64(n)/1024, 0
+	for f >= 1024 && i < len(units)-1 {
+		f /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.1f %ciB", f, units[i])
+}
@@ -0,0 +1,129 @@
+// Package handler serves profiles of the running process derived from its
+// execution trace, like net/http/pprof serves its profiles. Importing it
+// registers Profile with http.DefaultServeMux:
+//
+//	import _ "github.com/nsrip-dd/trace2timeline/handler"
+//
+// Then, for a CPU profile with timestamped samples covering the next 10
+// seconds:
+//
+//	curl -o cpu.pprof 'http://localhost:6060/debug/trace2timeline/profile?seconds=10'
+package handler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime/pprof"
+	"runtime/trace"
+	"strconv"
+	"time"
+
+	"github.com/nsrip-dd/trace2timeline/convert"
+)
+
+func init() {
+	http.HandleFunc("/debug/trace2timeline/profile", Profile)
+}
+
+// cpuProfileRate is the rate pprof.StartCPUProfile profiles at
+const cpuProfileRate = 100
+
+// Profile traces the process for the number of seconds given by the seconds
+// query parameter, 5 by default, and responds with a gzipped pprof profile
+// converted from the trace. The profile query parameter selects the kind of
+// profile, one of the keys of convert.Profiles, and is "cpu" by default.
+// With compat=true, the profile leaves out the Breakdown and LabelSet
+// extensions.
+//
+// Traces only have CPU samples while the CPU profiler is running, so Profile
+// runs it while tracing unless something else already is, in which case
+// that profile's rate is assumed to be the default 100 Hz. Like the
+// net/http/pprof profiles, the number of seconds must be less than the
+// server's WriteTimeout, if it has one.
+func Profile(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	q := r.URL.Query()
+	seconds := 5.0
+	if v := q.Get("seconds"); v != "" {
+		var err error
+		seconds, err = strconv.ParseFloat(v, 64)
+		if err != nil || seconds <= 0 {
+			serveError(w, http.StatusBadRequest, "invalid seconds")
+			return
+		}
+	}
+	// the response would be cut off by the server's write deadline, as
+	// net/http/pprof checks too
+	if srv, ok := r.Context().Value(http.ServerContextKey).(*http.Server); ok && srv.WriteTimeout > 0 && seconds >= srv.WriteTimeout.Seconds() {
+		serveError(w, http.StatusBadRequest, "profile duration exceeds server's WriteTimeout")
+		return
+	}
+	kind := q.Get("profile")
+	if kind == "" {
+		kind = "cpu"
+	}
+	if _, ok := convert.Profiles[kind]; !ok {
+		serveError(w, http.StatusBadRequest, fmt.Sprintf("unknown profile %q", kind))
+		return
+	}
+	compat, _ := strconv.ParseBool(q.Get("compat"))
+
+	buf := new(bytes.Buffer)
+	start := time.Now()
+	if err := trace.Start(buf); err != nil {
+		serveError(w, http.StatusInternalServerError, fmt.Sprintf("could not enable tracing: %v", err))
+		return
+	}
+	// Fails if a CPU profile is already being collected, which adds its
+	// samples to the trace anyway
+	profiling := pprof.StartCPUProfile(io.Discard) == nil
+	sleep(r, time.Duration(seconds*float64(time.Second)))
+	trace.Stop()
+	if profiling {
+		pprof.StopCPUProfile()
+	}
+	if r.Context().Err() != nil {
+		// the client has gone, so there's no one to convert the trace for
+		return
+	}
+
+	out := new(bytes.Buffer)
+	gz := gzip.NewWriter(out)
+	opts := convert.Options{
+		Start:   start,
+		Profile: kind,
+		Pprof: convert.PprofOptions{
+			Compat:         compat,
+			CPUProfileRate: cpuProfileRate,
+			Mapping:        convert.ExecutableMapping(),
+		},
+	}
+	if err := convert.TraceToPprof(buf, gz, opts); err != nil {
+		serveError(w, http.StatusInternalServerError, fmt.Sprintf("could not convert trace: %v", err))
+		return
+	}
+	if err := gz.Close(); err != nil {
+		serveError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.pprof"`, kind))
+	w.Write(out.Bytes())
+}
+
+// sleep waits for d, or until the request is cancelled.
+func sleep(r *http.Request, d time.Duration) {
+	select {
+	case <-time.After(d):
+	case <-r.Context().Done():
+	}
+}
+
+func serveError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(status)
+	fmt.Fprintln(w, msg)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/nsrip-dd/trace2timeline/convert"
+)
+
+// runLeaks implements the leaks command, which reports the goroutines in an
+// execution trace which look like they've leaked, grouped by where they
+// were created.
+func runLeaks(args []string) error {
+	fs := flag.NewFlagSet("leaks", flag.ExitOnError)
+	input := fs.String("i", "", "execution trace `file`, or - for stdin")
+	output := fs.String("o", "-", "output `file`, or - for stdout")
+	binary := fs.String("binary", "", "binary which produced the trace (required for traces from Go 1.6 and below)")
+	minBlocked := fs.Duration("min-blocked", 0, "only report goroutines which had been blocked for at least this `duration` by the end of the trace")
+	asJSON := fs.Bool("json", false, "write a JSON array of the suspected leaks rather than text")
+	addLogFlags(fs)
+	fs.Parse(args)
+
+	if *input == "" && fs.NArg() == 1 {
+		*input = fs.Arg(0)
+	}
+	if *input == "" {
+		fs.Usage()
+		return usageError("-i is required")
+	}
+
+	res, _, err := parseFile(*input, *binary, nil)
+	if err != nil {
+		return err
+	}
+	suspects := convert.LeakSuspects(res, int64(*minBlocked))
+
+	out, err := createOutput(*output)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		if suspects == nil {
+			suspects = []convert.LeakSuspect{}
+		}
+		err = json.NewEncoder(out).Encode(suspects)
+	} else {
+		err = writeLeaks(out, suspects)
+	}
+	if err != nil {
+		discard(out)
+		return err
+	}
+	return out.Close()
+}
+
+// writeLeaks writes the suspected leaks as text, with the stacks each group
+// of goroutines was created from and is blocked at.
+func writeLeaks(out io.Writer, suspects []convert.LeakSuspect) error {
+	w := bufio.NewWriter(out)
+	if len(suspects) == 0 {
+		fmt.Fprintln(w, "No suspected goroutine leaks")
+		return w.Flush()
+	}
+	frames := func(stk []convert.StackFrame) {
+		if len(stk) == 0 {
+			fmt.Fprintln(w, "    (no stack)")
+		}
+		for _, f := range stk {
+			fmt.Fprintf(w, "    %s\n        %s:%d\n", f.Func, f.File, f.Line)
+		}
+	}
+	for i, s := range suspects {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		ids := make([]string, len(s.Goroutines))
+		for i, g := range s.Goroutines {
+			ids[i] = fmt.Sprint(g)
+		}
+		fmt.Fprintf(w, "%s, blocked (%s) for at least %v\n",
+			cmp.Or(s.Func, "unknown function"), s.Reason, statsDuration(s.MinBlocked))
+		fmt.Fprintf(w, "  %d goroutines: %s\n", len(s.Goroutines), strings.Join(ids, ", "))
+		fmt.Fprintln(w, "  created at:")
+		frames(s.CreatedAt)
+		fmt.Fprintln(w, "  blocked at:")
+		frames(s.BlockedAt)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"flag"
+	"log/slog"
+	"os"
+)
+
+// logLevel is the level of the messages logged to stderr, set with -v and
+// -q. Stdout is kept for output.
+var logLevel = new(slog.LevelVar)
+
+func init() {
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: logLevel})))
+}
+
+// addLogFlags adds the -v and -q flags, which every command has, to fs.
+func addLogFlags(fs *flag.FlagSet) {
+	fs.BoolFunc("v", "log debug details to stderr", func(string) error {
+		logLevel.Set(slog.LevelDebug)
+		return nil
+	})
+	fs.BoolFunc("q", "only log errors to stderr", func(string) error {
+		logLevel.Set(slog.LevelError)
+		return nil
+	})
+}
@@ -1,101 +1,141 @@
 package main
 
 import (
-	"bytes"
-	"compress/gzip"
-	"context"
-	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/url"
 	"os"
-	"runtime"
-	"runtime/pprof"
-	"runtime/trace"
-	"sort"
-	"strconv"
-	"sync"
-	"time"
+
+	"github.com/nsrip-dd/trace2timeline/convert"
 )
 
-type ParsedEvent struct {
-	Type      string
-	Goroutine uint64
-	Timestamp int64
-	Stack     []StackFrame
-}
+const usage = `usage: trace2timeline <command> [flags]
 
-type StackFrame struct {
-	Func string
-	File string
-	Line int
-}
+Commands:
+  convert   convert an execution trace file into a profile
+  capture   fetch an execution trace from a running program and convert it
+  agent     continuously capture traces from a running program and export their profiles
+  convoys   report where goroutines in an execution trace convoyed on a lock
+  critpath  report what the critical path of each user task in an execution trace spent its time on
+  diff      compare the profiles from two execution traces
+  demo      capture a trace of some busy work in this process and convert it
+  gc        summarize the garbage collector's activity in an execution trace
+  leaks     report goroutines in an execution trace which look like they've leaked
+  otlp      convert an execution trace and push it to an OpenTelemetry collector
+  serve     run an HTTP server which converts the execution traces sent to it
+  runq      report when goroutines in an execution trace waited to run because every P was busy
+  samples   estimate how many CPU samples an execution trace is missing
+  schedlat  report the distribution of scheduling latencies in an execution trace
+  seq       write the channel messages between goroutines in an execution trace as a sequence diagram
+  stats     summarize the time each goroutine in an execution trace spent in each state
+  syscalls  report the syscalls in an execution trace which took long enough to lose their P
+  top       show the functions with the most CPU samples in an execution trace
+  tree      write the tree of which goroutine created which in an execution trace
+  tui       browse the goroutines of an execution trace in the terminal
+  util      write the CPU utilization over an execution trace as a time series
+  view      serve a web page with an interactive timeline of an execution trace
+  wakeups   write a graph of which goroutines woke up which in an execution trace
 
-func main() {
-	// start this so that we get CPU samples added to the trace
-	// (requires Go >= 1.19)
-	runtime.SetCPUProfileRate(100)
+Run "trace2timeline <command> -h" for the flags of each command.
+Every command takes -v to log debug details to stderr, and -q to only log
+errors.
 
-	buf := new(bytes.Buffer)
-	start := time.Now()
-	if err := trace.Start(buf); err != nil {
-		panic(err)
-	}
+Exit status:
+  0  success
+  1  any other error
+  2  invalid command line
+  3  malformed or unsupported trace
+  4  error reading or writing a file, or talking to a server
+`
 
-	var wg sync.WaitGroup
-	for j := 0; j < 4; j++ {
-		wg.Add(1)
-		// just do some work
-		thingy := make([]int, 1_000_000)
-		go pprof.Do(context.Background(), pprof.Labels("worker", strconv.Itoa(j)), func(_ context.Context) {
-			defer wg.Done()
-			for i := 0; i < 100; i++ {
-				sort.Ints(thingy)
-			}
-		})
-	}
-	wg.Wait()
+// usageError is an error in the command line, such as a missing or invalid
+// flag.
+type usageError string
 
-	trace.Stop()
-	stop := time.Now()
+func (e usageError) Error() string { return string(e) }
 
-	if err := os.WriteFile("trace.out", buf.Bytes(), 0660); err != nil {
-		panic(err)
+// exitCode returns the exit status for an error from one of the commands.
+func exitCode(err error) int {
+	var usageErr usageError
+	var parseErr *convert.ParseError
+	var pathErr *fs.PathError
+	var netErr *net.OpError
+	var urlErr *url.Error
+	switch {
+	case errors.As(err, &usageErr), errors.Is(err, convert.ErrBinaryRequired):
+		// the same as for bad flags, which the flag package handles
+		return 2
+	case errors.As(err, &parseErr):
+		return 3
+	case errors.As(err, &pathErr), errors.As(err, &netErr), errors.As(err, &urlErr):
+		return 4
 	}
+	return 1
+}
 
-	res, err := Parse(buf, "")
-	if err != nil {
-		panic(err)
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
 	}
-	var stuff []ParsedEvent
-	for _, event := range res.Events {
-		eventType := EventDescriptions[event.Type]
-		thing := ParsedEvent{
-			Type:      eventType.Name,
-			Timestamp: event.Ts,
-			Goroutine: event.G,
-		}
-		stk := res.Stacks[event.StkID]
-		for _, frame := range stk {
-			thing.Stack = append(thing.Stack, StackFrame{
-				File: frame.File,
-				Func: frame.Fn,
-				Line: frame.Line,
-			})
-		}
-		stuff = append(stuff, thing)
+	var err error
+	switch cmd, args := os.Args[1], os.Args[2:]; cmd {
+	case "convert":
+		err = runConvert(args)
+	case "capture":
+		err = runCapture(args)
+	case "agent":
+		err = runAgent(args)
+	case "convoys":
+		err = runConvoys(args)
+	case "critpath":
+		err = runCritPath(args)
+	case "diff":
+		err = runDiff(args)
+	case "demo":
+		err = runDemo(args)
+	case "gc":
+		err = runGC(args)
+	case "leaks":
+		err = runLeaks(args)
+	case "otlp":
+		err = runOTLP(args)
+	case "serve":
+		err = runServe(args)
+	case "runq":
+		err = runRunQ(args)
+	case "samples":
+		err = runSamples(args)
+	case "schedlat":
+		err = runSchedLat(args)
+	case "seq":
+		err = runSeq(args)
+	case "stats":
+		err = runStats(args)
+	case "syscalls":
+		err = runSyscalls(args)
+	case "top":
+		err = runTop(args)
+	case "tree":
+		err = runTree(args)
+	case "tui":
+		err = runTUI(args)
+	case "util":
+		err = runUtil(args)
+	case "view":
+		err = runView(args)
+	case "wakeups":
+		err = runWakeups(args)
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(os.Stdout, usage)
+	default:
+		fmt.Fprintf(os.Stderr, "trace2timeline: unknown command %q\n\n%s", cmd, usage)
+		os.Exit(2)
 	}
-	buf.Reset()
-	json.NewEncoder(buf).Encode(stuff)
-	os.WriteFile("trace.json", buf.Bytes(), 0660)
-
-	// PPROF version
-
-	f, err := os.Create("trace.pprof")
 	if err != nil {
-		panic(err)
-	}
-	defer f.Close()
-	gz := gzip.NewWriter(f)
-	defer gz.Close()
-	if err := ToPprof(res, start, stop, gz); err != nil {
-		panic(err)
+		fmt.Fprintf(os.Stderr, "trace2timeline: %v\n", err)
+		os.Exit(exitCode(err))
 	}
 }
@@ -0,0 +1,18 @@
+//go:build !unix
+
+package main
+
+import (
+	"io"
+	"os"
+)
+
+// mapFile reads the contents of f into memory, on platforms where it can't
+// be mapped.
+func mapFile(f *os.File, size int64) ([]byte, func() error, error) {
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, func() error { return nil }, nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/nsrip-dd/trace2timeline/convert"
+	"github.com/nsrip-dd/trace2timeline/export"
+)
+
+// runOTLP implements the otlp command, which converts an execution trace
+// and pushes the CPU samples, and optionally the tasks and regions as spans,
+// to an OpenTelemetry collector.
+func runOTLP(args []string) error {
+	fs := flag.NewFlagSet("otlp", flag.ExitOnError)
+	input := fs.String("i", "", "execution trace `file` to convert")
+	endpoint := fs.String("endpoint", "http://localhost:4318", "OTLP/HTTP collector base `URL`")
+	service := fs.String("service", "", "service.name resource attribute")
+	binary := fs.String("binary", "", "binary which produced the trace (required for traces from Go 1.6 and below)")
+	headers := keyValueFlag{}
+	fs.Var(headers, "header", "`key=value` header to add to export requests (repeatable)")
+	resource := keyValueFlag{}
+	fs.Var(resource, "resource", "`key=value` resource attribute (repeatable)")
+	spans := fs.Bool("spans", false, "also export user tasks, regions and logs as spans")
+	addLogFlags(fs)
+	fs.Parse(args)
+
+	if *input == "" {
+		fs.Usage()
+		return usageError("-i is required")
+	}
+	if *service != "" {
+		resource["service.name"] = *service
+	}
+
+	in, err := os.Open(*input)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	res, err := convert.Parse(in, *binary)
+	if err != nil {
+		return fmt.Errorf("parsing %s: %w", *input, err)
+	}
+	stop := info.ModTime()
+	start := stop.Add(-convert.Duration(res))
+	buf := new(bytes.Buffer)
+	if err := convert.ToOTLP(res, start, stop, resource, buf); err != nil {
+		return err
+	}
+	e := &export.OTLPExporter{Endpoint: *endpoint, Headers: headers}
+	if err := e.ExportProfiles(context.Background(), buf.Bytes()); err != nil {
+		return err
+	}
+	if !*spans {
+		return nil
+	}
+	buf.Reset()
+	if err := convert.ToOTLPSpans(res, start, resource, buf); err != nil {
+		return err
+	}
+	return e.ExportTraces(context.Background(), buf.Bytes())
+}
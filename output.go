@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"gocloud.dev/blob"
+	_ "gocloud.dev/blob/azureblob"
+	_ "gocloud.dev/blob/gcsblob"
+	_ "gocloud.dev/blob/s3blob"
+)
+
+// objectSchemes are the URL schemes of the object stores outputs can be
+// written to
+var objectSchemes = map[string]bool{"s3": true, "gs": true, "azblob": true}
+
+// createOutput creates the named output: stdout for "-", an object in a
+// store for URLs like s3://bucket/path/profile.pprof, and otherwise a file.
+// Objects are only written once the returned writer is closed. To give up
+// on an output without leaving a partial object behind, discard it instead.
+//
+// Credentials for the object stores come from the environment, as for the
+// stores' own tools: e.g. AWS_PROFILE or AWS_ACCESS_KEY_ID for S3,
+// GOOGLE_APPLICATION_CREDENTIALS for Cloud Storage, and AZURE_STORAGE_ACCOUNT
+// with AZURE_STORAGE_KEY or AZURE_STORAGE_SAS_TOKEN for Azure. Other bucket
+// options go in the URL's query parameters, like
+// s3://bucket/key?region=us-east-2.
+func createOutput(name string) (io.WriteCloser, error) {
+	if name == "-" {
+		return nopCloser{os.Stdout}, nil
+	}
+	u, err := url.Parse(name)
+	if err != nil || !objectSchemes[u.Scheme] {
+		return os.Create(name)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	key := u.Path
+	if len(key) > 0 && key[0] == '/' {
+		key = key[1:]
+	}
+	bucketURL := *u
+	bucketURL.Path = ""
+	bucket, err := blob.OpenBucket(ctx, bucketURL.String())
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	w, err := bucket.NewWriter(ctx, key, nil)
+	if err != nil {
+		bucket.Close()
+		cancel()
+		return nil, err
+	}
+	return &objectWriter{Writer: w, bucket: bucket, cancel: cancel}, nil
+}
+
+// discard closes an output which couldn't be written completely. Objects
+// aren't written at all, and files are left as they are.
+func discard(w io.WriteCloser) {
+	if ow, ok := w.(*objectWriter); ok {
+		// Cancelling the write's context makes Close abandon it
+		ow.cancel()
+	}
+	w.Close()
+}
+
+// objectWriter writes an object, and closes its bucket once done
+type objectWriter struct {
+	*blob.Writer
+	bucket *blob.Bucket
+	cancel context.CancelFunc
+}
+
+func (w *objectWriter) Close() error {
+	err := w.Writer.Close()
+	if cerr := w.bucket.Close(); err == nil {
+		err = cerr
+	}
+	w.cancel()
+	return err
+}
+
+// splitExt splits the named output into the part before its extension and
+// the rest, which for object URLs includes the query parameters.
+func splitExt(name string) (base, ext string) {
+	u, err := url.Parse(name)
+	if err != nil || !objectSchemes[u.Scheme] {
+		ext = filepath.Ext(name)
+		return strings.TrimSuffix(name, ext), ext
+	}
+	ext = path.Ext(u.Path)
+	if u.RawQuery != "" {
+		ext += "?" + u.RawQuery
+	}
+	u.Path = strings.TrimSuffix(u.Path, path.Ext(u.Path))
+	u.RawQuery = ""
+	return u.String(), ext
+}
+
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/nsrip-dd/trace2timeline/convert"
+)
+
+// runRunQ implements the runq command, which reports when
+// goroutines in an execution trace waited too long to run because every P
+// was busy, and which goroutines kept the Ps busy.
+func runRunQ(args []string) error {
+	fs := flag.NewFlagSet("runq", flag.ExitOnError)
+	input := fs.String("i", "", "execution trace `file`, or - for stdin")
+	output := fs.String("o", "-", "output `file`, or - for stdout")
+	binary := fs.String("binary", "", "binary which produced the trace (required for traces from Go 1.6 and below)")
+	threshold := fs.Duration("threshold", time.Millisecond, "report goroutines which were runnable for longer than this `duration`")
+	hogs := fs.Int("hogs", 5, "list the `n` goroutines which ran the longest during each period, or all of them if 0")
+	asJSON := fs.Bool("json", false, "write a JSON array of the periods, with all the goroutines which ran during them, rather than text")
+	addLogFlags(fs)
+	fs.Parse(args)
+
+	if *input == "" && fs.NArg() == 1 {
+		*input = fs.Arg(0)
+	}
+	if *input == "" {
+		fs.Usage()
+		return usageError("-i is required")
+	}
+
+	res, _, err := parseFile(*input, *binary, nil)
+	if err != nil {
+		return err
+	}
+	periods := convert.RunQueueStarvation(res, int64(*threshold))
+
+	out, err := createOutput(*output)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		if periods == nil {
+			periods = []convert.Starvation{}
+		}
+		err = json.NewEncoder(out).Encode(periods)
+	} else {
+		var base int64
+		if len(res.Events) > 0 {
+			base = res.Events[0].Ts
+		}
+		err = writeStarvation(out, periods, base, *hogs)
+	}
+	if err != nil {
+		discard(out)
+		return err
+	}
+	return out.Close()
+}
+
+// writeStarvation writes the starvation periods as text, with when they
+// started relative to base, the start of the trace, and up to hogs of the
+// goroutines which ran during each.
+func writeStarvation(out io.Writer, periods []convert.Starvation, base int64, hogs int) error {
+	w := bufio.NewWriter(out)
+	if len(periods) == 0 {
+		fmt.Fprintln(w, "No run queue starvation")
+		return w.Flush()
+	}
+	for i, p := range periods {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		ids := make([]string, len(p.Waiting))
+		for i, g := range p.Waiting {
+			ids[i] = fmt.Sprint(g)
+		}
+		fmt.Fprintf(w, "%v to %v: goroutines starved, the longest runnable for %v\n",
+			statsDuration(p.Start-base), statsDuration(p.End-base), statsDuration(p.LongestWait))
+		fmt.Fprintf(w, "  waiting: %s\n", strings.Join(ids, ", "))
+		fmt.Fprintln(w, "  running:")
+		shown := p.Hogs
+		if hogs > 0 && len(shown) > hogs {
+			shown = shown[:hogs]
+		}
+		for _, h := range shown {
+			fmt.Fprintf(w, "  %10v  g%d %s\n", statsDuration(h.Running), h.G, cmp.Or(h.Func, "unknown function"))
+		}
+		if more := len(p.Hogs) - len(shown); more > 0 {
+			fmt.Fprintf(w, "  and %d more\n", more)
+		}
+	}
+	return w.Flush()
+}
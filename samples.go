@@ -0,0 +1,101 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nsrip-dd/trace2timeline/convert"
+)
+
+// runSamples implements the samples command, which estimates how many CPU
+// samples an execution trace is missing, to tell how far to trust the CPU
+// profile made from it.
+func runSamples(args []string) error {
+	fs := flag.NewFlagSet("samples", flag.ExitOnError)
+	input := fs.String("i", "", "execution trace `file`, or - for stdin")
+	output := fs.String("o", "-", "output `file`, or - for stdout")
+	binary := fs.String("binary", "", "binary which produced the trace (required for traces from Go 1.6 and below)")
+	rate := fs.Int("cpu-rate", 100, "CPU profiling `rate` in Hz the traced program used, set with runtime.SetCPUProfileRate")
+	minGap := fs.Duration("min-gap", 0, "report goroutines which ran for this `duration` without being sampled (default five sampling periods)")
+	limit := fs.Int("n", 10, "list the `n` longest gaps, or all of them if 0")
+	asJSON := fs.Bool("json", false, "write the estimate and all the gaps as JSON rather than text")
+	addLogFlags(fs)
+	fs.Parse(args)
+
+	if *input == "" && fs.NArg() == 1 {
+		*input = fs.Arg(0)
+	}
+	if *input == "" {
+		fs.Usage()
+		return usageError("-i is required")
+	}
+	if *rate <= 0 {
+		return usageError("-cpu-rate must be positive")
+	}
+	period := time.Second / time.Duration(*rate)
+	if *minGap <= 0 {
+		*minGap = 5 * period
+	}
+
+	res, _, err := parseFile(*input, *binary, nil)
+	if err != nil {
+		return err
+	}
+	loss := convert.NewSampleLoss(res, int64(period), int64(*minGap))
+
+	out, err := createOutput(*output)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		err = json.NewEncoder(out).Encode(loss)
+	} else {
+		var base int64
+		if len(res.Events) > 0 {
+			base = res.Events[0].Ts
+		}
+		err = writeSampleLoss(out, loss, base, *limit)
+	}
+	if err != nil {
+		discard(out)
+		return err
+	}
+	return out.Close()
+}
+
+// writeSampleLoss writes the estimate of the missing samples as text, and
+// up to limit of the gaps as a table, with times relative to base, the
+// start of the trace.
+func writeSampleLoss(out io.Writer, loss convert.SampleLoss, base int64, limit int) error {
+	if loss.Samples == 0 && loss.Lost == 0 {
+		_, err := fmt.Fprintln(out, "No CPU samples in the trace; was the CPU profiler running?")
+		return err
+	}
+	fmt.Fprintf(out, "%d samples from %v of running at %v per sample, expected %.0f\n",
+		loss.Samples, statsDuration(loss.Running), time.Duration(loss.Period), loss.Expected)
+	fmt.Fprintf(out, "estimated lost samples: %.0f (%.1f%%)\n", loss.EstimatedLost, 100*loss.EstimatedLostFraction)
+	if loss.Lost > 0 {
+		fmt.Fprintf(out, "the runtime recorded losing samples %d times\n", loss.Lost)
+	}
+	if len(loss.Gaps) == 0 {
+		return nil
+	}
+	gaps := loss.Gaps
+	if limit > 0 && len(gaps) > limit {
+		gaps = gaps[:limit]
+	}
+	fmt.Fprintf(out, "\ngaps without samples: %d, longest first\n", len(loss.Gaps))
+	tw := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "START\tEND\tRUNNING\tEXPECTED\tGOROUTINE\tFUNC")
+	for _, g := range gaps {
+		fmt.Fprintf(tw, "%v\t%v\t%v\t%.1f\t%d\t%s\n",
+			statsDuration(g.Start-base), statsDuration(g.End-base), statsDuration(g.Running), g.Expected,
+			g.G, cmp.Or(g.Func, "unknown function"))
+	}
+	return tw.Flush()
+}
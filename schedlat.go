@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+
+	"github.com/nsrip-dd/trace2timeline/convert"
+)
+
+// runSchedLat implements the schedlat command, which reports the
+// distribution of scheduling latencies in an execution trace: how long
+// goroutines waited to run once they were runnable.
+func runSchedLat(args []string) error {
+	fs := flag.NewFlagSet("schedlat", flag.ExitOnError)
+	input := fs.String("i", "", "execution trace `file`, or - for stdin")
+	output := fs.String("o", "-", "output `file`, or - for stdout")
+	binary := fs.String("binary", "", "binary which produced the trace (required for traces from Go 1.6 and below)")
+	hdr := fs.String("hdr", "", "also write the latencies as a JSON histogram with the same log-linear buckets as runtime/metrics' /sched/latencies:seconds to `file`")
+	addLogFlags(fs)
+	fs.Parse(args)
+
+	if *input == "" && fs.NArg() == 1 {
+		*input = fs.Arg(0)
+	}
+	if *input == "" {
+		fs.Usage()
+		return usageError("-i is required")
+	}
+
+	res, _, err := parseFile(*input, *binary, nil)
+	if err != nil {
+		return err
+	}
+	latencies := convert.SchedLatencies(res)
+
+	out, err := createOutput(*output)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(out)
+	for _, line := range convert.NewLatencyHistogram(latencies).Lines() {
+		fmt.Fprintln(w, line)
+	}
+	if err := w.Flush(); err != nil {
+		discard(out)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+
+	if *hdr == "" {
+		return nil
+	}
+	out, err = createOutput(*hdr)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(out).Encode(convert.NewHDRHistogram(latencies)); err != nil {
+		discard(out)
+		return err
+	}
+	return out.Close()
+}
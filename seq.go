@@ -0,0 +1,58 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+
+	"github.com/nsrip-dd/trace2timeline/convert"
+)
+
+// runSeq implements the seq command, which writes the channel messages
+// between goroutines in an execution trace as a sequence diagram.
+func runSeq(args []string) error {
+	fs := flag.NewFlagSet("seq", flag.ExitOnError)
+	input := fs.String("i", "", "execution trace `file`, or - for stdin")
+	output := fs.String("o", "-", "output `file`, or - for stdout")
+	binary := fs.String("binary", "", "binary which produced the trace (required for traces from Go 1.6 and below)")
+	format := fs.String("format", "mermaid", "output `format`: mermaid or plantuml")
+	var startFlag, endFlag timeFlag
+	fs.Var(&startFlag, "start", "only include messages after this `time`, an offset from the start of the trace like 1m30s or an RFC 3339 wall clock time")
+	fs.Var(&endFlag, "end", "only include messages before this `time`, in the same form as -start")
+	var goroutines idListFlag
+	fs.Var(&goroutines, "goroutines", "only include messages to or from these goroutine `IDs`, separated by commas")
+	limit := fs.Int("n", 200, "include at most this many `messages`, the earliest ones, since larger diagrams are hard to read (0 for no limit)")
+	addLogFlags(fs)
+	fs.Parse(args)
+
+	if *input == "" && fs.NArg() == 1 {
+		*input = fs.Arg(0)
+	}
+	if *input == "" {
+		fs.Usage()
+		return usageError("-i is required")
+	}
+	if *format != "mermaid" && *format != "plantuml" {
+		return usageError(fmt.Sprintf("unknown format %q", *format))
+	}
+
+	res, traceStart, err := parseFile(*input, *binary, nil)
+	if err != nil {
+		return err
+	}
+	msgs := convert.ChannelMessages(res, startFlag.resolve(traceStart), endFlag.resolve(traceStart), goroutines)
+	if *limit > 0 && len(msgs) > *limit {
+		slog.Warn("leaving out later messages; use -n, -start, -end or -goroutines to choose which to include",
+			"messages", len(msgs), "included", *limit)
+		msgs = msgs[:*limit]
+	}
+	out, err := createOutput(*output)
+	if err != nil {
+		return err
+	}
+	if err := convert.ToSequenceDiagram(res, msgs, *format, out); err != nil {
+		discard(out)
+		return err
+	}
+	return out.Close()
+}
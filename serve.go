@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nsrip-dd/trace2timeline/convert"
+)
+
+// runServe implements the serve command, which runs an HTTP server that
+// converts the execution traces POSTed to it.
+func runServe(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := fs.String("addr", "localhost:8080", "`address` to listen on")
+	maxSize := fs.Int64("max-size", 1<<30, "largest trace to accept, in `bytes`")
+	addLogFlags(fs)
+	fs.Parse(args)
+
+	s := &server{maxSize: *maxSize}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /convert", s.convert)
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, serveUsage)
+	})
+	slog.Info("listening", "addr", *addr)
+	return http.ListenAndServe(*addr, mux)
+}
+
+var serveUsage = `trace2timeline
+
+POST an execution trace, optionally gzip or zstd compressed, to /convert to
+convert it. The query parameters select the output:
+
+  format    output format: ` + strings.Join(formats, ", ") + `
+  profile   kind of profile for pprof output (default cpu)
+  compat    if true, leave out the Breakdown and LabelSet extensions
+  cpu-rate  CPU profiling rate in Hz the traced program used (default 100)
+  breakdown-interval
+            roll the breakdown of each sample up into entries for each
+            interval, like 10ms
+  compress  gzip, zstd or none, optionally with a level like gzip:9 (default
+            gzip for pprof, none otherwise)
+  pause-threshold
+            mark stop-the-world pauses longer than this, like 5ms, as
+            anomalies in json, chrome and timeline output (default 10ms)
+
+Without a format parameter, the format follows the Accept header:
+application/json gives json, text/html gives flamegraph, text/plain gives
+folded, and anything else gives pprof.
+
+For example:
+
+  curl --data-binary @trace.out 'http://localhost:8080/convert?profile=offcpu' > offcpu.pprof
+`
+
+// server converts traces sent to it over HTTP
+type server struct {
+	maxSize int64
+}
+
+// contentTypes are the media types of the output formats
+var contentTypes = map[string]string{
+	"pprof":            "application/octet-stream",
+	"json":             "application/json",
+	"ndjson":           "application/x-ndjson",
+	"csv":              "text/csv; charset=utf-8",
+	"sqlite":           "application/vnd.sqlite3",
+	"parquet":          "application/vnd.apache.parquet",
+	"arrow":            "application/vnd.apache.arrow.file",
+	"proto":            "application/x-protobuf",
+	"msgpack":          "application/vnd.msgpack",
+	"timeline-msgpack": "application/vnd.msgpack",
+	"chrome":           "application/json",
+	"perfetto":         "application/octet-stream",
+	"folded":           "text/plain; charset=utf-8",
+	"otlp":             "application/x-protobuf",
+	"flamegraph":       "text/html; charset=utf-8",
+	"stw":              "application/json",
+	"timeline":         "application/json",
+	"otlp-spans":       "application/x-protobuf",
+}
+
+func (s *server) convert(w http.ResponseWriter, r *http.Request) {
+	opts, err := requestOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, s.maxSize))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, fmt.Sprintf("trace is larger than %d bytes", s.maxSize), http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	res, err := convert.ParseBytes(data, "", opts.keep())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("parsing trace: %v", err), http.StatusUnprocessableEntity)
+		return
+	}
+	// The trace was presumably uploaded right after it was captured
+	stop := time.Now()
+	start := stop.Add(-convert.Duration(res))
+	// Converted in full before responding, so that a failure can still
+	// be reported with the status code
+	buf := new(bytes.Buffer)
+	if err := writeOutput(buf, res, start, stop, opts); err != nil {
+		slog.Error("converting trace", "remote", r.RemoteAddr, "err", err)
+		http.Error(w, fmt.Sprintf("converting trace: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", contentTypes[opts.format])
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.Write(buf.Bytes())
+}
+
+// requestOptions returns the conversion options selected by the request's
+// query parameters and Accept header.
+func requestOptions(r *http.Request) (outputOptions, error) {
+	q := r.URL.Query()
+	opts := outputOptions{
+		format:  q.Get("format"),
+		profile: q.Get("profile"),
+		title:   "trace",
+	}
+	if opts.format == "" {
+		opts.format = acceptedFormat(r.Header.Get("Accept"))
+	}
+	if opts.profile == "" {
+		opts.profile = "cpu"
+	}
+	opts.pprof.CPUProfileRate = 100
+	opts.pauseThreshold = defaultPauseThreshold
+	if v := q.Get("cpu-rate"); v != "" {
+		rate, err := strconv.Atoi(v)
+		if err != nil || rate <= 0 {
+			return opts, fmt.Errorf("invalid cpu-rate %q", v)
+		}
+		opts.pprof.CPUProfileRate = rate
+	}
+	if v := q.Get("breakdown-interval"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return opts, fmt.Errorf("invalid breakdown-interval %q", v)
+		}
+		opts.pprof.BreakdownInterval = d
+	}
+	if v := q.Get("pause-threshold"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return opts, fmt.Errorf("invalid pause-threshold %q", v)
+		}
+		opts.pauseThreshold = d
+	}
+	if v := q.Get("compat"); v != "" {
+		compat, err := strconv.ParseBool(v)
+		if err != nil {
+			return opts, fmt.Errorf("invalid compat %q", v)
+		}
+		opts.pprof.Compat = compat
+	}
+	if v := q.Get("compress"); v != "" {
+		c, err := convert.ParseCompression(v)
+		if err != nil {
+			return opts, err
+		}
+		opts.compression = c
+	}
+	return opts, opts.check()
+}
+
+// acceptedFormat returns the output format for an Accept header: the first
+// media type it lists which a format produces.
+func acceptedFormat(accept string) string {
+	for _, part := range strings.Split(accept, ",") {
+		mt, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		switch mt {
+		case "application/json":
+			return "json"
+		case "text/html":
+			return "flamegraph"
+		case "text/plain":
+			return "folded"
+		}
+	}
+	return "pprof"
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nsrip-dd/trace2timeline/convert"
+)
+
+// statsSorts are the columns the stats command can sort by, with how to
+// order the goroutines by each. Times and counts sort the largest first.
+var statsSorts = map[string]func(a, b convert.GoroutineStats) int{
+	"id":       func(a, b convert.GoroutineStats) int { return cmp.Compare(a.ID, b.ID) },
+	"running":  func(a, b convert.GoroutineStats) int { return cmp.Compare(b.Running, a.Running) },
+	"runnable": func(a, b convert.GoroutineStats) int { return cmp.Compare(b.Runnable, a.Runnable) },
+	"blocked":  func(a, b convert.GoroutineStats) int { return cmp.Compare(b.Blocked, a.Blocked) },
+	"syscall":  func(a, b convert.GoroutineStats) int { return cmp.Compare(b.Syscall, a.Syscall) },
+	"samples":  func(a, b convert.GoroutineStats) int { return cmp.Compare(b.CPUSamples, a.CPUSamples) },
+}
+
+// statsJSON is the output of the stats command with -json.
+type statsJSON struct {
+	Goroutines []convert.GoroutineStats `json:"goroutines"`
+	LongPauses []statsPause             `json:"long_pauses,omitempty"`
+}
+
+// statsPause is a stop-the-world pause in statsJSON, with the same columns
+// as writeLongPauses's table.
+type statsPause struct {
+	Time     time.Time `json:"time"`
+	Offset   int64     `json:"offset_ns"`
+	Duration int64     `json:"duration_ns"`
+	Reason   string    `json:"reason,omitempty"`
+}
+
+// runStats implements the stats command, which summarizes what each
+// goroutine in an execution trace spent its time doing, as a table or as
+// JSON.
+func runStats(args []string) error {
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	input := fs.String("i", "", "execution trace `file`, or - for stdin")
+	output := fs.String("o", "-", "output `file`, or - for stdout")
+	binary := fs.String("binary", "", "binary which produced the trace (required for traces from Go 1.6 and below)")
+	asJSON := fs.Bool("json", false, "write the goroutines' stats and the long pauses as JSON rather than tables")
+	sortBy := fs.String("sort", "running", "`column` to sort by: "+strings.Join(slices.Sorted(maps.Keys(statsSorts)), ", "))
+	limit := fs.Int("n", 0, "only show the first `n` goroutines, or all of them if 0")
+	pauseThreshold := fs.Duration("pause-threshold", defaultPauseThreshold, "list the stop-the-world pauses longer than this `duration` after the table, or in the JSON (0 to list none)")
+	addLogFlags(fs)
+	fs.Parse(args)
+
+	if *input == "" && fs.NArg() == 1 {
+		*input = fs.Arg(0)
+	}
+	if *input == "" {
+		fs.Usage()
+		return usageError("-i is required")
+	}
+	order, ok := statsSorts[*sortBy]
+	if !ok {
+		return usageError(fmt.Sprintf("unknown sort column %q", *sortBy))
+	}
+
+	res, start, err := parseFile(*input, *binary, nil)
+	if err != nil {
+		return err
+	}
+	stats := convert.NewGoroutineStats(res)
+	// ties are broken by ID, which they're already ordered by
+	slices.SortStableFunc(stats, order)
+	if *limit > 0 && *limit < len(stats) {
+		stats = stats[:*limit]
+	}
+
+	out, err := createOutput(*output)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		err = json.NewEncoder(out).Encode(newStatsJSON(stats, res, start, *pauseThreshold))
+	} else {
+		err = writeStatsTable(out, stats)
+		if err == nil {
+			err = writeLongPauses(out, res, start, *pauseThreshold)
+		}
+	}
+	if err != nil {
+		discard(out)
+		return err
+	}
+	return out.Close()
+}
+
+// writeStatsTable writes the goroutines' stats as a table, with the time
+// each was blocked for each reason, longest first, and where it was created.
+func writeStatsTable(out io.Writer, stats []convert.GoroutineStats) error {
+	tw := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "GOROUTINE\tRUNNING\tRUNNABLE\tBLOCKED\tSYSCALL\tSAMPLES\tFUNC\tCREATED AT\tBLOCKED ON")
+	for _, s := range stats {
+		created := ""
+		if len(s.CreatedAt) > 0 {
+			f := s.CreatedAt[0]
+			created = fmt.Sprintf("%s (%s:%d) in g%d", f.Func, filepath.Base(f.File), f.Line, s.CreatedBy)
+		}
+		reasons := slices.SortedFunc(maps.Keys(s.BlockedBy), func(a, b string) int {
+			return cmp.Or(cmp.Compare(s.BlockedBy[b], s.BlockedBy[a]), strings.Compare(a, b))
+		})
+		var blocked []string
+		for _, r := range reasons {
+			blocked = append(blocked, fmt.Sprintf("%s %v", r, statsDuration(s.BlockedBy[r])))
+		}
+		fmt.Fprintf(tw, "%d\t%v\t%v\t%v\t%v\t%d\t%s\t%s\t%s\n",
+			s.ID, statsDuration(s.Running), statsDuration(s.Runnable), statsDuration(s.Blocked),
+			statsDuration(s.Syscall), s.CPUSamples, s.Func, created, strings.Join(blocked, ", "))
+	}
+	return tw.Flush()
+}
+
+// writeLongPauses writes a table of the stop-the-world pauses in the parsed
+// trace, which started at the given time, longer than threshold, with the
+// wall clock time and offset into the trace each started at. It writes
+// nothing if there are none.
+func writeLongPauses(out io.Writer, parsed convert.ParseResult, start time.Time, threshold time.Duration) error {
+	pauses := convert.LongPauses(parsed, int64(threshold))
+	if len(pauses) == 0 {
+		return nil
+	}
+	base := parsed.Events[0].Ts
+	fmt.Fprintf(out, "\nStop-the-world pauses longer than %v:\n", threshold)
+	tw := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tOFFSET\tDURATION\tREASON")
+	for _, p := range pauses {
+		at := start.Add(time.Duration(p.Start - base))
+		fmt.Fprintf(tw, "%s\t%v\t%v\t%s\n",
+			at.Format("2006-01-02 15:04:05.000000"), statsDuration(p.Start-base), statsDuration(p.Duration()), p.Reason)
+	}
+	return tw.Flush()
+}
+
+// newStatsJSON returns the goroutines' stats along with the stop-the-world
+// pauses in the parsed trace, which started at the given time, longer than
+// threshold.
+func newStatsJSON(stats []convert.GoroutineStats, parsed convert.ParseResult, start time.Time, threshold time.Duration) statsJSON {
+	out := statsJSON{Goroutines: stats}
+	for _, p := range convert.LongPauses(parsed, int64(threshold)) {
+		offset := p.Start - parsed.Events[0].Ts
+		out.LongPauses = append(out.LongPauses, statsPause{
+			Time:     start.Add(time.Duration(offset)),
+			Offset:   offset,
+			Duration: p.Duration(),
+			Reason:   p.Reason,
+		})
+	}
+	return out
+}
+
+// statsDuration rounds a time in nanoseconds to the microsecond, which is
+// plenty for the table.
+func statsDuration(ns int64) time.Duration {
+	return time.Duration(ns).Round(time.Microsecond)
+}
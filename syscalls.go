@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/nsrip-dd/trace2timeline/convert"
+)
+
+// runSyscalls implements the syscalls command, which reports the syscalls
+// in an execution trace which took long enough to lose their P.
+func runSyscalls(args []string) error {
+	fs := flag.NewFlagSet("syscalls", flag.ExitOnError)
+	input := fs.String("i", "", "execution trace `file`, or - for stdin")
+	output := fs.String("o", "-", "output `file`, or - for stdout")
+	binary := fs.String("binary", "", "binary which produced the trace (required for traces from Go 1.6 and below)")
+	minDuration := fs.Duration("min", time.Millisecond, "only report syscalls which took at least this `duration`")
+	limit := fs.Int("n", 20, "report the `n` longest syscalls, or all of them if 0")
+	asJSON := fs.Bool("json", false, "write a JSON array of the syscalls rather than text")
+	addLogFlags(fs)
+	fs.Parse(args)
+
+	if *input == "" && fs.NArg() == 1 {
+		*input = fs.Arg(0)
+	}
+	if *input == "" {
+		fs.Usage()
+		return usageError("-i is required")
+	}
+
+	res, _, err := parseFile(*input, *binary, nil)
+	if err != nil {
+		return err
+	}
+	syscalls := convert.LongSyscalls(res, int64(*minDuration))
+	if *limit > 0 && len(syscalls) > *limit {
+		syscalls = syscalls[:*limit]
+	}
+
+	out, err := createOutput(*output)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		if syscalls == nil {
+			syscalls = []convert.LongSyscall{}
+		}
+		err = json.NewEncoder(out).Encode(syscalls)
+	} else {
+		var base int64
+		if len(res.Events) > 0 {
+			base = res.Events[0].Ts
+		}
+		err = writeSyscalls(out, syscalls, base)
+	}
+	if err != nil {
+		discard(out)
+		return err
+	}
+	return out.Close()
+}
+
+// writeSyscalls writes the long syscalls as text, with when they started
+// relative to base, the start of the trace, and the stacks they were made
+// from.
+func writeSyscalls(out io.Writer, syscalls []convert.LongSyscall, base int64) error {
+	w := bufio.NewWriter(out)
+	if len(syscalls) == 0 {
+		fmt.Fprintln(w, "No syscalls lost their P")
+		return w.Flush()
+	}
+	for i, s := range syscalls {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "g%d %s: %v syscall at %v, without its P for %v",
+			s.G, cmp.Or(s.Func, "unknown function"), statsDuration(s.Duration()),
+			statsDuration(s.Start-base), statsDuration(s.End-s.Retaken))
+		if s.Incomplete {
+			fmt.Fprint(w, " (still running at the end of the trace)")
+		}
+		fmt.Fprintln(w)
+		if len(s.Stack) == 0 {
+			fmt.Fprintln(w, "    (no stack)")
+		}
+		for _, f := range s.Stack {
+			fmt.Fprintf(w, "    %s\n        %s:%d\n", f.Func, f.File, f.Line)
+		}
+	}
+	return w.Flush()
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"flag"
+	"fmt"
+	"io"
+	"slices"
+	"time"
+
+	"github.com/nsrip-dd/trace2timeline/convert"
+)
+
+// runTop implements the top command, which prints the functions with the
+// most CPU samples in an execution trace, like pprof's top report, for a
+// quick look without converting the trace to a profile first.
+func runTop(args []string) error {
+	fs := flag.NewFlagSet("top", flag.ExitOnError)
+	input := fs.String("i", "", "execution trace `file`, or - for stdin")
+	output := fs.String("o", "-", "output `file`, or - for stdout")
+	binary := fs.String("binary", "", "binary which produced the trace (required for traces from Go 1.6 and below)")
+	limit := fs.Int("n", 10, "show the top `n` functions, or all of them if 0")
+	cum := fs.Bool("cum", false, "sort by cumulative samples rather than flat")
+	rate := fs.Int("cpu-rate", 100, "CPU profiling `rate` in Hz the traced program used, set with runtime.SetCPUProfileRate")
+	addLogFlags(fs)
+	fs.Parse(args)
+
+	if *input == "" && fs.NArg() == 1 {
+		*input = fs.Arg(0)
+	}
+	if *input == "" {
+		fs.Usage()
+		return usageError("-i is required")
+	}
+	if *rate <= 0 {
+		return usageError("-cpu-rate must be positive")
+	}
+
+	res, _, err := parseFile(*input, *binary, convert.CPUEvents)
+	if err != nil {
+		return err
+	}
+	funcs, total := convert.TopFuncs(res)
+	if *cum {
+		slices.SortStableFunc(funcs, func(a, b convert.TopFunc) int { return cmp.Compare(b.Cum, a.Cum) })
+	}
+
+	out, err := createOutput(*output)
+	if err != nil {
+		return err
+	}
+	if err := writeTop(out, funcs, total, *limit, time.Second/time.Duration(*rate)); err != nil {
+		discard(out)
+		return err
+	}
+	return out.Close()
+}
+
+// writeTop writes the first n of the functions as a table like pprof's top
+// report, with each sample counting for the given period of CPU time.
+func writeTop(out io.Writer, funcs []convert.TopFunc, total, n int, period time.Duration) error {
+	w := bufio.NewWriter(out)
+	if total == 0 {
+		fmt.Fprintln(w, "No CPU samples in the trace")
+		return w.Flush()
+	}
+	if n <= 0 || n > len(funcs) {
+		n = len(funcs)
+	}
+	shown := 0
+	for _, f := range funcs[:n] {
+		shown += f.Flat
+	}
+	pct := func(samples int) string { return fmt.Sprintf("%.2f%%", 100*float64(samples)/float64(total)) }
+	cpu := func(samples int) time.Duration { return time.Duration(samples) * period }
+	fmt.Fprintf(w, "Showing nodes accounting for %v, %s of %v total (%d samples)\n", cpu(shown), pct(shown), cpu(total), total)
+	if n < len(funcs) {
+		fmt.Fprintf(w, "Dropped %d nodes\n", len(funcs)-n)
+	}
+	fmt.Fprintf(w, "%10s %7s %7s %10s %7s\n", "flat", "flat%", "sum%", "cum", "cum%")
+	sum := 0
+	for _, f := range funcs[:n] {
+		sum += f.Flat
+		fmt.Fprintf(w, "%10v %7s %7s %10v %7s  %s\n", cpu(f.Flat), pct(f.Flat), pct(sum), cpu(f.Cum), pct(f.Cum), f.Func)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+
+	"github.com/nsrip-dd/trace2timeline/convert"
+)
+
+// runTree implements the tree command, which writes the tree of which
+// goroutine created which in an execution trace, with the running and
+// blocked time of each subtree.
+func runTree(args []string) error {
+	fs := flag.NewFlagSet("tree", flag.ExitOnError)
+	input := fs.String("i", "", "execution trace `file`, or - for stdin")
+	output := fs.String("o", "-", "output `file`, or - for stdout")
+	binary := fs.String("binary", "", "binary which produced the trace (required for traces from Go 1.6 and below)")
+	format := fs.String("format", "json", "output `format`: json, or dot for Graphviz")
+	addLogFlags(fs)
+	fs.Parse(args)
+
+	if *input == "" && fs.NArg() == 1 {
+		*input = fs.Arg(0)
+	}
+	if *input == "" {
+		fs.Usage()
+		return usageError("-i is required")
+	}
+	if *format != "json" && *format != "dot" {
+		return usageError(fmt.Sprintf("unknown format %q", *format))
+	}
+
+	res, _, err := parseFile(*input, *binary, nil)
+	if err != nil {
+		return err
+	}
+	out, err := createOutput(*output)
+	if err != nil {
+		return err
+	}
+	if *format == "dot" {
+		err = convert.ToGoroutineTreeDOT(res, out)
+	} else {
+		roots := convert.GoroutineTree(res)
+		if roots == nil {
+			roots = []*convert.GoroutineNode{}
+		}
+		err = json.NewEncoder(out).Encode(roots)
+	}
+	if err != nil {
+		discard(out)
+		return err
+	}
+	return out.Close()
+}
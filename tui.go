@@ -0,0 +1,353 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/nsrip-dd/trace2timeline/convert"
+	"golang.org/x/term"
+)
+
+// runTUI implements the tui command, which shows the goroutine lanes of an
+// execution trace in the terminal, to scroll and zoom through without
+// converting the trace first.
+func runTUI(args []string) error {
+	fs := flag.NewFlagSet("tui", flag.ExitOnError)
+	input := fs.String("i", "", "execution trace `file`")
+	binary := fs.String("binary", "", "binary which produced the trace (required for traces from Go 1.6 and below)")
+	addLogFlags(fs)
+	fs.Parse(args)
+
+	if *input == "" && fs.NArg() == 1 {
+		*input = fs.Arg(0)
+	}
+	if *input == "" {
+		fs.Usage()
+		return usageError("-i is required")
+	}
+	if *input == "-" {
+		// stdin is where the key presses come from
+		return usageError("the tui command can't read the trace from stdin")
+	}
+	in, out := int(os.Stdin.Fd()), int(os.Stdout.Fd())
+	if !term.IsTerminal(in) || !term.IsTerminal(out) {
+		return usageError("the tui command needs a terminal")
+	}
+
+	res, start, err := parseFile(*input, *binary, nil)
+	if err != nil {
+		return err
+	}
+	v := &tuiView{tl: convert.NewTimeline(res, start, 0)}
+	v.reset()
+
+	state, err := term.MakeRaw(in)
+	if err != nil {
+		return err
+	}
+	defer term.Restore(in, state)
+	// Draw on the alternate screen, so the shell's scrollback is left as it
+	// was once we're done.
+	os.Stdout.WriteString("\x1b[?1049h\x1b[?25l")
+	defer os.Stdout.WriteString("\x1b[?25h\x1b[?1049l")
+
+	buf := make([]byte, 16)
+	for {
+		// The size is checked again each time, to follow the terminal
+		// being resized.
+		v.width, v.height, err = term.GetSize(out)
+		if err != nil {
+			return err
+		}
+		os.Stdout.Write(v.render())
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			return err
+		}
+		for _, k := range splitKeys(string(buf[:n])) {
+			if !v.key(k) {
+				return nil
+			}
+		}
+	}
+}
+
+// splitKeys splits what was read from the terminal into key presses, as
+// several can come in one read, e.g. when a key is held down.
+func splitKeys(s string) []string {
+	var keys []string
+	for len(s) > 0 {
+		n := 1
+		if strings.HasPrefix(s, "\x1b[") {
+			// an escape sequence, up to its final byte
+			n = strings.IndexFunc(s[2:], func(r rune) bool { return r == '~' || r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z' }) + 3
+			if n == 2 {
+				n = len(s)
+			}
+		} else {
+			_, n = utf8.DecodeRuneInString(s)
+		}
+		keys = append(keys, s[:n])
+		s = s[n:]
+	}
+	return keys
+}
+
+const (
+	// tuiLabelWidth is the width of the goroutine labels left of the lanes
+	tuiLabelWidth = 24
+	// tuiPanelHeight is the most lines the details of the interval under
+	// the cursor take up at the bottom of the screen
+	tuiPanelHeight = 12
+	// tuiAxisStep is the number of columns between the marks of the time
+	// axis
+	tuiAxisStep = 14
+)
+
+// tuiStates is how each goroutine state is drawn in the lanes.
+var tuiStates = map[string]string{
+	"running":  "\x1b[32m█",
+	"runnable": "\x1b[33m▒",
+	"blocked":  "\x1b[31m░",
+	"syscall":  "\x1b[34m▓",
+}
+
+// tuiView is the state of the tui command's screen: which part of the
+// timeline is shown, and where the cursor is.
+type tuiView struct {
+	tl *convert.Timeline
+	// from and span are the range of time shown, in nanoseconds since the
+	// start of the trace
+	from, span int64
+	// row is the index of the goroutine the cursor is on, and top the index
+	// of the first goroutine shown
+	row, top int
+	// col is the column of the lanes the cursor is on
+	col int
+
+	width, height int
+}
+
+// reset shows the whole trace, with the cursor on the first goroutine.
+func (v *tuiView) reset() {
+	v.from, v.span = 0, max(v.tl.Duration, 1)
+	v.row, v.top, v.col = 0, 0, 0
+}
+
+// laneWidth returns the number of columns of the lanes.
+func (v *tuiView) laneWidth() int {
+	return max(v.width-tuiLabelWidth-1, 1)
+}
+
+// laneRows returns the number of goroutines shown at once, which is what's
+// left of the screen after the header, the time axis and the panel.
+func (v *tuiView) laneRows() int {
+	return max(v.height-2-v.panelHeight(), 1)
+}
+
+func (v *tuiView) panelHeight() int {
+	return min(tuiPanelHeight, v.height/3)
+}
+
+// cell returns the range of time covered by the given column.
+func (v *tuiView) cell(col int) (start, end int64) {
+	w := int64(v.laneWidth())
+	return v.from + v.span*int64(col)/w, v.from + v.span*int64(col+1)/w
+}
+
+// key handles a key press, returning false if it means to quit.
+func (v *tuiView) key(k string) bool {
+	w := v.laneWidth()
+	switch k {
+	case "q", "\x03", "\x1b":
+		return false
+	case "\x1b[A", "k":
+		v.row--
+	case "\x1b[B", "j":
+		v.row++
+	case "\x1b[5~":
+		v.row -= v.laneRows()
+	case "\x1b[6~":
+		v.row += v.laneRows()
+	case "\x1b[D", "h":
+		v.col--
+	case "\x1b[C", "l":
+		v.col++
+	case "<", ",":
+		v.from -= v.span / 2
+	case ">", ".":
+		v.from += v.span / 2
+	case "+", "=", "i":
+		v.zoom(0.5)
+	case "-", "o":
+		v.zoom(2)
+	case "0":
+		v.reset()
+	}
+	// Moving the cursor past either edge of the lanes scrolls them.
+	if v.col < 0 {
+		v.from -= v.span * int64(-v.col) / int64(w)
+		v.col = 0
+	} else if v.col >= w {
+		v.from += v.span * int64(v.col-w+1) / int64(w)
+		v.col = w - 1
+	}
+	v.from = max(min(v.from, v.tl.Duration-v.span), 0)
+	v.row = max(min(v.row, len(v.tl.Goroutines)-1), 0)
+	if v.row < v.top {
+		v.top = v.row
+	} else if rows := v.laneRows(); v.row >= v.top+rows {
+		v.top = v.row - rows + 1
+	}
+	return true
+}
+
+// zoom scales the range of time shown by the factor, keeping the time under
+// the cursor where it is. It won't zoom in past a nanosecond per column or
+// out past the whole trace.
+func (v *tuiView) zoom(factor float64) {
+	w := int64(v.laneWidth())
+	at, _ := v.cell(v.col)
+	span := int64(float64(v.span) * factor)
+	v.span = max(min(span, max(v.tl.Duration, 1)), w)
+	v.from = at - v.span*int64(v.col)/w
+}
+
+// intervalAt returns the goroutine's interval with the most overlap with
+// the given range of time, or nil if there's none.
+func intervalAt(g *convert.TimelineGoroutine, start, end int64) *convert.TimelineInterval {
+	// the intervals are sorted by start and don't overlap
+	i := sort.Search(len(g.Intervals), func(i int) bool { return g.Intervals[i].End > start })
+	var best *convert.TimelineInterval
+	var most int64 = -1
+	for ; i < len(g.Intervals) && g.Intervals[i].Start < max(end, start+1); i++ {
+		in := &g.Intervals[i]
+		if overlap := min(in.End, end) - max(in.Start, start); overlap > most {
+			best, most = in, overlap
+		}
+	}
+	return best
+}
+
+// render draws the whole screen.
+func (v *tuiView) render() []byte {
+	b := new(bytes.Buffer)
+	b.WriteString("\x1b[H")
+	line := func(s string) {
+		b.WriteString(s)
+		b.WriteString("\x1b[0m\x1b[K\r\n")
+	}
+	w := v.laneWidth()
+	cursorStart, cursorEnd := v.cell(v.col)
+
+	line(fit(fmt.Sprintf("\x1b[1m%s\x1b[0m  %d goroutines  showing %v to %v of %v  cursor at %v",
+		v.tl.Start.Format(time.RFC3339), len(v.tl.Goroutines),
+		time.Duration(v.from), time.Duration(v.from+v.span), time.Duration(v.tl.Duration),
+		time.Duration(cursorStart)), v.width))
+
+	// The time axis marks every tuiAxisStep columns with its time, rounded
+	// to a power of ten which still tells the columns apart.
+	round := time.Duration(1)
+	for round*10 <= time.Duration(v.span/int64(w)) {
+		round *= 10
+	}
+	axis := []rune(strings.Repeat(" ", w))
+	for col := 0; col < w; col += tuiAxisStep {
+		t, _ := v.cell(col)
+		copy(axis[col:], []rune("|"+time.Duration(t).Round(round).String()))
+	}
+	line(fit(strings.Repeat(" ", tuiLabelWidth+1)+string(axis), v.width))
+
+	rows := v.laneRows()
+	for r := v.top; r < v.top+rows; r++ {
+		if r >= len(v.tl.Goroutines) {
+			line("")
+			continue
+		}
+		g := &v.tl.Goroutines[r]
+		label := fit(fmt.Sprintf("g%d %s", g.ID, g.Func), tuiLabelWidth)
+		if r == v.row {
+			label = "\x1b[1m" + label + "\x1b[0m"
+		}
+		b.WriteString(label + " ")
+		for col := 0; col < w; col++ {
+			start, end := v.cell(col)
+			if r == v.row && col == v.col {
+				b.WriteString("\x1b[7m")
+			}
+			if in := intervalAt(g, start, end); in != nil {
+				b.WriteString(tuiStates[in.State])
+			} else {
+				b.WriteString(" ")
+			}
+			b.WriteString("\x1b[0m")
+		}
+		line("")
+	}
+
+	// The panel shows the interval under the cursor, and its stack.
+	var panel []string
+	if len(v.tl.Goroutines) > 0 {
+		g := &v.tl.Goroutines[v.row]
+		if in := intervalAt(g, cursorStart, cursorEnd); in != nil {
+			desc := in.State
+			if in.Reason != "" {
+				desc += " (" + in.Reason + ")"
+			}
+			if in.P != nil {
+				desc += fmt.Sprintf(" on P %d", *in.P)
+			}
+			panel = append(panel, fmt.Sprintf("\x1b[1mg%d\x1b[0m %s from %v to %v, for %v",
+				g.ID, desc, time.Duration(in.Start), time.Duration(in.End), time.Duration(in.End-in.Start)))
+			for _, f := range v.tl.Stacks[in.Stack] {
+				panel = append(panel, "  "+f.Func, fmt.Sprintf("      %s:%d", f.File, f.Line))
+			}
+		} else {
+			panel = append(panel, fmt.Sprintf("\x1b[1mg%d\x1b[0m has no events here", g.ID))
+		}
+	}
+	help := "arrows/hjkl move  +/- zoom  </> pan  PgUp/PgDn scroll  0 reset  q quit"
+	for i := 0; i < v.panelHeight()-1; i++ {
+		if i < len(panel) {
+			line(fit(panel[i], v.width))
+		} else {
+			line("")
+		}
+	}
+	b.WriteString(fit("\x1b[2m"+help, v.width) + "\x1b[0m\x1b[K\x1b[J")
+	return b.Bytes()
+}
+
+// fit truncates or pads s to width columns. Escape sequences in s don't
+// count towards its width.
+func fit(s string, width int) string {
+	var b strings.Builder
+	n := 0
+	for i := 0; i < len(s); {
+		if s[i] == '\x1b' {
+			// copy the escape sequence, up to its final letter
+			j := i + 1
+			for j < len(s) && !(s[j] >= 'A' && s[j] <= 'Z' || s[j] >= 'a' && s[j] <= 'z') {
+				j++
+			}
+			b.WriteString(s[i:min(j+1, len(s))])
+			i = j + 1
+			continue
+		}
+		if n == width {
+			break
+		}
+		_, size := utf8.DecodeRuneInString(s[i:])
+		b.WriteString(s[i : i+size])
+		i += size
+		n++
+	}
+	b.WriteString(strings.Repeat(" ", width-n))
+	return b.String()
+}
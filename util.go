@@ -0,0 +1,59 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/nsrip-dd/trace2timeline/convert"
+)
+
+// utilFormats are the time series formats the util command can write.
+var utilFormats = map[string]func(convert.ParseResult, time.Time, time.Duration, io.Writer) error{
+	"prometheus": convert.ToPrometheusUtilization,
+	"influx":     convert.ToInfluxUtilization,
+}
+
+// runUtil implements the util command, which writes how busy the Ps were
+// over the course of an execution trace as a time series, to chart
+// alongside other metrics.
+func runUtil(args []string) error {
+	fs := flag.NewFlagSet("util", flag.ExitOnError)
+	input := fs.String("i", "", "execution trace `file`, or - for stdin")
+	output := fs.String("o", "-", "output `file`, or - for stdout")
+	binary := fs.String("binary", "", "binary which produced the trace (required for traces from Go 1.6 and below)")
+	interval := fs.Duration("interval", 10*time.Millisecond, "length of each `interval` of the time series")
+	format := fs.String("format", "prometheus", "output `format`: prometheus, for OpenMetrics text which promtool can backfill, or influx, for InfluxDB line protocol")
+	addLogFlags(fs)
+	fs.Parse(args)
+
+	if *input == "" && fs.NArg() == 1 {
+		*input = fs.Arg(0)
+	}
+	if *input == "" {
+		fs.Usage()
+		return usageError("-i is required")
+	}
+	write, ok := utilFormats[*format]
+	if !ok {
+		return usageError(fmt.Sprintf("unknown format %q", *format))
+	}
+	if *interval <= 0 {
+		return usageError("-interval must be positive")
+	}
+
+	res, start, err := parseFile(*input, *binary, nil)
+	if err != nil {
+		return err
+	}
+	out, err := createOutput(*output)
+	if err != nil {
+		return err
+	}
+	if err := write(res, start, *interval, out); err != nil {
+		discard(out)
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"log/slog"
+	"net"
+	"net/http"
+
+	"github.com/nsrip-dd/trace2timeline/convert"
+)
+
+// runView implements the view command, which serves a page drawing the
+// timeline of an execution trace, to look through it in a browser without
+// sending it anywhere. The page is convert.ToTimelineHTML's.
+func runView(args []string) error {
+	fs := flag.NewFlagSet("view", flag.ExitOnError)
+	input := fs.String("i", "", "execution trace `file`, or - for stdin")
+	binary := fs.String("binary", "", "binary which produced the trace (required for traces from Go 1.6 and below)")
+	addr := fs.String("addr", "localhost:0", "`address` to listen on; port 0 picks a free port")
+	addLogFlags(fs)
+	fs.Parse(args)
+
+	if *input == "" && fs.NArg() == 1 {
+		*input = fs.Arg(0)
+	}
+	if *input == "" {
+		fs.Usage()
+		return usageError("-i is required")
+	}
+
+	res, start, err := parseFile(*input, *binary, nil)
+	if err != nil {
+		return err
+	}
+	page := new(bytes.Buffer)
+	if err := convert.ToTimelineHTML(res, start, displayName(*input), page); err != nil {
+		return err
+	}
+	ln, err := net.Listen("tcp", *addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(page.Bytes())
+	})
+	slog.Info("serving the timeline", "url", "http://"+ln.Addr().String()+"/")
+	return http.Serve(ln, mux)
+}
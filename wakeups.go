@@ -0,0 +1,41 @@
+package main
+
+import (
+	"flag"
+
+	"github.com/nsrip-dd/trace2timeline/convert"
+)
+
+// runWakeups implements the wakeups command, which writes a Graphviz graph
+// of which goroutines unblocked which in an execution trace.
+func runWakeups(args []string) error {
+	fs := flag.NewFlagSet("wakeups", flag.ExitOnError)
+	input := fs.String("i", "", "execution trace `file`, or - for stdin")
+	output := fs.String("o", "-", "output `file`, or - for stdout")
+	binary := fs.String("binary", "", "binary which produced the trace (required for traces from Go 1.6 and below)")
+	perGoroutine := fs.Bool("goroutines", false, "draw each goroutine on its own rather than grouping them by the function they were started with")
+	addLogFlags(fs)
+	fs.Parse(args)
+
+	if *input == "" && fs.NArg() == 1 {
+		*input = fs.Arg(0)
+	}
+	if *input == "" {
+		fs.Usage()
+		return usageError("-i is required")
+	}
+
+	res, _, err := parseFile(*input, *binary, nil)
+	if err != nil {
+		return err
+	}
+	out, err := createOutput(*output)
+	if err != nil {
+		return err
+	}
+	if err := convert.ToWakeupDOT(res, !*perGoroutine, out); err != nil {
+		discard(out)
+		return err
+	}
+	return out.Close()
+}